@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	anthropicBaseURL      = "https://api.anthropic.com"
+	anthropicVersion      = "2023-06-01"
+	defaultAnthropicModel = "claude-sonnet-4-5"
+	// anthropicMaxTokens caps response length when max_tokens is not set,
+	// since the messages API requires a limit.
+	anthropicMaxTokens = 4096
+)
+
+// anthropicProvider talks to Anthropic's messages API. It has no notion of
+// several choices, penalties or seeds, so those settings are ignored and
+// every response is choice 0.
+type anthropicProvider struct {
+	apiKey  string
+	baseURL string
+	client  *http.Client
+}
+
+func newAnthropicProvider(p profile) (provider, error) {
+	baseURL := p.BaseURL
+	if baseURL == "" {
+		baseURL = anthropicBaseURL
+	}
+	return &anthropicProvider{
+		apiKey:  p.APIKey,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  http.DefaultClient,
+	}, nil
+}
+
+// anthropicError is an error response from the messages API.
+type anthropicError struct {
+	StatusCode int
+	Type       string
+	Message    string
+}
+
+func (e *anthropicError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("anthropic: %s: %s", e.Type, e.Message)
+	}
+	return fmt.Sprintf("anthropic: %d %s: %s", e.StatusCode, e.Type, e.Message)
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+// anthropicBlock is a block of a message's content: text, an image, a tool
+// call or a tool's result.
+type anthropicBlock struct {
+	Type      string                `json:"type"`
+	Text      string                `json:"text,omitempty"`
+	Source    *anthropicImageSource `json:"source,omitempty"`
+	ID        string                `json:"id,omitempty"`
+	Name      string                `json:"name,omitempty"`
+	Input     json.RawMessage       `json:"input,omitempty"`
+	ToolUseID string                `json:"tool_use_id,omitempty"`
+	Content   string                `json:"content,omitempty"`
+}
+
+// anthropicTool declares a tool the model may call.
+type anthropicTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"input_schema"`
+}
+
+type anthropicImageSource struct {
+	Type      string `json:"type"` // always "base64"
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+type anthropicRequest struct {
+	Model         string             `json:"model"`
+	System        string             `json:"system,omitempty"`
+	Messages      []anthropicMessage `json:"messages"`
+	MaxTokens     int64              `json:"max_tokens"`
+	Temperature   *float64           `json:"temperature,omitempty"`
+	TopP          *float64           `json:"top_p,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Tools         []anthropicTool    `json:"tools,omitempty"`
+	Thinking      *anthropicThinking `json:"thinking,omitempty"`
+	Stream        bool               `json:"stream"`
+}
+
+// anthropicThinking enables extended thinking, within a budget of tokens
+// counted towards max_tokens.
+type anthropicThinking struct {
+	Type         string `json:"type"` // always "enabled"
+	BudgetTokens int64  `json:"budget_tokens"`
+}
+
+// anthropicEvent is the union of the streamed events this client reads.
+type anthropicEvent struct {
+	Type  string `json:"type"`
+	Index int    `json:"index"`
+	Delta struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		Thinking    string `json:"thinking"`
+		PartialJSON string `json:"partial_json"`
+	} `json:"delta"`
+	// A tool call starts a content block of its own, its input following
+	// in deltas.
+	ContentBlock struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"content_block"`
+	// The prompt's usage is reported when the message starts, and the
+	// response's as it grows.
+	Message struct {
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message"`
+	Usage anthropicUsage `json:"usage"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int64 `json:"input_tokens"`
+	OutputTokens int64 `json:"output_tokens"`
+}
+
+func (p *anthropicProvider) stream(ctx context.Context, req request) (<-chan chunk, error) {
+	body, err := json.Marshal(anthropicParams(req))
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/v1/messages", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+	httpReq.Header.Set("X-Api-Key", p.apiKey)
+	httpReq.Header.Set("Anthropic-Version", anthropicVersion)
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, readAnthropicError(resp)
+	}
+
+	ch := make(chan chunk)
+	go func() {
+		defer close(ch)
+		defer resp.Body.Close()
+		var end chunk
+		var used usage
+		var calls []toolCall
+		callAt := make(map[int]int) // index of the call in each tool_use block
+		ended := false
+		err := readEvents(resp.Body, func(data []byte) bool {
+			var event anthropicEvent
+			if err := json.Unmarshal(data, &event); err != nil {
+				end, ended = chunk{err: fmt.Errorf("anthropic: malformed event: %w", err)}, true
+				return false
+			}
+			switch event.Type {
+			case "message_start":
+				used.prompt = event.Message.Usage.InputTokens
+			case "message_delta":
+				used.completion = event.Usage.OutputTokens
+			case "content_block_start":
+				if event.ContentBlock.Type == "tool_use" {
+					callAt[event.Index] = len(calls)
+					calls = append(calls, toolCall{id: event.ContentBlock.ID, name: event.ContentBlock.Name})
+				}
+			case "content_block_delta":
+				if event.Delta.Type == "text_delta" && event.Delta.Text != "" {
+					return sendChunk(ctx, ch, chunk{content: event.Delta.Text})
+				}
+				if event.Delta.Type == "thinking_delta" && event.Delta.Thinking != "" {
+					return sendChunk(ctx, ch, chunk{reasoning: event.Delta.Thinking})
+				}
+				if i, ok := callAt[event.Index]; ok && event.Delta.Type == "input_json_delta" {
+					calls[i].arguments += event.Delta.PartialJSON
+				}
+			case "error":
+				end, ended = chunk{err: &anthropicError{Type: event.Error.Type, Message: event.Error.Message}}, true
+				return false
+			case "message_stop":
+				if len(calls) > 0 && !sendChunk(ctx, ch, chunk{toolCalls: calls}) {
+					return false
+				}
+				end, ended = chunk{usage: &used}, true
+				return false
+			}
+			return true
+		})
+		if !ended {
+			if err == nil {
+				// The connection closed before the message was complete
+				err = io.ErrUnexpectedEOF
+			}
+			end = chunk{err: err}
+		}
+		if end.err != nil || end.usage != nil {
+			sendChunk(ctx, ch, end)
+		}
+	}()
+	return ch, nil
+}
+
+// anthropicParams translates a request into the messages API's format. System
+// messages are not part of the conversation there, so they are combined into
+// the separate system prompt, and consecutive turns from the same role are
+// merged since roles must alternate.
+func anthropicParams(req request) anthropicRequest {
+	params := anthropicRequest{
+		Model:         req.model,
+		MaxTokens:     anthropicMaxTokens,
+		Temperature:   req.temperature,
+		TopP:          req.topP,
+		StopSequences: req.stop,
+		Stream:        true,
+	}
+	if req.maxTokens != nil {
+		params.MaxTokens = *req.maxTokens
+	}
+	var system []string
+	for _, msg := range req.messages {
+		if msg.role == "system" {
+			system = append(system, msg.content)
+			continue
+		}
+		role := msg.role
+		var blocks []anthropicBlock
+		for _, img := range msg.images {
+			blocks = append(blocks, anthropicBlock{Type: "image", Source: &anthropicImageSource{Type: "base64", MediaType: img.mime, Data: img.base64()}})
+		}
+		if role == "tool" {
+			// Results are given in the user's turn
+			role = "user"
+			blocks = append(blocks, anthropicBlock{Type: "tool_result", ToolUseID: msg.toolCallID, Content: msg.content})
+		} else if msg.content != "" {
+			blocks = append(blocks, anthropicBlock{Type: "text", Text: msg.content})
+		}
+		for _, call := range msg.toolCalls {
+			input := json.RawMessage(call.arguments)
+			if !json.Valid(input) {
+				input = json.RawMessage("{}")
+			}
+			blocks = append(blocks, anthropicBlock{Type: "tool_use", ID: call.id, Name: call.name, Input: input})
+		}
+		// Empty turns are rejected
+		if len(blocks) == 0 {
+			continue
+		}
+		// Turns must alternate, so consecutive ones are joined
+		if n := len(params.Messages); n > 0 && params.Messages[n-1].Role == role {
+			params.Messages[n-1].Content = append(params.Messages[n-1].Content, blocks...)
+			continue
+		}
+		params.Messages = append(params.Messages, anthropicMessage{Role: role, Content: blocks})
+	}
+	if req.json {
+		// There is no JSON mode, so ask for it instead
+		prompt := jsonModePrompt
+		if req.schema != nil {
+			if schema, err := json.Marshal(req.schema.schema); err == nil {
+				prompt += " Follow this JSON schema: " + string(schema)
+			}
+		}
+		system = append(system, prompt)
+	}
+	params.System = strings.Join(system, "\n\n")
+	for _, t := range req.tools {
+		params.Tools = append(params.Tools, anthropicTool{Name: t.name, Description: t.description, InputSchema: t.parameters})
+	}
+	// Thinking before answering with the results of tools would need the
+	// signed thinking that led to the calls sent back, which is not kept, so
+	// those answers are given without
+	if req.reasoningEffort != "" && (len(req.messages) == 0 || req.messages[len(req.messages)-1].role != "tool") {
+		budget := thinkingBudget(req.reasoningEffort)
+		if req.maxTokens == nil {
+			// Leave the usual room for the answer after the thinking
+			params.MaxTokens += budget
+		} else {
+			budget = min(budget, params.MaxTokens-1)
+		}
+		params.Thinking = &anthropicThinking{Type: "enabled", BudgetTokens: budget}
+		// Sampling cannot be changed while thinking
+		params.Temperature, params.TopP = nil, nil
+	}
+	return params
+}
+
+// readAnthropicError reads the error a failed request was answered with.
+func readAnthropicError(resp *http.Response) error {
+	apiErr := &anthropicError{StatusCode: resp.StatusCode, Type: "error", Message: resp.Status}
+	var body struct {
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&body); err == nil && body.Error.Message != "" {
+		apiErr.Type = body.Error.Type
+		apiErr.Message = body.Error.Message
+	}
+	return apiErr
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxAttachmentSize caps the size of a file attached to a message. Larger
+// files are refused rather than cut, since half a file misleads more than it
+// helps.
+const maxAttachmentSize = 64 * 1024
+
+func runAttach(m *model, args string) tea.Cmd {
+	if args == "" {
+		m.notice = "Usage: /attach <file>"
+		return nil
+	}
+	if isImage(args) {
+		img, err := readImage(expandHome(args))
+		if err != nil {
+			m.notice = err.Error()
+			return nil
+		}
+		m.images = append(m.images, img)
+		m.notice = fmt.Sprintf("%s will be included in your next message, %s (Esc to drop it)", args, m.costOfTokens(imageTokens))
+		return nil
+	}
+	text, err := readAttachment(expandHome(args))
+	if err != nil {
+		m.notice = err.Error()
+		return nil
+	}
+	m.attach(args, text)
+	m.notice = fmt.Sprintf("%s will be included in your next message, %s (Esc to drop it)", args, m.estimateCost(message{content: text}))
+	return nil
+}
+
+// fileOpener opens files, either anywhere as the os package does or only
+// within an *os.Root.
+type fileOpener interface {
+	Stat(name string) (fs.FileInfo, error)
+	Open(name string) (*os.File, error)
+}
+
+// anyFile opens files anywhere.
+type anyFile struct{}
+
+func (anyFile) Stat(name string) (fs.FileInfo, error) { return os.Stat(name) }
+func (anyFile) Open(name string) (*os.File, error)    { return os.Open(name) }
+
+// readAttachment reads a text file to attach, checking it is not too large.
+func readAttachment(path string) (string, error) {
+	return readAttachmentFrom(anyFile{}, path)
+}
+
+// readAttachmentFrom reads a text file to attach with files, as
+// readAttachment does.
+func readAttachmentFrom(files fileOpener, path string) (string, error) {
+	info, err := files.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a file", path)
+	}
+	if info.Size() > maxAttachmentSize {
+		return "", fmt.Errorf("%s is %d KB, over the %d KB limit for attachments", path, info.Size()/1024, maxAttachmentSize/1024)
+	}
+	f, err := files.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(data) || strings.ContainsRune(string(data), 0) {
+		return "", fmt.Errorf("%s is not a text file", path)
+	}
+	return string(data), nil
+}
+
+// attach keeps a file's contents to be included in the next message, under a
+// header naming it and fenced with its extension as the language.
+func (m *model) attach(name, text string) {
+	fence := fenceFor(text)
+	lang := strings.TrimPrefix(filepath.Ext(name), ".")
+	if m.attachment != "" {
+		m.attachment += "\n\n"
+	}
+	m.attachment += fmt.Sprintf("File: %s\n%s%s\n%s\n%s", name, fence, lang, strings.TrimRight(text, "\n"), fence)
+}
+
+// fenceFor returns a code fence longer than any run of backticks in text, so
+// text can be fenced as it is.
+func fenceFor(text string) string {
+	fence := "```"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+	return fence
+}
+
+// attachMentions attaches every file named by an @path in input, and every
+// image whose path is in it, as when pasted. Words starting with @ that name
+// no file are left alone, as in an email address. Nothing is attached if any
+// of the files can't be.
+func (m *model) attachMentions(input string) error {
+	names := mentionedFiles(input)
+	texts := make([]string, len(names))
+	var images []attachedImage
+	for i, name := range names {
+		if isImage(name) {
+			img, err := readImage(expandHome(name))
+			if err != nil {
+				return err
+			}
+			images = append(images, img)
+			continue
+		}
+		text, err := readAttachment(expandHome(name))
+		if err != nil {
+			return err
+		}
+		texts[i] = text
+	}
+	for i, name := range names {
+		if !isImage(name) {
+			m.attach(name, texts[i])
+		}
+	}
+	m.images = append(m.images, images...)
+	return nil
+}
+
+// mentionedFiles returns the paths of the existing files input mentions as
+// @path, and of the images whose paths it contains, once each.
+func mentionedFiles(input string) []string {
+	var names []string
+	for _, word := range strings.Fields(input) {
+		name, ok := strings.CutPrefix(word, "@")
+		// Terminals quote paths dropped on them
+		if !ok {
+			name = strings.Trim(word, `'"`)
+		}
+		// Allow for punctuation after the path
+		name = strings.TrimRight(name, ",.;:!?)")
+		if name == "" || slices.Contains(names, name) || !ok && !isImage(name) {
+			continue
+		}
+		if info, err := os.Stat(expandHome(name)); err == nil && !info.IsDir() {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// mentions are the files mentioned in the input, with the tokens they will
+// add to the message estimated from their sizes. They are looked up when the
+// input changes, since the footer showing them is drawn far more often.
+type mentions struct {
+	names    []string
+	tokens   int64
+	tooLarge string // why one of the files can't be attached, if one can't
+}
+
+// mentionsIn looks up the files input mentions, none for a command.
+func mentionsIn(input string) mentions {
+	var found mentions
+	if strings.HasPrefix(input, "/") {
+		return found
+	}
+	found.names = mentionedFiles(input)
+	for _, name := range found.names {
+		info, err := os.Stat(expandHome(name))
+		switch {
+		case err != nil:
+		case isImage(name):
+			if info.Size() > maxImageSize {
+				found.tooLarge = fmt.Sprintf("%s is over the %d MB limit for images", name, maxImageSize>>20)
+				return found
+			}
+			found.tokens += imageTokens
+		case info.Size() > maxAttachmentSize:
+			found.tooLarge = fmt.Sprintf("%s is over the %d KB limit for attachments", name, maxAttachmentSize/1024)
+			return found
+		default:
+			found.tokens += info.Size() / 4
+		}
+	}
+	return found
+}
+
+// mentionPreview previews what the files mentioned in the input will add to
+// the message, or returns "" if it mentions none.
+func (m model) mentionPreview() string {
+	switch {
+	case m.mentions.tooLarge != "":
+		return m.mentions.tooLarge
+	case len(m.mentions.names) == 0:
+		return ""
+	}
+	return fmt.Sprintf("Attaching %s, %s", strings.Join(m.mentions.names, ", "), m.costOfTokens(m.mentions.tokens))
+}
+
+// estimateCost estimates the tokens a message adds to a request, and what
+// they cost with the current model if its price is known.
+func (m model) estimateCost(msg message) string {
+	return m.costOfTokens(int64(estimateTokens(msg)))
+}
+
+func (m model) costOfTokens(tokens int64) string {
+	s := fmt.Sprintf("~%d tokens", tokens)
+	if u := m.config.priceUsage(m.modelName, usage{prompt: tokens}); u.priced {
+		s += " (" + formatCost(u.cost) + ")"
+	}
+	return s
+}
+
+// completeFiles completes the path typed so far in the input.
+func completeFiles(m *model) []string {
+	_, typed, _ := strings.Cut(m.input.Value(), " ")
+	pattern := expandHome(typed)
+	if strings.HasSuffix(typed, "/") && !strings.HasSuffix(pattern, "/") {
+		// Expanding ~/ drops the slash
+		pattern += "/"
+	}
+	matches, err := filepath.Glob(pattern + "*")
+	if err != nil {
+		return nil
+	}
+	for i, match := range matches {
+		if info, err := os.Stat(match); err == nil && info.IsDir() {
+			match += string(filepath.Separator)
+		}
+		// Keep a typed ~/ as it was typed
+		if strings.HasPrefix(typed, "~/") {
+			if home, err := os.UserHomeDir(); err == nil {
+				match = "~" + strings.TrimPrefix(match, home)
+			}
+		}
+		matches[i] = match
+	}
+	return matches
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Editing a message, regenerating a response or branching from a selected
+// message forks the conversation: the path it was on is kept as a branch of
+// the session, which /branches and /switch go back to.
+
+// branchPreviewLength is how much of its first message of its own a branch is
+// described by.
+const branchPreviewLength = 50
+
+// fork keeps the conversation as it is as a branch, before the messages from
+// index at on are replaced, reporting whether it did. Nothing is kept if
+// those are all failed turns.
+func (m *model) fork(at int) bool {
+	if !slices.ContainsFunc(m.messages[at:], func(msg chatMessage) bool { return msg.err == nil }) {
+		return false
+	}
+	// Cloned, since the conversation goes on to reuse the array
+	m.branches = append(m.branches, slices.Clone(m.messages))
+	return true
+}
+
+// divergence returns the index of the first message where two paths differ.
+func divergence(a, b []chatMessage) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n].role == b[n].role && a[n].content == b[n].content {
+		n++
+	}
+	return n
+}
+
+// describeBranch summarizes branch i by where it leaves the conversation and
+// what it says there.
+func (m model) describeBranch(i int) (label, help string) {
+	b := m.branches[i]
+	at := divergence(b, m.messages)
+	label = strconv.Itoa(i + 1)
+	if at < len(b) {
+		preview := strings.Join(strings.Fields(b[at].content), " ")
+		if b[at].err != nil {
+			preview = "Error: " + b[at].err.Error()
+		}
+		if len([]rune(preview)) > branchPreviewLength {
+			preview = string([]rune(preview)[:branchPreviewLength]) + "…"
+		}
+		label += ": " + preview
+	}
+	return label, fmt.Sprintf("%d messages, differs from message %d", len(b), at+1)
+}
+
+func runBranches(m *model, args string) tea.Cmd {
+	if len(m.branches) == 0 {
+		m.notice = "The conversation has no other branches, editing or regenerating a message starts one"
+		return nil
+	}
+	m.palette = &palette{entries: branchEntries, noMatches: "No matching branches", verb: "switch"}
+	return nil
+}
+
+// branchEntries lists the conversation's other branches.
+func branchEntries(m model) []paletteEntry {
+	entries := make([]paletteEntry, len(m.branches))
+	for i := range m.branches {
+		label, help := m.describeBranch(i)
+		entries[i] = paletteEntry{label: label, help: help, run: func(m *model) tea.Cmd {
+			return m.switchBranch(i)
+		}}
+	}
+	return entries
+}
+
+func runSwitch(m *model, args string) tea.Cmd {
+	n, err := strconv.Atoi(args)
+	if err != nil || n < 1 || n > len(m.branches) {
+		if len(m.branches) == 0 {
+			m.notice = "The conversation has no other branches"
+		} else {
+			m.notice = fmt.Sprintf("Usage: /switch <1-%d>, /branches lists them", len(m.branches))
+		}
+		return nil
+	}
+	return m.switchBranch(n - 1)
+}
+
+func completeBranches(m *model) []string {
+	numbers := make([]string, len(m.branches))
+	for i := range m.branches {
+		numbers[i] = strconv.Itoa(i + 1)
+	}
+	return numbers
+}
+
+// switchBranch continues the conversation on branch i, which the path it was
+// on takes the place of, so switching back is switching to i again.
+func (m *model) switchBranch(i int) tea.Cmd {
+	if m.loading {
+		m.notice = "Please wait for the current response..."
+		return nil
+	}
+	m.messages, m.branches[i] = m.branches[i], m.messages
+	// The summary was of the other path
+	m.summary = ""
+	for j := range m.messages {
+		m.messages[j].compacted = false
+	}
+	m.selected = -1
+	m.editing = -1
+	m.firstVisible = 0
+	m.trimScrollback()
+	m.viewport.GotoBottom()
+	m.notice = fmt.Sprintf("Switched to branch %d, the previous path is now branch %d", i+1, i+1)
+	return m.autosave()
+}
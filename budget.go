@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// errOverBudget is why a response cancelled for running over budget stopped.
+var errOverBudget = errors.New("cancelled, over budget")
+
+// budget limits how long a response may run, and roughly how many tokens it
+// may produce, before the user is asked whether to cancel it. Reasoning
+// models in particular can think for a long and costly time. Zero values
+// mean no limit.
+type budget struct {
+	duration time.Duration
+	tokens   int
+}
+
+func (c config) budget() budget {
+	return budget{
+		duration: time.Duration(max(c.BudgetSeconds, 0)) * time.Second,
+		tokens:   max(c.BudgetTokens, 0),
+	}
+}
+
+// watchdog reports, once, when a streaming response goes over its budget.
+type watchdog struct {
+	budget budget
+	warn   sync.Once
+	report func(reason string)
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// watch starts a watchdog for a response streaming to streamChan. The timer
+// runs in its own goroutine so it fires even while no tokens arrive, as when
+// a model is reasoning. stop must be called before streamChan is closed.
+func (b budget) watch(ctx context.Context, streamChan chan<- streamEvent) *watchdog {
+	w := &watchdog{budget: b, stop: make(chan struct{})}
+	w.report = func(reason string) {
+		sendEvent(ctx, streamChan, streamEvent{overBudget: reason})
+	}
+	if b.duration > 0 {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			select {
+			case <-time.After(b.duration):
+				w.over(fmt.Sprintf("The response has run for over %s", b.duration))
+			case <-w.stop:
+			case <-ctx.Done():
+			}
+		}()
+	}
+	return w
+}
+
+// received checks the number of tokens received so far against the budget.
+func (w *watchdog) received(tokens int) {
+	if w.budget.tokens > 0 && tokens > w.budget.tokens {
+		w.over(fmt.Sprintf("The response is over %d tokens", w.budget.tokens))
+	}
+}
+
+func (w *watchdog) over(reason string) {
+	w.warn.Do(func() { w.report(reason) })
+}
+
+// close stops the timer, waiting for a report in progress to be delivered.
+func (w *watchdog) close() {
+	close(w.stop)
+	w.wg.Wait()
+}
+
+// confirmOverBudget asks whether to cancel a response that went over budget.
+// The question is tied to the request that raised it and dropped when that
+// ends, so it never outlives it.
+func (m *model) confirmOverBudget(reason string) {
+	if m.confirm != nil || m.prompt != nil {
+		m.notice = reason
+		return
+	}
+	c, done := m.conversation, m.streamDone
+	m.confirm = &confirmation{
+		prompt: reason + ". Cancel it?",
+		stream: done,
+		action: func(m *model) tea.Cmd {
+			if c.streamDone == done && c.cancelStream != nil {
+				c.cancelStream(errOverBudget)
+			}
+			return nil
+		},
+	}
+}
+
+// dropStreamConfirm drops the question about the conversation's response, if
+// one is open, as the response ends.
+func (m *model) dropStreamConfirm() {
+	if m.confirm != nil && m.confirm.stream != nil && m.confirm.stream == m.streamDone {
+		m.confirm = nil
+	}
+}
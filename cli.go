@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// usageText introduces the flags in llmtui -h.
+const usageText = `Usage:
+  llmtui [flags]                 chat in the terminal
+  llmtui [flags] "question"      answer without the TUI, also reading stdin
+  llmtui sessions                list the saved sessions
+  llmtui search <words>          find saved messages containing the words
+  llmtui export [--format json] <session>
+                                 print a saved session
+  llmtui import <file.json>      save an exported conversation as a session
+  llmtui index <folder>          index a folder for retrieval
+
+Flags:
+`
+
+func printUsage() {
+	fmt.Fprint(flag.CommandLine.Output(), usageText)
+	flag.PrintDefaults()
+}
+
+// cliOptions are the flags that change how the conversation starts, over
+// what the config and profile say.
+type cliOptions struct {
+	provider string
+	model    string
+	system   string
+	session  string
+	noStream bool
+}
+
+// apply sets up m as the flags ask. A model that failed or is being set up
+// is left alone, since there is no provider to change.
+func (o cliOptions) apply(m *model) error {
+	if m.err != nil || m.setup != nil {
+		return nil
+	}
+	if o.provider != "" && m.profile != "" {
+		// The profile's provider was connected; the flag wins over it
+		p, modelName, err := profile{Provider: o.provider}.withEnv().connect()
+		if err != nil {
+			return err
+		}
+		m.provider, m.modelName, m.profile = p, modelName, ""
+	}
+	if o.session != "" {
+		// name:N, as llmtui search lists matches, opens at message N
+		name, at := o.session, 0
+		s, err := loadSession(name)
+		if before, after, ok := cutLast(name, ":"); errors.Is(err, errSessionNotFound) && ok {
+			if n, convErr := strconv.Atoi(after); convErr == nil && n > 0 {
+				name, at = before, n
+				s, err = loadSession(name)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		m.restoreSession(name, s)
+		m.notice = "Opened session " + name
+		if at > 0 {
+			m.selectMessage(at - 1)
+		}
+		// The session was asked for, so skip the picker
+		m.sessions = nil
+	}
+	if o.model != "" {
+		m.modelName = o.model
+	}
+	if o.system != "" {
+		m.systemPrompt = o.system
+	}
+	if o.noStream {
+		m.config.NoStream = true
+	}
+	return nil
+}
+
+// runSubcommand runs the subcommand named by args[0], reporting false if
+// there is none.
+func runSubcommand(args []string, exportFormat string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+	switch args[0] {
+	case "sessions":
+		if len(args) != 1 {
+			return true, errUsage{errors.New("usage: llmtui sessions")}
+		}
+		return true, printSessions(os.Stdout)
+	case "search":
+		if len(args) < 2 {
+			return true, errUsage{errors.New("usage: llmtui search <words>")}
+		}
+		return true, printSearch(os.Stdout, strings.Join(args[1:], " "))
+	case "export":
+		fs := flag.NewFlagSet("export", flag.ContinueOnError)
+		format := fs.String("format", exportFormat, "the `format` to print, markdown or json")
+		if err := fs.Parse(args[1:]); err != nil {
+			return true, errUsage{err}
+		}
+		if fs.NArg() != 1 {
+			return true, errUsage{errors.New("usage: llmtui export [--format json] <session>")}
+		}
+		return true, exportSession(fs.Arg(0), *format)
+	case "index":
+		if len(args) != 2 {
+			return true, errUsage{errors.New("usage: llmtui index <folder>")}
+		}
+		return true, runIndex(args[1])
+	case "import":
+		if len(args) != 2 {
+			return true, errUsage{errors.New("usage: llmtui import <file.json>")}
+		}
+		name, err := importSession(args[1])
+		if err != nil {
+			return true, err
+		}
+		fmt.Println("Imported session " + name)
+		return true, nil
+	}
+	return false, nil
+}
+
+// printSessions lists the saved sessions, most recent first, a line each.
+func printSessions(w io.Writer) error {
+	summaries, err := listSessionSummaries("")
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tTITLE\tMODEL\tMESSAGES\tSAVED")
+	for _, s := range summaries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", s.name, s.title, s.model, s.messages, formatTimestamp(s.saved))
+	}
+	return tw.Flush()
+}
+
+// cutLast slices s around the last instance of sep.
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
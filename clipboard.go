@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/atotto/clipboard"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/muesli/termenv"
+)
+
+// writeClipboard copies text to the system clipboard, falling back to the
+// terminal's (OSC 52) where there is no native clipboard to reach, such as
+// over SSH or without a clipboard tool on Linux. It describes where the text
+// went, for the notice.
+func writeClipboard(text string) string {
+	// Over SSH the native clipboard is the remote machine's
+	if os.Getenv("SSH_TTY") == "" && os.Getenv("SSH_CONNECTION") == "" {
+		if err := clipboard.WriteAll(text); err == nil {
+			return "the clipboard"
+		}
+	}
+	termenv.Copy(text)
+	return "the clipboard through the terminal"
+}
+
+// copyLastResponse copies the most recent response, as the raw markdown the
+// model sent.
+func (m *model) copyLastResponse() {
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if msg := m.messages[i]; msg.role == "assistant" && msg.err == nil {
+			m.notice = "Copied the last response to " + writeClipboard(msg.content)
+			return
+		}
+	}
+	m.notice = "No response to copy"
+}
+
+// copyMessage copies the target message to the clipboard, either as the raw
+// markdown the model sent or as the plain text shown on screen.
+func (m *model) copyMessage(raw bool) {
+	i := m.targetMessage()
+	if i < 0 {
+		m.notice = "No message to copy"
+		return
+	}
+	msg := m.messages[i]
+	if msg.err != nil {
+		m.notice = "Failed turns cannot be copied"
+		return
+	}
+
+	text, variant := msg.content, "raw markdown"
+	if !raw {
+		text, variant = m.plainText(msg), "plain text"
+	}
+	m.notice = "Copied " + variant + " to " + writeClipboard(text)
+}
+
+// plainText returns a message as it reads on screen, without the styling or
+// the margins and padding of rendered markdown.
+func (m model) plainText(msg chatMessage) string {
+	content := formattedContent(msg)
+	if msg.role == "assistant" && !msg.raw && !msg.json {
+		content = renderMarkdown(content, markdownWidth(m.width))
+	}
+	lines := strings.Split(ansi.Strip(content), "\n")
+	indent := -1
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+		if lines[i] != "" {
+			n := len(lines[i]) - len(strings.TrimLeft(lines[i], " "))
+			if indent < 0 || n < indent {
+				indent = n
+			}
+		}
+	}
+	for i, line := range lines {
+		if len(line) >= indent && indent > 0 {
+			lines[i] = line[indent:]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
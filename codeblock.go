@@ -0,0 +1,115 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters"
+	"github.com/alecthomas/chroma/v2/lexers"
+	chromastyles "github.com/alecthomas/chroma/v2/styles"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/muesli/termenv"
+)
+
+// markdownPart is a run of prose, or a fenced code block with its language,
+// in a response.
+type markdownPart struct {
+	code bool
+	lang string
+	text string
+}
+
+// fenceOpen matches the line opening a code fence: three or more backticks or
+// tildes, indented by at most three spaces, then the language.
+var fenceOpen = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})\\s*([^`\\s]*)")
+
+// splitFences separates the fenced code blocks of a markdown text from the
+// prose around them. A fence left open, as in a response still streaming,
+// runs to the end of the text.
+func splitFences(text string) []markdownPart {
+	var parts []markdownPart
+	var prose []string
+	flush := func() {
+		if s := strings.Trim(strings.Join(prose, "\n"), "\n"); s != "" {
+			parts = append(parts, markdownPart{text: s})
+		}
+		prose = nil
+	}
+
+	lines := strings.Split(text, "\n")
+	for i := 0; i < len(lines); i++ {
+		open := fenceOpen.FindStringSubmatch(lines[i])
+		if open == nil {
+			prose = append(prose, lines[i])
+			continue
+		}
+		flush()
+		fence := open[1]
+		var code []string
+		for i++; i < len(lines); i++ {
+			if closing := strings.TrimSpace(lines[i]); strings.HasPrefix(closing, fence) && strings.Trim(closing, fence[:1]) == "" {
+				break
+			}
+			code = append(code, lines[i])
+		}
+		parts = append(parts, markdownPart{code: true, lang: open[2], text: strings.Join(code, "\n")})
+	}
+	flush()
+	return parts
+}
+
+// codeStyle is the chroma style code is highlighted in, matched to
+// markdownStyle by detectMarkdownStyle. An empty style leaves code plain.
+var codeStyle = "monokai"
+
+var codeBoxStyle = lipgloss.NewStyle().
+	Border(lipgloss.RoundedBorder()).
+	BorderForeground(lipgloss.Color("#626262")).
+	Padding(0, 1).
+	MarginLeft(2)
+
+// renderCode highlights code in the given language and boxes it to stand out
+// from the prose around it. Lines too long for width columns are wrapped
+// inside the box.
+func renderCode(lang, code string, width int) string {
+	code = strings.ReplaceAll(code, "\t", "    ")
+	highlighted := highlight(lang, code)
+	inner := max(width-codeBoxStyle.GetHorizontalFrameSize(), 1)
+	box := codeBoxStyle.Render(ansi.Hardwrap(highlighted, inner, true))
+	if lang == "" {
+		return box
+	}
+	return "  " + helpStyle.Render(lang) + "\n" + box
+}
+
+// highlight colours code for the terminal, falling back to plain text when
+// the terminal has no colours or the code cannot be tokenised.
+func highlight(lang, code string) string {
+	if codeStyle == "" {
+		return code
+	}
+	lexer := lexers.Get(lang)
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+	if err != nil {
+		return code
+	}
+	var formatter chroma.Formatter
+	switch lipgloss.ColorProfile() {
+	case termenv.TrueColor:
+		formatter = formatters.TTY16m
+	case termenv.ANSI256:
+		formatter = formatters.TTY256
+	default:
+		formatter = formatters.TTY16
+	}
+	var b strings.Builder
+	if err := formatter.Format(&b, chromastyles.Get(codeStyle), iterator); err != nil {
+		return code
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
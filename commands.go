@@ -0,0 +1,825 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// command is a slash command that can be typed into the input.
+type command struct {
+	name string
+	// aliases are other names the command can be typed as.
+	aliases []string
+	usage   string
+	help    string
+	// group is the category the command is listed under on the help screen.
+	group string
+	run   func(m *model, args string) tea.Cmd
+	// complete lists candidate values for the command's argument, if it
+	// takes one that can be completed.
+	complete func(m *model) []string
+}
+
+// commands is the registry of slash commands. It is populated in init because
+// some commands refer back to the registry.
+var commands []command
+
+func init() {
+	commands = []command{
+		{
+			name:  "help",
+			usage: "/help",
+			help:  "Show all keys and commands",
+			group: "General",
+			run:   func(m *model, args string) tea.Cmd { return openHelp(m) },
+		},
+		{
+			name:    "quit",
+			aliases: []string{"exit"},
+			usage:   "/quit",
+			help:    "Quit (also /exit)",
+			group:   "General",
+			run:     func(m *model, args string) tea.Cmd { return m.quit() },
+		},
+		{
+			name:  "search",
+			usage: "/search [words]",
+			help:  "Find messages in every saved session and open one there",
+			group: "Saving",
+			run:   runSearch,
+		},
+		{
+			name:     "vim",
+			usage:    "/vim [on|off]",
+			help:     "Toggle vim-style keys, with normal, insert and visual modes",
+			group:    "General",
+			run:      runVim,
+			complete: completeOnOff,
+		},
+		{
+			name:  "clear",
+			usage: "/clear",
+			help:  "Start a new conversation",
+			group: "Conversation",
+			run:   runClear,
+		},
+		{
+			name:  "save",
+			usage: "/save [name]",
+			help:  "Save the conversation as a session",
+			group: "Saving",
+			run:   runSave,
+		},
+		{
+			name:     "load",
+			usage:    "/load <name>",
+			help:     "Replace the conversation with a saved session",
+			group:    "Saving",
+			run:      runLoad,
+			complete: completeSessions,
+		},
+		{
+			name:  "export",
+			usage: "/export [file.md]",
+			help:  "Write the conversation to a markdown file",
+			group: "Saving",
+			run:   runExport,
+		},
+		{
+			name:  "sessions",
+			usage: "/sessions",
+			help:  "List saved sessions, most recent first",
+			group: "Saving",
+			run:   runSessions,
+		},
+		{
+			name:  "branch",
+			usage: "/branch [--save]",
+			help:  "Continue from the selected message, keeping the messages after it as a branch",
+			group: "Conversation",
+			run:   runBranch,
+		},
+		{
+			name:  "branches",
+			usage: "/branches",
+			help:  "List the conversation's other branches to switch to",
+			group: "Conversation",
+			run:   runBranches,
+		},
+		{
+			name:     "switch",
+			usage:    "/switch <n>",
+			help:     "Continue the conversation on another branch",
+			group:    "Conversation",
+			run:      runSwitch,
+			complete: completeBranches,
+		},
+		{
+			name:  "templates",
+			usage: "/templates",
+			help:  "List saved prompt templates",
+			group: "Saving",
+			run:   runTemplates,
+		},
+		{
+			name:     "use",
+			usage:    "/use <template>",
+			help:     "Put a saved prompt template in the input",
+			group:    "Saving",
+			run:      runUse,
+			complete: completeTemplates,
+		},
+		{
+			name:     "prompt",
+			usage:    "/prompt [template] [input]",
+			help:     "Fill in a saved prompt template's placeholders and put it in the input",
+			group:    "Saving",
+			run:      runPrompt,
+			complete: completeTemplates,
+		},
+		{
+			name:  "choices",
+			usage: "/choices [n]",
+			help:  "Show or set how many candidate responses to generate",
+			group: "Generation",
+			run:   runChoices,
+		},
+		{
+			name:     "compact",
+			usage:    "/compact [off]",
+			help:     "Summarize older messages to save context, or send them in full again",
+			group:    "Conversation",
+			run:      runCompact,
+			complete: completeOff,
+		},
+		{
+			name:  "title",
+			usage: "/title [text]",
+			help:  "Show or set the conversation title",
+			group: "Conversation",
+			run:   runTitle,
+		},
+		{
+			name:     "model",
+			usage:    "/model [name]",
+			help:     "Switch the model, picking from a list without a name",
+			group:    "Models",
+			run:      runModel,
+			complete: completeModels,
+		},
+		{
+			name:     "stop",
+			usage:    `/stop ["seq" ...|off]`,
+			help:     "Show, set or clear the sequences that stop generation",
+			group:    "Generation",
+			run:      runStop,
+			complete: completeOff,
+		},
+		{
+			name:     "json",
+			usage:    "/json [on|off|schema <file>|schema off]",
+			help:     "Toggle JSON responses, optionally following a JSON schema",
+			group:    "Generation",
+			run:      runJSON,
+			complete: completeJSON,
+		},
+		{
+			name:     "set",
+			usage:    "/set [setting] [value|off]",
+			help:     "Show or change temperature, top_p, max_tokens, the penalties or the seed",
+			group:    "Generation",
+			run:      runSet,
+			complete: completeSet,
+		},
+		{
+			name:     "penalty",
+			usage:    "/penalty [presence|frequency] [value|off]",
+			help:     "Show or set the presence and frequency penalties (-2.0 to 2.0)",
+			group:    "Generation",
+			run:      runPenalty,
+			complete: completePenalty,
+		},
+		{
+			name:     "seed",
+			usage:    "/seed [n|off]",
+			help:     "Show, set or clear the seed for repeatable responses",
+			group:    "Generation",
+			run:      runSeed,
+			complete: completeOff,
+		},
+		{
+			name:     "system",
+			usage:    "/system [text|off]",
+			help:     "Edit, set or clear the system prompt for this conversation",
+			group:    "Conversation",
+			run:      runSystem,
+			complete: completeOff,
+		},
+		{
+			name:  "code",
+			usage: "/code [all|<n> [file]]",
+			help:  "Pick a code block from the response to copy, or copy or save block n",
+			group: "Messages",
+			run:   runCode,
+		},
+		{
+			name:  "run",
+			usage: "/run <command>",
+			help:  "Run a shell command and include its output in the next message",
+			group: "Conversation",
+			run:   runRun,
+		},
+		{
+			name:  "tools",
+			usage: "/tools",
+			help:  "List the tools the model can call, and enable or disable them",
+			group: "Models",
+			run:   runTools,
+		},
+		{
+			name:     "memory",
+			usage:    "/memory [add <text>|forget <id>|edit|clear]",
+			help:     "List, add, edit or forget what is remembered across conversations",
+			group:    "Models",
+			run:      runMemory,
+			complete: completeMemory,
+		},
+		{
+			name:     "rag",
+			usage:    "/rag [on|off]",
+			help:     "Show or toggle searching the documents indexed with llmtui index",
+			group:    "Models",
+			run:      runRAG,
+			complete: completeOnOff,
+		},
+		{
+			name:  "mcp",
+			usage: "/mcp",
+			help:  "List the MCP servers and their tools, and reconnect to one",
+			group: "Models",
+			run:   runMCP,
+		},
+		{
+			name:  "fetch",
+			usage: "/fetch <url>",
+			help:  "Include the readable text of a web page in your next message",
+			group: "Conversation",
+			run:   runFetch,
+		},
+		{
+			name:     "attach",
+			usage:    "/attach <file>",
+			help:     "Include a file in the next message, as @file in a message also does",
+			group:    "Conversation",
+			run:      runAttach,
+			complete: completeFiles,
+		},
+		{
+			name:     "retry",
+			usage:    "/retry [model] [setting=value ...] [--keep]",
+			help:     "Regenerate the last response, optionally with another model or settings",
+			group:    "Models",
+			run:      runRetry,
+			complete: completeModels,
+		},
+		{
+			name:  "models",
+			usage: "/models",
+			help:  "List the models the provider offers",
+			group: "Models",
+			run:   runModels,
+		},
+		{
+			name:  "compare",
+			usage: `/compare <model>,<model>[,...] ["prompt"]|off`,
+			help:  "Send a prompt, or every message until off, to several models at once and keep one answer",
+			group: "Models",
+			run:   runCompare,
+		},
+		{
+			name:     "profile",
+			usage:    "/profile [name] [--new]",
+			help:     "List profiles or switch to one, optionally starting a new conversation",
+			group:    "Models",
+			run:      runProfile,
+			complete: completeProfiles,
+		},
+	}
+}
+
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name || slices.Contains(c.aliases, name) {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+// openModelPicker lists the models to switch to, fetching the provider's
+// list if it has not been yet. A name that is not listed can be typed in.
+func openModelPicker(m *model) tea.Cmd {
+	m.palette = &palette{
+		entries:   modelEntries,
+		fallback:  runModel,
+		noMatches: "No matching models, Enter switches to the name typed",
+		verb:      "switch",
+	}
+	if m.providerModels == nil {
+		return m.listModels(true)
+	}
+	return nil
+}
+
+func openProfilePicker(m *model) tea.Cmd {
+	if len(m.config.Profiles) == 0 {
+		m.notice = "No profiles configured"
+		return nil
+	}
+	m.palette = &palette{entries: profileEntries, noMatches: "No matching profiles", verb: "switch"}
+	return nil
+}
+
+// profileEntries lists the profiles with what sets them apart: their model,
+// temperature and system prompt.
+func profileEntries(m model) []paletteEntry {
+	var entries []paletteEntry
+	for _, name := range m.config.profileNames() {
+		p := m.config.Profiles[name]
+		var details []string
+		if name == m.profile {
+			details = append(details, "current")
+		}
+		if p.Model != "" {
+			details = append(details, p.Model)
+		}
+		if p.Temperature != nil {
+			details = append(details, "temperature "+formatPenalty(p.Temperature))
+		}
+		if p.SystemPrompt != "" {
+			details = append(details, strings.Join(strings.Fields(p.SystemPrompt), " "))
+		}
+		entries = append(entries, paletteEntry{label: name, help: strings.Join(details, " · "), run: func(m *model) tea.Cmd {
+			return runProfile(m, name)
+		}})
+	}
+	return entries
+}
+
+// modelEntries lists the models known from the provider, the recently used
+// models and the profiles.
+func modelEntries(m model) []paletteEntry {
+	var entries []paletteEntry
+	for _, name := range completeModels(&m) {
+		var help string
+		switch {
+		case name == m.modelName:
+			help = "current"
+		case slices.Contains(m.recentModels, name):
+			help = "recent"
+		}
+		entries = append(entries, paletteEntry{label: name, help: help, run: func(m *model) tea.Cmd {
+			return runModel(m, name)
+		}})
+	}
+	return entries
+}
+
+// runCommand executes a line of input starting with "/".
+func (m *model) runCommand(input string) tea.Cmd {
+	name, args, _ := strings.Cut(strings.TrimPrefix(input, "/"), " ")
+	c, ok := findCommand(name)
+	if !ok {
+		m.notice = fmt.Sprintf("Unknown command /%s", name)
+		return nil
+	}
+	return c.run(m, strings.TrimSpace(args))
+}
+
+func runProfile(m *model, args string) tea.Cmd {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return openProfilePicker(m)
+	}
+	if m.loading {
+		m.notice = "Please wait for the current response..."
+		return nil
+	}
+
+	name := fields[0]
+	switchProfile := func(m *model) tea.Cmd {
+		if err := m.useProfile(name); err != nil {
+			m.notice = err.Error()
+			return nil
+		}
+		m.rememberModel()
+		m.notice = fmt.Sprintf("Switched to profile %s (%s)", m.profile, m.modelName)
+		return m.listModels(true)
+	}
+	if len(fields) > 1 && fields[1] == "--new" {
+		return m.confirmDiscard("Start a new conversation?", func(m *model) tea.Cmd {
+			m.resetConversation()
+			return switchProfile(m)
+		})
+	}
+	return switchProfile(m)
+}
+
+func runClear(m *model, args string) tea.Cmd {
+	if m.loading {
+		m.notice = "Please wait for the current response..."
+		return nil
+	}
+	return m.confirmDiscard("Clear the conversation?", func(m *model) tea.Cmd {
+		m.resetConversation()
+		m.notice = "Conversation cleared"
+		return nil
+	})
+}
+
+func runSave(m *model, args string) tea.Cmd {
+	name := args
+	if name == "" {
+		name = defaultSessionName(m.title)
+	}
+	if err := saveSession(name, m.session()); err != nil {
+		m.notice = err.Error()
+		return nil
+	}
+	// Later turns are saved there too
+	m.sessionName = name
+	m.notice = "Saved session " + name
+	return nil
+}
+
+func runLoad(m *model, args string) tea.Cmd {
+	if args == "" {
+		m.notice = "Usage: /load <name>"
+		return nil
+	}
+	if m.loading {
+		m.notice = "Please wait for the current response..."
+		return nil
+	}
+	s, err := loadSession(args)
+	if err != nil {
+		m.notice = err.Error()
+		return nil
+	}
+	return m.confirmDiscard(fmt.Sprintf("Replace the conversation with session %s?", args), func(m *model) tea.Cmd {
+		m.restoreSession(args, s)
+		m.notice = "Loaded session " + args
+		return nil
+	})
+}
+
+func runSessions(m *model, args string) tea.Cmd {
+	names, err := recentSessions()
+	if err != nil {
+		m.notice = err.Error()
+		return nil
+	}
+	if len(names) == 0 {
+		m.notice = "No saved sessions"
+		return nil
+	}
+	m.notice = "Sessions: " + strings.Join(names, ", ")
+	return nil
+}
+
+func runBranch(m *model, args string) tea.Cmd {
+	if m.selected < 0 {
+		m.notice = "Select the message to branch from first"
+		return nil
+	}
+	if m.loading {
+		m.notice = "Please wait for the current response..."
+		return nil
+	}
+	keep := m.selected + 1
+	if keep == len(m.messages) {
+		m.notice = "Nothing to discard after the selected message"
+		return nil
+	}
+
+	var saved string
+	if args == "--save" {
+		// Numbered rather than overwriting the branch saved last time
+		saved = unusedSessionName(defaultSessionName(m.title) + "-branch")
+		if err := saveSession(saved, m.session()); err != nil {
+			m.notice = err.Error()
+			return nil
+		}
+	}
+
+	discarded := len(m.messages) - keep
+	forked := m.fork(keep)
+	m.messages = m.messages[:keep]
+	m.editing = -1
+	m.messages[keep-1].discarded += discarded
+	m.selected = -1
+	if forked {
+		m.notice = fmt.Sprintf("Branched, the %d later messages are kept as branch %d", discarded, len(m.branches))
+	} else {
+		m.notice = fmt.Sprintf("Branched, discarding %d messages", discarded)
+	}
+	if saved != "" {
+		m.notice += ". Previous branch saved as session " + saved
+	}
+	return nil
+}
+
+func runTemplates(m *model, args string) tea.Cmd {
+	names, err := listTemplates()
+	if err != nil {
+		m.notice = err.Error()
+		return nil
+	}
+	if len(names) == 0 {
+		m.notice = "No templates saved, press Ctrl+S to save the input as one"
+		return nil
+	}
+	m.notice = "Templates: " + strings.Join(names, ", ")
+	return nil
+}
+
+func runUse(m *model, args string) tea.Cmd {
+	if args == "" {
+		m.notice = "Usage: /use <template>"
+		return nil
+	}
+	content, err := loadTemplate(args)
+	if err != nil {
+		m.notice = err.Error()
+		return nil
+	}
+	m.setInput(content)
+	return nil
+}
+
+func runChoices(m *model, args string) tea.Cmd {
+	if args == "" {
+		m.notice = fmt.Sprintf("Generating %d choice(s) per response", max(m.config.Choices, 1))
+		return nil
+	}
+	n, err := strconv.Atoi(args)
+	if err != nil || n < 1 || n > 9 {
+		m.notice = "Choices must be a number from 1 to 9"
+		return nil
+	}
+	m.config.Choices = n
+	m.notice = fmt.Sprintf("Generating %d choice(s) per response", n)
+	return nil
+}
+
+func runTitle(m *model, args string) tea.Cmd {
+	if args == "" {
+		if m.title == "" {
+			m.notice = "The conversation has no title yet"
+		} else {
+			m.notice = "Title: " + m.title
+		}
+		return nil
+	}
+	m.title = args
+	m.notice = "Title set to " + args
+	return nil
+}
+
+func runModel(m *model, args string) tea.Cmd {
+	if args == "" {
+		return openModelPicker(m)
+	}
+	m.modelName = args
+	m.rememberModel()
+	m.notice = "Switched to model " + args
+	return nil
+}
+
+func runModels(m *model, args string) tea.Cmd {
+	cmd := m.listModels(false)
+	if cmd == nil {
+		m.notice = "This provider cannot list its models"
+		return nil
+	}
+	m.notice = "Listing models..."
+	return cmd
+}
+
+func runStop(m *model, args string) tea.Cmd {
+	switch args {
+	case "":
+		if len(m.config.Stop) == 0 {
+			m.notice = "No stop sequences set"
+		} else {
+			m.notice = "Stop sequences: " + formatStopSequences(m.config.Stop)
+		}
+		return nil
+	case "off":
+		m.config.Stop = nil
+		m.notice = "Stop sequences cleared"
+		return nil
+	}
+	stops, err := parseStopSequences(args)
+	if err != nil {
+		m.notice = err.Error()
+		return nil
+	}
+	m.config.Stop = stops
+	m.notice = "Stop sequences: " + formatStopSequences(stops)
+	return nil
+}
+
+func runJSON(m *model, args string) tea.Cmd {
+	sub, rest, _ := strings.Cut(args, " ")
+	switch sub {
+	case "":
+		m.config.JSONMode = !m.config.JSONMode
+	case "on":
+		m.config.JSONMode = true
+	case "off":
+		m.config.JSONMode = false
+	case "schema":
+		rest = strings.TrimSpace(rest)
+		if rest == "" || rest == "off" {
+			m.jsonSchema = nil
+			m.config.JSONSchema = ""
+			m.notice = "JSON schema cleared"
+			return nil
+		}
+		schema, err := loadJSONSchema(rest)
+		if err != nil {
+			m.notice = err.Error()
+			return nil
+		}
+		m.jsonSchema = schema
+		m.config.JSONSchema = rest
+		m.config.JSONMode = true
+	default:
+		m.notice = "Usage: /json [on|off|schema <file>|schema off]"
+		return nil
+	}
+
+	if !m.config.JSONMode {
+		m.notice = "JSON mode off"
+		return nil
+	}
+	m.notice = "JSON mode on"
+	if m.jsonSchema != nil {
+		m.notice += " with schema " + m.jsonSchema.name
+	}
+	if !supportsJSONMode(m.modelName) {
+		m.notice += fmt.Sprintf(". Warning: %s may not support JSON mode", m.modelName)
+	}
+	return nil
+}
+
+func runPenalty(m *model, args string) tea.Cmd {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		m.notice = fmt.Sprintf("Presence penalty: %s, frequency penalty: %s",
+			formatPenalty(m.config.PresencePenalty), formatPenalty(m.config.FrequencyPenalty))
+		return nil
+	}
+	var target **float64
+	switch fields[0] {
+	case "presence":
+		target = &m.config.PresencePenalty
+	case "frequency":
+		target = &m.config.FrequencyPenalty
+	default:
+		m.notice = "Usage: /penalty [presence|frequency] [value|off]"
+		return nil
+	}
+	switch {
+	case len(fields) == 1:
+	case fields[1] == "off":
+		*target = nil
+	default:
+		p, err := parsePenalty(fields[1])
+		if err != nil {
+			m.notice = err.Error()
+			return nil
+		}
+		*target = &p
+	}
+	m.notice = fmt.Sprintf("%s penalty: %s", strings.ToUpper(fields[0][:1])+fields[0][1:], formatPenalty(*target))
+	return nil
+}
+
+func runSet(m *model, args string) tea.Cmd {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		values := make([]string, len(samplingSettings))
+		for i, s := range samplingSettings {
+			values[i] = s.name + " " + s.get(m.config)
+		}
+		m.notice = strings.Join(values, ", ")
+		return nil
+	}
+	s, ok := findSamplingSetting(fields[0])
+	if !ok || len(fields) > 2 {
+		m.notice = "Usage: /set [setting] [value|off]"
+		return nil
+	}
+	if len(fields) == 2 {
+		if err := s.set(&m.config, fields[1]); err != nil {
+			m.notice = err.Error()
+			return nil
+		}
+	}
+	m.notice = s.name + " " + s.get(m.config)
+	return nil
+}
+
+func runSeed(m *model, args string) tea.Cmd {
+	switch args {
+	case "":
+		if m.config.Seed == nil {
+			m.notice = "No seed set"
+		} else {
+			m.notice = fmt.Sprintf("Seed: %d", *m.config.Seed)
+		}
+		return nil
+	case "off":
+		m.config.Seed = nil
+		m.notice = "Seed cleared"
+		return nil
+	}
+	seed, err := strconv.ParseInt(args, 10, 64)
+	if err != nil {
+		m.notice = "Seed must be a whole number"
+		return nil
+	}
+	m.config.Seed = &seed
+	m.notice = fmt.Sprintf("Seed: %d", seed)
+	return nil
+}
+
+func runSystem(m *model, args string) tea.Cmd {
+	switch args {
+	case "":
+		// Put the prompt in the input to be edited and sent back
+		m.setInput("/system " + m.systemPrompt)
+		if m.systemPrompt == "" {
+			m.notice = "No system prompt set, type one after /system"
+		}
+		return nil
+	case "off":
+		m.systemPrompt = ""
+		m.notice = "System prompt cleared"
+		return nil
+	}
+	m.systemPrompt = args
+	m.notice = "System prompt set for this conversation"
+	return nil
+}
+
+func runRetry(m *model, args string) tea.Cmd {
+	var modelName string
+	keep := false
+	var settings *config
+	for _, field := range strings.Fields(args) {
+		name, value, isSetting := strings.Cut(field, "=")
+		switch {
+		case field == "--keep":
+			keep = true
+		case isSetting:
+			s, ok := findSamplingSetting(name)
+			if !ok {
+				m.notice = fmt.Sprintf("Unknown setting %q", name)
+				return nil
+			}
+			if settings == nil {
+				c := m.config
+				settings = &c
+			}
+			if err := s.set(settings, value); err != nil {
+				m.notice = err.Error()
+				return nil
+			}
+		case modelName == "":
+			modelName = field
+		default:
+			m.notice = "Usage: /retry [model] [setting=value ...] [--keep]"
+			return nil
+		}
+	}
+	if m.loading {
+		m.notice = "Please wait for the current response..."
+		return nil
+	}
+	if keep {
+		if modelName == "" {
+			m.notice = "Name the model to keep"
+			return nil
+		}
+		m.modelName = modelName
+		m.rememberModel()
+	}
+	return m.regenerate(modelName, settings)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const compactPrompt = "Summarize the conversation below so it can continue without the original. " +
+	"Keep every fact, decision, name, number and piece of code that later turns may rely on, " +
+	"and note what the user is trying to do. Reply with the summary only."
+
+// summaryPrefix introduces the summary where it replaces the messages in a
+// request.
+const summaryPrefix = "Summary of the earlier conversation:\n\n"
+
+// compactKeep is how many of the latest messages are left out of a summary,
+// so the exchange in progress is still sent word for word.
+const compactKeep = 4
+
+// compactTimeout bounds the summary request, which reads the whole history.
+const compactTimeout = 2 * time.Minute
+
+// autoCompactShare is how much of the context window a conversation may
+// fill before auto_compact summarizes it.
+const autoCompactShare = 0.75
+
+// compactMsg carries the summary of the conversation's first n messages, the
+// last of which was sent at last.
+type compactMsg struct {
+	tab     int
+	summary string
+	n       int
+	last    time.Time
+	err     error
+}
+
+func (msg compactMsg) tabID() int { return msg.tab }
+
+func runCompact(m *model, args string) tea.Cmd {
+	switch args {
+	case "":
+	case "off":
+		if m.summary == "" {
+			m.notice = "The conversation is not compacted"
+			return nil
+		}
+		for i := range m.messages {
+			m.messages[i].compacted = false
+		}
+		m.summary = ""
+		m.notice = "Dropped the summary, the full history is sent again"
+		return nil
+	default:
+		m.notice = "Usage: /compact [off]"
+		return nil
+	}
+	if m.compacting {
+		m.notice = "Already summarizing the conversation..."
+		return nil
+	}
+	cmd := m.compact()
+	if cmd == nil {
+		m.notice = "Nothing to compact yet"
+		return nil
+	}
+	m.notice = "Summarizing older messages..."
+	return cmd
+}
+
+// compact starts summarizing every message but the latest few in the
+// background, building on the summary so far. It returns nil if there is
+// nothing new to summarize.
+func (m *model) compact() tea.Cmd {
+	n := len(m.messages) - compactKeep
+	var transcript strings.Builder
+	if m.summary != "" {
+		transcript.WriteString(summaryPrefix + m.summary + "\n\n")
+	}
+	added := 0
+	for _, msg := range m.messages[:max(n, 0)] {
+		if msg.compacted || msg.err != nil {
+			continue
+		}
+		role := "User"
+		switch msg.role {
+		case "assistant":
+			role = "Assistant"
+		case "tool":
+			role = "Tool " + msg.toolName
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n\n", role, msg.content)
+		for _, call := range msg.toolCalls {
+			fmt.Fprintf(&transcript, "Assistant called %s with %s\n\n", call.name, call.arguments)
+		}
+		added++
+	}
+	if added == 0 {
+		return nil
+	}
+
+	m.compacting = true
+	tab, backend, modelName, last := m.id, m.provider, m.modelName, m.messages[n-1].sent
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), compactTimeout)
+		defer cancel()
+
+		chunks, err := backend.stream(ctx, request{
+			model: modelName,
+			messages: []message{
+				{role: "system", content: compactPrompt},
+				{role: "user", content: transcript.String()},
+			},
+			choices: 1,
+		})
+		if err != nil {
+			return compactMsg{tab: tab, err: err}
+		}
+		var summary strings.Builder
+		for c := range chunks {
+			if c.err != nil {
+				return compactMsg{tab: tab, err: c.err}
+			}
+			summary.WriteString(c.content)
+		}
+		if err := ctx.Err(); err != nil {
+			return compactMsg{tab: tab, err: err}
+		}
+		return compactMsg{tab: tab, summary: strings.TrimSpace(summary.String()), n: n, last: last}
+	}
+}
+
+// applyCompact replaces the summarized messages with the summary in later
+// requests. They stay in the conversation, and its saved session, as they
+// were.
+func (m *model) applyCompact(msg compactMsg) {
+	m.compacting = false
+	switch {
+	case msg.err != nil:
+		m.notice = "Could not summarize the conversation: " + msg.err.Error()
+		return
+	case msg.summary == "":
+		m.notice = "Could not summarize the conversation: the summary was empty"
+		return
+	case msg.n > len(m.messages) || !m.messages[msg.n-1].sent.Equal(msg.last):
+		m.notice = "The conversation changed while it was summarized, run /compact again"
+		return
+	}
+	for i := range m.messages[:msg.n] {
+		m.messages[i].compacted = true
+	}
+	m.summary = msg.summary
+	m.notice = fmt.Sprintf("Summarized %d messages for later requests", msg.n)
+}
+
+// autoCompact summarizes the conversation once it fills most of the model's
+// context window, if auto_compact is set.
+func (m *model) autoCompact() tea.Cmd {
+	if !m.config.AutoCompact || m.compacting {
+		return nil
+	}
+	req, dropped := m.newRequest(m.messages, m.modelName)
+	total := 0
+	for _, msg := range req.messages {
+		total += estimateTokens(msg)
+	}
+	if dropped == 0 && float64(total) < autoCompactShare*float64(m.config.contextWindow(m.modelName)) {
+		return nil
+	}
+	return m.compact()
+}
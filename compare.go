@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// maxCompare is how many models can be compared at once, one per number key.
+const maxCompare = 9
+
+// minPanelWidth is the narrowest a comparison panel gets before panels are
+// stacked instead of shown side by side.
+const minPanelWidth = 30
+
+// comparison is a prompt sent to several models at once. Each streams on its
+// own channel, and fails on its own, until the user keeps one answer.
+type comparison struct {
+	models   []string
+	chans    []chan streamEvent
+	results  []string
+	errs     []error
+	usage    []usage
+	finished []bool
+	// started is when the prompt was sent, and elapsed how long each model
+	// took to finish its answer.
+	started time.Time
+	elapsed []time.Duration
+}
+
+// compareMsg wraps a stream message from one of the compared models.
+type compareMsg struct {
+	tab  int
+	slot int
+	c    *comparison
+	msg  tea.Msg
+}
+
+func (msg compareMsg) tabID() int { return msg.tab }
+
+var panelStyle = lipgloss.NewStyle().
+	Border(lipgloss.RoundedBorder()).
+	BorderForeground(lipgloss.Color("#7C3AED")).
+	Padding(0, 1)
+
+// compareUsage is how /compare is used.
+const compareUsage = `Usage: /compare <model>,<model>[,...] ["prompt"] or /compare off`
+
+func runCompare(m *model, args string) tea.Cmd {
+	if args == "off" {
+		if len(m.comparing) == 0 {
+			m.notice = "Not comparing models"
+			return nil
+		}
+		m.comparing = nil
+		m.notice = "Stopped comparing, messages go to " + m.modelName
+		return nil
+	}
+	list, prompt, _ := strings.Cut(args, " ")
+	prompt = strings.TrimSpace(prompt)
+	if unquoted, err := strconv.Unquote(prompt); err == nil {
+		prompt = unquoted
+	}
+	var models []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			models = append(models, name)
+		}
+	}
+	if len(models) < 2 {
+		m.notice = compareUsage
+		return nil
+	}
+	if len(models) > maxCompare {
+		m.notice = fmt.Sprintf("At most %d models can be compared", maxCompare)
+		return nil
+	}
+	if prompt == "" {
+		// Without a prompt, every message is compared until turned off
+		m.comparing = models
+		m.notice = fmt.Sprintf("Comparing %s: each message goes to all of them, /compare off to stop", strings.Join(models, ", "))
+		return nil
+	}
+	if m.loading {
+		m.notice = "Please wait for the current response..."
+		return nil
+	}
+	return m.startCompare(models, prompt)
+}
+
+// startCompare adds prompt to the conversation and compares the models'
+// answers to it.
+func (m *model) startCompare(models []string, prompt string) tea.Cmd {
+	m.messages = append(m.messages, chatMessage{role: "user", content: prompt, sent: time.Now()})
+	m.trimScrollback()
+	m.viewport.GotoBottom()
+	if err := m.transcript.userTurn(prompt); err != nil {
+		m.notice = err.Error()
+	}
+	return m.streamCompare(models)
+}
+
+// streamCompare streams answers to the conversation from every model
+// concurrently, each with the same history.
+func (m *model) streamCompare(models []string) tea.Cmd {
+	c := &comparison{
+		models:   models,
+		chans:    make([]chan streamEvent, len(models)),
+		results:  make([]string, len(models)),
+		errs:     make([]error, len(models)),
+		usage:    make([]usage, len(models)),
+		finished: make([]bool, len(models)),
+		started:  time.Now(),
+		elapsed:  make([]time.Duration, len(models)),
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	done := make(chan struct{})
+	m.compare = c
+	m.loading = true
+	m.cancelStream = cancel
+	m.streamDone = done
+
+	var wg sync.WaitGroup
+	cmds := make([]tea.Cmd, len(models))
+	for i, name := range models {
+		req, dropped := m.newRequest(m.messages, name)
+		if dropped > 0 {
+			m.notice = trimmedNotice(dropped, name, m.config.contextWindow(name))
+		}
+		// Each model gives one answer; the comparison is the choice. Answers
+		// are compared as they are, without calling tools
+		req.choices = 1
+		req.tools = nil
+		c.chans[i] = make(chan streamEvent, 100)
+		wg.Add(1)
+		go func(streamChan chan streamEvent) {
+			defer wg.Done()
+			startStreamingInBackground(ctx, streamChan, m.provider, req, m.config.budget())
+		}(c.chans[i])
+		cmds[i] = m.listenForCompare(c, i)
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return tea.Batch(cmds...)
+}
+
+// listenForCompare waits for the next update from one compared model.
+func (m model) listenForCompare(c *comparison, slot int) tea.Cmd {
+	listen := listenForStreamUpdates(m.id, c.chans[slot], m.config.renderThrottle())
+	return func() tea.Msg {
+		return compareMsg{tab: m.id, slot: slot, c: c, msg: listen()}
+	}
+}
+
+// updateCompare records progress from one compared model. Once all have
+// finished, the answers become choices to pick from.
+func (m *model) updateCompare(msg compareMsg) tea.Cmd {
+	c := m.compare
+	if c != msg.c {
+		// A comparison that has since been cancelled
+		return nil
+	}
+	switch inner := msg.msg.(type) {
+	case streamUpdateMsg:
+		if len(inner.choices) > 0 {
+			c.results[msg.slot] = inner.choices[0]
+		}
+		if inner.overBudget != "" {
+			m.confirmOverBudget(fmt.Sprintf("%s: %s", c.models[msg.slot], inner.overBudget))
+		}
+		return m.listenForCompare(c, msg.slot)
+	case streamCompleteMsg:
+		if len(inner.choices) > 0 {
+			c.results[msg.slot] = inner.choices[0]
+		}
+		c.errs[msg.slot] = inner.err
+		c.usage[msg.slot] = m.recordUsage(inner.usage, c.models[msg.slot])
+		c.finished[msg.slot] = true
+		c.elapsed[msg.slot] = time.Since(c.started)
+	}
+
+	for _, finished := range c.finished {
+		if !finished {
+			return nil
+		}
+	}
+	m.loading = false
+	m.dropStreamConfirm()
+	m.cancelStream = nil
+	m.streamDone = nil
+	for _, result := range c.results {
+		if result != "" {
+			m.choices = c.results
+			return nil
+		}
+	}
+	// Nothing to pick from
+	m.compare = nil
+	m.failTurn(fmt.Errorf("every model failed: %w", errors.Join(c.errs...)))
+	return m.afterTurn()
+}
+
+// renderComparison renders each model's answer in its own labelled panel,
+// side by side when the terminal is wide enough.
+func renderComparison(c *comparison, width int, streaming bool) string {
+	panelWidth := 0
+	if width > 0 {
+		panelWidth = width/len(c.models) - panelStyle.GetHorizontalFrameSize()
+	}
+	sideBySide := panelWidth >= minPanelWidth
+	if !sideBySide && width > 0 {
+		panelWidth = width - panelStyle.GetHorizontalFrameSize()
+	}
+
+	panels := make([]string, len(c.models))
+	for i, name := range c.models {
+		body := c.results[i]
+		if c.errs[i] != nil {
+			if body != "" {
+				body += "\n"
+			}
+			body += errorStyle.Render("Error: ") + c.errs[i].Error()
+		} else if streaming && !c.finished[i] {
+			body += assistantStyle.Render("█")
+		}
+		style := panelStyle
+		if panelWidth > 0 {
+			style = style.Width(panelWidth)
+		}
+		label := assistantStyle.Render(fmt.Sprintf("%d %s", i+1, name))
+		if c.finished[i] {
+			label += helpStyle.Render(panelStats(c.elapsed[i], c.usage[i]))
+		}
+		panels[i] = style.Render(label + "\n" + body)
+	}
+	if sideBySide {
+		return lipgloss.JoinHorizontal(lipgloss.Top, panels...) + "\n\n"
+	}
+	return strings.Join(panels, "\n") + "\n\n"
+}
+
+// panelStats sums up how a compared model did: how long it took and, if the
+// provider reported it, the tokens it wrote and what it cost.
+func panelStats(elapsed time.Duration, u usage) string {
+	s := fmt.Sprintf(" · %.1fs", elapsed.Seconds())
+	if u.completion > 0 {
+		s += fmt.Sprintf(" · %d tokens", u.completion)
+	}
+	if u.priced {
+		s += " · " + formatCost(u.cost)
+	}
+	return s
+}
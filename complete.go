@@ -0,0 +1,118 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// maxSuggestions is how many completions are shown below the input.
+const maxSuggestions = 5
+
+// suggestions completes a slash command being typed: the command name, or
+// once a space follows it, the command's argument.
+func (m model) suggestions() []string {
+	input := m.input.Value()
+	if !strings.HasPrefix(input, "/") {
+		return nil
+	}
+	name, arg, hasArg := strings.Cut(strings.TrimPrefix(input, "/"), " ")
+
+	var out []string
+	if !hasArg {
+		for _, c := range commands {
+			if strings.HasPrefix(c.name, name) {
+				out = append(out, "/"+c.name)
+			}
+		}
+		return out
+	}
+
+	c, ok := findCommand(name)
+	if !ok || c.complete == nil {
+		return nil
+	}
+	for _, candidate := range c.complete(&m) {
+		if strings.HasPrefix(candidate, arg) && candidate != arg {
+			out = append(out, "/"+c.name+" "+candidate)
+		}
+	}
+	return out
+}
+
+// completeInput replaces the input with the top suggestion. A completed
+// command name is followed by a space, ready for its argument.
+func (m *model) completeInput() bool {
+	suggestions := m.suggestions()
+	if len(suggestions) == 0 {
+		return false
+	}
+	completed := suggestions[0]
+	if !strings.Contains(completed, " ") {
+		completed += " "
+	}
+	m.setInput(completed)
+	return true
+}
+
+func completeSessions(m *model) []string {
+	names, _ := recentSessions()
+	return names
+}
+
+func completeTemplates(m *model) []string {
+	names, _ := listTemplates()
+	return names
+}
+
+func completeProfiles(m *model) []string {
+	return m.config.profileNames()
+}
+
+// completeModels offers the recently used models, those of every profile and
+// those the provider offers.
+func completeModels(m *model) []string {
+	models := slices.Clone(m.recentModels)
+	for _, name := range m.providerModels {
+		if !slices.Contains(models, name) {
+			models = append(models, name)
+		}
+	}
+	for _, name := range m.config.profileNames() {
+		if p := m.config.Profiles[name]; p.Model != "" && !slices.Contains(models, p.Model) {
+			models = append(models, p.Model)
+		}
+	}
+	if !slices.Contains(models, defaultModel) {
+		models = append(models, defaultModel)
+	}
+	return models
+}
+
+func completeJSON(m *model) []string {
+	return []string{"on", "off", "schema "}
+}
+
+func completeMemory(m *model) []string {
+	return []string{"add ", "forget ", "edit", "clear"}
+}
+
+func completeOnOff(m *model) []string {
+	return []string{"on", "off"}
+}
+
+// completeOff offers "off" for commands whose only fixed argument clears a setting.
+func completeOff(m *model) []string {
+	return []string{"off"}
+}
+
+func completeSet(m *model) []string {
+	names := make([]string, len(samplingSettings))
+	for i, s := range samplingSettings {
+		names[i] = s.name + " "
+	}
+	return names
+}
+
+func completePenalty(m *model) []string {
+	return []string{"presence ", "frequency "}
+}
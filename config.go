@@ -0,0 +1,359 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+const defaultModel = "gpt-4o"
+
+// config is the contents of the user's config file.
+type config struct {
+	// The top-level provider, model, key, base URL and system prompt are
+	// used without a profile, and the environment overrides them.
+	profile
+	DefaultProfile string             `toml:"default_profile,omitempty"`
+	Profiles       map[string]profile `toml:"profiles,omitempty"`
+	// SkipConfirmations runs destructive commands like /clear without asking.
+	SkipConfirmations bool `toml:"skip_confirmations,omitempty"`
+	// Choices is the number of candidate responses to request. With more
+	// than one, the user picks which to keep.
+	Choices int `toml:"choices,omitempty"`
+	// AutoTitle names new conversations by asking the model to summarize the
+	// first exchange.
+	AutoTitle bool `toml:"auto_title,omitempty"`
+	// AutoCompact summarizes older turns once a conversation fills most of
+	// the model's context window.
+	AutoCompact bool `toml:"auto_compact,omitempty"`
+	// Stop lists sequences that end generation when produced.
+	Stop []string `toml:"stop,omitempty"`
+	// JSONMode asks for responses in JSON, following JSONSchema (a path to
+	// a schema file) when one is given.
+	JSONMode   bool   `toml:"json_mode,omitempty"`
+	JSONSchema string `toml:"json_schema,omitempty"`
+	// ScrollbackLimit caps how many messages are kept on screen, defaulting
+	// to defaultScrollbackLimit. Older messages are dropped from the
+	// conversation, unless ScrollbackKeepHistory keeps them in the history
+	// that is sent and saved.
+	ScrollbackLimit       int  `toml:"scrollback_limit,omitempty"`
+	ScrollbackKeepHistory bool `toml:"scrollback_keep_history,omitempty"`
+	// DisableMouse leaves mouse events to the terminal, so plain dragging
+	// selects text.
+	DisableMouse bool `toml:"disable_mouse,omitempty"`
+	// DisableAutosave stops conversations from being saved as sessions
+	// after every turn.
+	DisableAutosave bool `toml:"disable_autosave,omitempty"`
+	// DisableSessionPicker starts with a new conversation rather than the
+	// list of saved sessions.
+	DisableSessionPicker bool `toml:"disable_session_picker,omitempty"`
+	// RenderThrottleMS coalesces streamed text so the screen is redrawn at
+	// most once per interval. Zero, the default, redraws on every update.
+	RenderThrottleMS int `toml:"render_throttle_ms,omitempty"`
+	// NoStream shows responses only once they are complete, rather than as
+	// they stream in.
+	NoStream bool `toml:"no_stream,omitempty"`
+	// VimMode takes keys the way vim does, with normal mode for moving
+	// between messages and insert mode for typing.
+	VimMode bool `toml:"vim_mode,omitempty"`
+	// Temperature, TopP and MaxTokens control sampling and response length.
+	// Like the penalties and seed, they are only sent when set.
+	Temperature *float64 `toml:"temperature,omitempty"`
+	TopP        *float64 `toml:"top_p,omitempty"`
+	MaxTokens   *int64   `toml:"max_tokens,omitempty"`
+	// PresencePenalty and FrequencyPenalty discourage repetition. They are
+	// only sent when set.
+	PresencePenalty  *float64 `toml:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64 `toml:"frequency_penalty,omitempty"`
+	// Seed makes sampling repeatable, as far as the provider allows.
+	Seed *int64 `toml:"seed,omitempty"`
+	// ReasoningEffort is how hard reasoning models think before answering,
+	// one of reasoningEfforts. Unset, it is left to the model.
+	ReasoningEffort string `toml:"reasoning_effort,omitempty"`
+	// BudgetSeconds and BudgetTokens ask whether to cancel a response once
+	// it runs longer, or produces more tokens, than allowed.
+	BudgetSeconds int `toml:"budget_seconds,omitempty"`
+	BudgetTokens  int `toml:"budget_tokens,omitempty"`
+	// MaxInputLength caps how many characters a message may have, so a
+	// mistaken paste does not overflow the context window. It defaults to
+	// defaultMaxInputLength.
+	MaxInputLength int `toml:"max_input_length,omitempty"`
+	// InputMaxHeight is how many rows the input grows to before scrolling,
+	// defaulting to defaultInputMaxHeight.
+	InputMaxHeight int `toml:"input_max_height,omitempty"`
+	// RunAllowed lists programs /run, and the run_shell tool, may start
+	// without asking first.
+	RunAllowed []string `toml:"run_allowed,omitempty"`
+	// RunDenied lists programs that are never run, by /run or the model.
+	RunDenied []string `toml:"run_denied,omitempty"`
+	// Tools lists the tools the model may call, by name.
+	Tools []string `toml:"tools,omitempty"`
+	// FetchMaxTokens caps how much of a page /fetch and the fetch_url tool
+	// keep, defaulting to defaultFetchMaxTokens.
+	FetchMaxTokens int `toml:"fetch_max_tokens,omitempty"`
+	// Search sets up the search provider of the web_search tool.
+	Search searchConfig `toml:"search,omitempty"`
+	// RAG sets up the embeddings of the documents indexed with llmtui index,
+	// which are searched for context before each request.
+	RAG ragConfig `toml:"rag,omitempty"`
+	// MCPServers are Model Context Protocol servers whose tools the model
+	// may call too, keyed by a name for each.
+	MCPServers map[string]mcpServer `toml:"mcp_servers,omitempty"`
+	// Labels replaces the names shown before messages.
+	Labels labels `toml:"labels,omitempty"`
+	Theme  theme  `toml:"theme,omitempty"`
+	// Keys rebinds global shortcuts, mapping action names to keys.
+	Keys map[string]string `toml:"keys,omitempty"`
+	// Pricing adds or overrides model prices used to estimate costs, keyed
+	// by model name or prefix.
+	Pricing map[string]price `toml:"pricing,omitempty"`
+	// ContextWindows adds or overrides model context sizes in tokens, keyed
+	// by model name or prefix. Conversations are trimmed to fit them.
+	ContextWindows map[string]int `toml:"context_windows,omitempty"`
+}
+
+// labels are the names, and optional icons such as nerd font glyphs, shown
+// before user and assistant messages.
+type labels struct {
+	User          string `toml:"user,omitempty"`
+	Assistant     string `toml:"assistant,omitempty"`
+	UserIcon      string `toml:"user_icon,omitempty"`
+	AssistantIcon string `toml:"assistant_icon,omitempty"`
+}
+
+// withDefaults fills in the default names for any left unset.
+func (l labels) withDefaults() labels {
+	if l.User == "" {
+		l.User = "You"
+	}
+	if l.Assistant == "" {
+		l.Assistant = "LLM"
+	}
+	return l
+}
+
+func (c config) renderThrottle() time.Duration {
+	return time.Duration(max(c.RenderThrottleMS, 0)) * time.Millisecond
+}
+
+const defaultScrollbackLimit = 1000
+
+const defaultMaxInputLength = 100_000
+
+func (c config) maxInputLength() int {
+	if c.MaxInputLength <= 0 {
+		return defaultMaxInputLength
+	}
+	return c.MaxInputLength
+}
+
+const defaultInputMaxHeight = 8
+
+func (c config) inputMaxHeight() int {
+	if c.InputMaxHeight <= 0 {
+		return defaultInputMaxHeight
+	}
+	return c.InputMaxHeight
+}
+
+func (c config) scrollbackLimit() int {
+	if c.ScrollbackLimit <= 0 {
+		return defaultScrollbackLimit
+	}
+	return c.ScrollbackLimit
+}
+
+// profile is a named connection setup that can be switched at runtime.
+type profile struct {
+	Provider     string `toml:"provider,omitempty"`
+	Model        string `toml:"model,omitempty"`
+	APIKey       string `toml:"api_key,omitempty"`
+	APIKeyEnv    string `toml:"api_key_env,omitempty"` // variable holding the key, if not the provider's usual one
+	BaseURL      string `toml:"base_url,omitempty"`
+	APIVersion   string `toml:"api_version,omitempty"` // Azure OpenAI only
+	SystemPrompt string `toml:"system_prompt,omitempty"`
+	// Temperature replaces the configured temperature while the profile is
+	// in use. The top-level temperature is the config's own.
+	Temperature *float64 `toml:"temperature,omitempty"`
+}
+
+// withEnv overrides the profile with the provider, model, base URL and API key
+// set in the environment. Naming another provider there starts from that
+// provider's defaults instead.
+func (p profile) withEnv() profile {
+	if env := os.Getenv("LLMTUI_PROVIDER"); env != "" && env != p.Provider {
+		p = profile{Provider: env, SystemPrompt: p.SystemPrompt}
+	}
+	b, err := lookupBackend(p.Provider)
+	if err != nil {
+		// Reported by connect
+		return p
+	}
+	for _, v := range []struct {
+		env   string
+		value *string
+	}{
+		{b.baseURLEnv, &p.BaseURL},
+		{b.modelEnv, &p.Model},
+		{p.keyEnv(b), &p.APIKey},
+	} {
+		if v.env == "" {
+			continue
+		}
+		if env := os.Getenv(v.env); env != "" {
+			*v.value = env
+		}
+	}
+	return p
+}
+
+// keyEnv returns the environment variable the profile's API key is read from
+// when it gives none. A profile pointing a provider whose key is optional at
+// a server of its own is only given the key it names, so that the key for
+// the provider's API is never sent elsewhere.
+func (p profile) keyEnv(b backend) string {
+	switch {
+	case p.APIKeyEnv != "":
+		return p.APIKeyEnv
+	case b.optionalKey && p.BaseURL != "" && os.Getenv(b.baseURLEnv) == "":
+		return ""
+	}
+	return b.keyEnv
+}
+
+// configFile is the config file given with --config, if any.
+var configFile string
+
+// configPath returns the location of the config file,
+// ~/.config/llmtui/config.toml on Linux unless --config says otherwise.
+func configPath() (string, error) {
+	if configFile != "" {
+		return configFile, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "llmtui", "config.toml"), nil
+}
+
+// loadConfig reads the config file. A missing file is not an error.
+func loadConfig() (config, error) {
+	var cfg config
+	path, err := configPath()
+	if err != nil {
+		return cfg, nil
+	}
+	// Only a config file given with --config has to exist
+	if _, err := toml.DecodeFile(path, &cfg); err != nil && (!errors.Is(err, fs.ErrNotExist) || configFile != "") {
+		return cfg, fmt.Errorf("read config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// configExists reports whether the config file is present.
+func configExists() bool {
+	path, err := configPath()
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// saveConfig writes cfg to the config file. The file may hold API keys, so it
+// is only readable by the user.
+func saveConfig(cfg config) (string, error) {
+	path, err := configPath()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", fmt.Errorf("save config: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", fmt.Errorf("save config: %w", err)
+	}
+	defer f.Close()
+	if err := toml.NewEncoder(f).Encode(cfg); err != nil {
+		return "", fmt.Errorf("save config: %w", err)
+	}
+	return path, nil
+}
+
+// profileNames returns the configured profile names in sorted order.
+func (c config) profileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// connect builds a client for the profile, falling back to the environment
+// for anything the profile leaves unset. It returns the client and the model
+// name to use with it.
+func (p profile) connect() (provider, string, error) {
+	b, err := lookupBackend(p.Provider)
+	if err != nil {
+		return nil, "", err
+	}
+	if p.BaseURL == "" && b.baseURLEnv != "" {
+		p.BaseURL = os.Getenv(b.baseURLEnv)
+	}
+	keyEnv := p.keyEnv(b)
+	if p.APIKey == "" && keyEnv != "" {
+		p.APIKey = os.Getenv(keyEnv)
+		if p.APIKey == "" && !(b.optionalKey && p.BaseURL != "") {
+			return nil, "", fmt.Errorf("%s %w", keyEnv, errMissingAPIKey)
+		}
+	}
+
+	modelName := p.Model
+	if modelName == "" {
+		modelName = os.Getenv(b.modelEnv)
+	}
+	if modelName == "" {
+		modelName = b.defaultModel
+	}
+
+	backend, err := b.connect(p)
+	if err != nil {
+		return nil, "", err
+	}
+	return backend, modelName, nil
+}
+
+// useProfile switches the active conversation to the named profile,
+// rebuilding the client.
+func (m *model) useProfile(name string) error {
+	p, ok := m.config.Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile %q", name)
+	}
+	backend, modelName, err := p.connect()
+	if err != nil {
+		return fmt.Errorf("profile %s: %w", name, err)
+	}
+	m.provider = backend
+	m.providerModels = nil
+	m.modelName = modelName
+	m.systemPrompt = p.SystemPrompt
+	if m.systemPrompt == "" {
+		m.systemPrompt = m.config.SystemPrompt
+	}
+	m.config.Temperature = m.baseTemperature
+	if p.Temperature != nil {
+		m.config.Temperature = p.Temperature
+	}
+	m.profile = name
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// defaultContextWindow is assumed for models of unknown size, small enough
+// for most local models.
+const defaultContextWindow = 8192
+
+// responseReserve is the room left for the response when max_tokens is not
+// set.
+const responseReserve = 4096
+
+// contextWindows are the context sizes of well-known models in tokens,
+// matched by the longest prefix like prices. The config's [context_windows]
+// table adds to and overrides them.
+var contextWindows = map[string]int{
+	"gpt-5":         400_000,
+	"gpt-4.1":       1_047_576,
+	"gpt-4o":        128_000,
+	"gpt-4-turbo":   128_000,
+	"gpt-4":         8192,
+	"gpt-3.5-turbo": 16_385,
+	"o1":            200_000,
+	"o3":            200_000,
+	"o4-mini":       200_000,
+	"claude":        200_000,
+	"gemini":        1_048_576,
+}
+
+// contextWindow returns the context size of modelName in tokens.
+func (c config) contextWindow(modelName string) int {
+	if n, ok := longestPrefix(c.ContextWindows, modelName); ok && n > 0 {
+		return n
+	}
+	if n, ok := longestPrefix(contextWindows, modelName); ok {
+		return n
+	}
+	return defaultContextWindow
+}
+
+// estimateTokens approximates how many tokens a message takes, at about four
+// characters a token plus a few for the message's framing, and imageTokens
+// an image. It errs on the large side for English, so trimming leaves some
+// slack.
+func estimateTokens(msg message) int {
+	return utf8.RuneCountInString(msg.content)/4 + 4 + len(msg.images)*imageTokens
+}
+
+// fitContext leaves out the oldest turns of messages until the rest, and
+// reserve tokens for the response, fit in window. System messages and the
+// latest message are always kept, and the history kept starts with a user
+// message. It returns the messages kept and how many were left out.
+func fitContext(messages []message, window, reserve int) ([]message, int) {
+	// Keep room for the response, but not all of a small window
+	budget := window - min(reserve, window/2)
+	total := 0
+	for _, msg := range messages {
+		total += estimateTokens(msg)
+	}
+	if total <= budget {
+		return messages, 0
+	}
+
+	var system, history []message
+	for _, msg := range messages {
+		if msg.role == "system" {
+			system = append(system, msg)
+		} else {
+			history = append(history, msg)
+		}
+	}
+	dropped := 0
+	for len(history) > 1 && (total > budget || history[0].role != "user") {
+		total -= estimateTokens(history[0])
+		history = history[1:]
+		dropped++
+	}
+	return append(system, history...), dropped
+}
+
+// trimmedNotice warns that the oldest messages were left out of a request.
+func trimmedNotice(dropped int, modelName string, window int) string {
+	unit := "messages"
+	if dropped == 1 {
+		unit = "message"
+	}
+	return fmt.Sprintf("Left out the %d oldest %s to fit %s's context window of %d tokens", dropped, unit, modelName, window)
+}
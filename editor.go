@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// editorFinishedMsg reports that the external editor has exited.
+type editorFinishedMsg struct {
+	err error
+}
+
+// editorCommand returns the user's editor and its arguments, from $VISUAL or
+// $EDITOR, falling back to a platform default.
+func editorCommand() []string {
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if fields := strings.Fields(os.Getenv(name)); len(fields) > 0 {
+			return fields
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return []string{"notepad"}
+	}
+	return []string{"vi"}
+}
+
+// openInEditor writes the target response to a temporary file and opens it in
+// the user's editor, suspending the TUI until the editor exits.
+func (m *model) openInEditor() tea.Cmd {
+	i := m.targetMessage()
+	if i < 0 {
+		m.notice = "No response to open"
+		return nil
+	}
+	if m.messages[i].role != "assistant" || m.messages[i].err != nil {
+		m.notice = "Only responses can be opened in the editor"
+		return nil
+	}
+	return m.editText("llmtui-*.md", m.messages[i].content, func(path string, err error) tea.Msg {
+		os.Remove(path)
+		return editorFinishedMsg{err: err}
+	})
+}
+
+// editText writes text to a temporary file named after pattern and opens it
+// in the user's editor, suspending the TUI until the editor exits. done is
+// given the file's path and any error running the editor, and is left to
+// remove the file.
+func (m *model) editText(pattern, text string, done func(path string, err error) tea.Msg) tea.Cmd {
+	args := editorCommand()
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		m.notice = fmt.Sprintf("Cannot run editor %s, set $EDITOR", args[0])
+		return nil
+	}
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		m.notice = "Could not create temporary file: " + err.Error()
+		return nil
+	}
+	_, err = f.WriteString(text)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		m.notice = "Could not write temporary file: " + err.Error()
+		return nil
+	}
+
+	cmd := exec.Command(path, append(args[1:], f.Name())...)
+	return tea.ExecProcess(cmd, func(err error) tea.Msg {
+		return done(f.Name(), err)
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/joho/godotenv"
+)
+
+// loadEnv loads the nearest .env file found walking up from the working
+// directory, then ~/.llmtui/.env. Variables that are already set are never
+// overridden, so the environment wins over the project file, which wins over
+// the one in the home directory.
+func loadEnv() {
+	var paths []string
+	if dir, err := os.Getwd(); err == nil {
+		if path, ok := findUp(dir, ".env"); ok {
+			paths = append(paths, path)
+		}
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".llmtui", ".env"))
+	}
+
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if err := godotenv.Load(path); err != nil {
+			log.Printf("env: could not load %s: %v", path, err)
+			continue
+		}
+		log.Printf("env: loaded %s", path)
+	}
+}
+
+// findUp looks for name in dir and each of its parents, returning the first
+// match.
+func findUp(dir, name string) (string, bool) {
+	for {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+)
+
+// Exit codes, so scripts can tell failures apart.
+const (
+	exitOK        = 0
+	exitFailure   = 1 // any other error
+	exitUsage     = 2 // invalid flags or configuration
+	exitAuth      = 3 // missing or rejected credentials
+	exitRateLimit = 4 // rate limited or out of quota
+	exitTimeout   = 5 // the request or connection timed out
+)
+
+// errMissingAPIKey is returned, after the name of the variable that was
+// looked for, when no API key is configured anywhere.
+var errMissingAPIKey = errors.New("not found in environment or .env file")
+
+// errUsage marks errors in how the program was invoked or configured.
+type errUsage struct{ err error }
+
+func (e errUsage) Error() string { return e.err.Error() }
+func (e errUsage) Unwrap() error { return e.err }
+
+// errorKind classifies err for reporting, returning a short machine readable
+// name and the exit code for it.
+func errorKind(err error) (string, int) {
+	if status, ok := apiStatus(err); ok {
+		switch status {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return "auth", exitAuth
+		case http.StatusTooManyRequests:
+			return "rate_limit", exitRateLimit
+		case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+			return "timeout", exitTimeout
+		}
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout", exitTimeout
+	}
+	if errors.Is(err, errMissingAPIKey) {
+		return "auth", exitAuth
+	}
+	if errors.As(err, new(errUsage)) {
+		return "usage", exitUsage
+	}
+	return "error", exitFailure
+}
+
+// exitWithError reports err on stderr as a single "llmtui: kind: message"
+// line and exits with the matching code.
+func exitWithError(err error) {
+	kind, code := errorKind(err)
+	fmt.Fprintf(os.Stderr, "llmtui: %s: %v\n", kind, err)
+	os.Exit(code)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// exportMarkdown formats a conversation as a markdown document, with a
+// heading per turn like the transcript's. Messages are written as they were
+// sent, so code fences survive as they are.
+func exportMarkdown(s session) string {
+	var b strings.Builder
+	title := s.Title
+	if title == "" {
+		title = "Conversation"
+	}
+	fmt.Fprintf(&b, "# %s\n\n", title)
+	fmt.Fprintf(&b, "Model: %s · Exported %s\n\n", s.Model, formatTimestamp(time.Now()))
+	if s.System != "" {
+		b.WriteString("> System: " + strings.ReplaceAll(s.System, "\n", "\n> ") + "\n\n")
+	}
+	for _, msg := range s.Messages {
+		heading := "You"
+		switch msg.Role {
+		case "assistant":
+			model := s.Model
+			if msg.Model != "" {
+				model = msg.Model
+			}
+			heading = fmt.Sprintf("LLM (%s)", model)
+		case "tool":
+			heading = fmt.Sprintf("Tool (%s)", msg.ToolName)
+		}
+		if !msg.Time.IsZero() {
+			heading += " · " + formatTimestamp(msg.Time)
+		}
+		fmt.Fprintf(&b, "## %s\n\n", heading)
+		if msg.Error != "" {
+			fmt.Fprintf(&b, "> Error: %s\n\n", msg.Error)
+			continue
+		}
+		for _, img := range msg.Images {
+			fmt.Fprintf(&b, "[image: %s]\n\n", img.Name)
+		}
+		if reasoning := strings.TrimSpace(msg.Reasoning); reasoning != "" {
+			fmt.Fprintf(&b, "<details>\n<summary>Thinking</summary>\n\n%s\n\n</details>\n\n", reasoning)
+		}
+		if msg.Role == "tool" {
+			// Results are data rather than markdown
+			fence := fenceFor(msg.Content)
+			fmt.Fprintf(&b, "%s\n%s\n%s\n\n", fence, strings.TrimRight(msg.Content, "\n"), fence)
+			continue
+		}
+		if content := strings.TrimRight(msg.Content, "\n"); content != "" {
+			b.WriteString(closeFences(content) + "\n\n")
+		}
+		for _, call := range msg.ToolCalls {
+			fmt.Fprintf(&b, "> Called `%s` with `%s`\n\n", call.Name, call.Arguments)
+		}
+		if len(msg.Sources) > 0 {
+			b.WriteString("> " + formatSources(msg.Sources) + "\n\n")
+		}
+	}
+	return b.String()
+}
+
+// closeFences closes a code fence left open, as in a response that was cut
+// off, so it does not run on into the rest of the document.
+func closeFences(text string) string {
+	fence := ""
+	for _, line := range strings.Split(text, "\n") {
+		if fence == "" {
+			if open := fenceOpen.FindStringSubmatch(line); open != nil {
+				fence = open[1]
+			}
+		} else if closing := strings.TrimSpace(line); strings.HasPrefix(closing, fence) && strings.Trim(closing, fence[:1]) == "" {
+			fence = ""
+		}
+	}
+	if fence != "" {
+		text += "\n" + fence
+	}
+	return text
+}
+
+func runExport(m *model, args string) tea.Cmd {
+	if len(m.messages) == 0 {
+		m.notice = "Nothing to export yet"
+		return nil
+	}
+	path := args
+	// JSON is asked for by name or by the file's extension
+	asJSON := strings.HasSuffix(path, ".json")
+	if rest, ok := strings.CutPrefix(path, "json"); ok && (rest == "" || rest[0] == ' ') {
+		path, asJSON = strings.TrimSpace(rest), true
+	}
+	if path == "" {
+		path = defaultSessionName(m.title) + ".md"
+		if asJSON {
+			path = strings.TrimSuffix(path, ".md") + ".json"
+		}
+	}
+	path = expandHome(path)
+	export := func(m *model) tea.Cmd {
+		data := []byte(exportMarkdown(m.session()))
+		if asJSON {
+			var err error
+			if data, err = encodeSession(m.session()); err != nil {
+				m.notice = "Export failed: " + err.Error()
+				return nil
+			}
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			m.notice = "Export failed: " + err.Error()
+			return nil
+		}
+		m.notice = "Exported the conversation to " + path
+		return nil
+	}
+	if _, err := os.Stat(path); err == nil {
+		return m.confirmAction(path+" exists. Replace it?", false, export)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		m.notice = err.Error()
+		return nil
+	}
+	return export(m)
+}
+
+// expandHome expands a leading ~/ in a path typed into a command.
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
+
+// exportSession prints the named saved session as markdown, or as JSON if
+// format is "json", for --export.
+func exportSession(name, format string) error {
+	s, err := loadSession(name)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "markdown":
+		_, err = fmt.Print(exportMarkdown(s))
+	case "json":
+		var data []byte
+		if data, err = encodeSession(s); err == nil {
+			_, err = os.Stdout.Write(data)
+		}
+	default:
+		err = errUsage{fmt.Errorf("unknown export format %q, use markdown or json", format)}
+	}
+	return err
+}
+
+// sessionVersion is the version of the JSON format sessions are exported in.
+// It changes when fields are added or a field's meaning changes, so that an
+// earlier version refuses a session it could not read in full. Version 2
+// added each response's model, usage and state and the summary.
+const sessionVersion = 2
+
+// encodeSession formats a session as JSON.
+func encodeSession(s session) ([]byte, error) {
+	s.Version = sessionVersion
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// decodeSession reads a session exported as JSON, or saved as a file by an
+// earlier version.
+func decodeSession(data []byte) (session, error) {
+	var s session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, err
+	}
+	if s.Version > sessionVersion {
+		return s, fmt.Errorf("session format version %d is newer than this version supports (%d)", s.Version, sessionVersion)
+	}
+	for _, messages := range append([][]sessionMessage{s.Messages}, s.Branches...) {
+		for _, msg := range messages {
+			if msg.Role != "user" && msg.Role != "assistant" && msg.Role != "tool" {
+				return s, fmt.Errorf("invalid message role %q", msg.Role)
+			}
+		}
+	}
+	if s.Saved.IsZero() {
+		s.Saved = time.Now()
+	}
+	return s, nil
+}
+
+// importSession saves the session exported to path under the file's name,
+// or a numbered variant of it if that is taken, and returns the name.
+func importSession(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("import: %w", err)
+	}
+	s, err := decodeSession(data)
+	if err != nil {
+		return "", fmt.Errorf("import %s: %w", path, err)
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if validateName(name) != nil {
+		name = defaultSessionName(s.Title)
+	}
+	name = unusedSessionName(name)
+	if err := saveSession(name, s); err != nil {
+		return "", err
+	}
+	return name, nil
+}
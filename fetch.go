@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// maxFetchSize caps how much of a page is downloaded.
+const maxFetchSize = 5 * 1024 * 1024
+
+// defaultFetchMaxTokens is how many tokens of a fetched page's text are kept
+// unless fetch_max_tokens says otherwise.
+const defaultFetchMaxTokens = 4000
+
+func (c config) fetchMaxTokens() int {
+	if c.FetchMaxTokens <= 0 {
+		return defaultFetchMaxTokens
+	}
+	return c.FetchMaxTokens
+}
+
+// fetchedPage is the readable text of a web page.
+type fetchedPage struct {
+	url       string
+	title     string
+	text      string
+	truncated bool // cut to the token budget
+}
+
+// fetchMsg carries a page fetched with /fetch.
+type fetchMsg struct {
+	tab  int
+	page fetchedPage
+	err  error
+}
+
+func runFetch(m *model, args string) tea.Cmd {
+	if args == "" || strings.ContainsRune(args, ' ') {
+		m.notice = "Usage: /fetch <url>"
+		return nil
+	}
+	m.notice = "Fetching " + args + "..."
+	tab, budget := m.id, m.config.fetchMaxTokens()
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), toolTimeout)
+		defer cancel()
+		page, err := fetchPage(ctx, http.DefaultClient, args, budget)
+		return fetchMsg{tab: tab, page: page, err: err}
+	}
+}
+
+// attachPage keeps a fetched page's text to be included in the next message.
+func (m *model) attachPage(msg fetchMsg) {
+	if msg.err != nil {
+		m.notice = "Fetch failed: " + msg.err.Error()
+		return
+	}
+	text := msg.page.String()
+	if m.attachment != "" {
+		m.attachment += "\n\n"
+	}
+	m.attachment += text
+	m.notice = fmt.Sprintf("%s will be included in your next message, %s (Esc to drop it)", msg.page.url, m.estimateCost(message{content: text}))
+}
+
+// String formats the page as it is given to the model: its title and URL,
+// then its text.
+func (p fetchedPage) String() string {
+	var b strings.Builder
+	if p.title != "" {
+		fmt.Fprintf(&b, "Page: %s (%s)\n\n", p.title, p.url)
+	} else {
+		fmt.Fprintf(&b, "Page: %s\n\n", p.url)
+	}
+	b.WriteString(p.text)
+	if p.truncated {
+		b.WriteString("\n\n(truncated)")
+	}
+	return b.String()
+}
+
+// publicClient fetches pages for the model, which must not reach services on
+// the user's machine or network: connections to loopback, link-local and
+// private addresses are refused, whatever a name resolved to and wherever a
+// redirect led. Proxies are not used, since they would hide the address.
+var publicClient = &http.Client{Transport: &http.Transport{
+	DialContext:         (&net.Dialer{Timeout: 30 * time.Second, Control: refuseLocal}).DialContext,
+	TLSHandshakeTimeout: 10 * time.Second,
+}}
+
+// sharedAddresses is the range carriers share between customers, private in
+// all but name.
+var sharedAddresses = netip.MustParsePrefix("100.64.0.0/10")
+
+// refuseLocal refuses to connect to an address that is not on the public
+// internet.
+func refuseLocal(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddresses.Contains(ip) {
+		return fmt.Errorf("%s is a local or private address, which the model may not fetch", ip)
+	}
+	return nil
+}
+
+// fetchURLTool fetches a page for the model.
+func fetchURLTool(ctx context.Context, c config, args json.RawMessage) (string, error) {
+	var params struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", err
+	}
+	page, err := fetchPage(ctx, publicClient, params.URL, c.fetchMaxTokens())
+	if err != nil {
+		return "", err
+	}
+	return page.String(), nil
+}
+
+// fetchPage downloads a page and reduces it to its readable text, cut to
+// about budget tokens, using client. Plain text, JSON and the like are kept as
+// they are.
+func fetchPage(ctx context.Context, client *http.Client, rawURL string, budget int) (fetchedPage, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fetchedPage{}, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fetchedPage{}, fmt.Errorf("%s is not an http or https URL", rawURL)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return fetchedPage{}, err
+	}
+	req.Header.Set("User-Agent", "llmtui")
+	req.Header.Set("Accept", "text/html, text/plain;q=0.9, */*;q=0.5")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fetchedPage{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fetchedPage{}, fmt.Errorf("%s: %s", rawURL, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFetchSize))
+	if err != nil {
+		return fetchedPage{}, err
+	}
+
+	page := fetchedPage{url: resp.Request.URL.String()}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "" {
+		mediaType = http.DetectContentType(body)
+		mediaType, _, _ = mime.ParseMediaType(mediaType)
+	}
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		doc, err := html.Parse(strings.NewReader(string(body)))
+		if err != nil {
+			return fetchedPage{}, err
+		}
+		page.title, page.text = readableText(doc)
+	case strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "json") || strings.HasSuffix(mediaType, "xml"):
+		if !utf8.Valid(body) {
+			return fetchedPage{}, fmt.Errorf("%s is not UTF-8 text", rawURL)
+		}
+		page.text = strings.TrimSpace(string(body))
+	default:
+		return fetchedPage{}, fmt.Errorf("%s is %s, not a page of text", rawURL, mediaType)
+	}
+	if page.text == "" {
+		return fetchedPage{}, errors.New(rawURL + " has no readable text")
+	}
+	page.text, page.truncated = cutToTokens(page.text, budget)
+	return page, nil
+}
+
+// cutToTokens cuts text to about budget tokens, at a line break if there is
+// one near the end, reporting whether it did.
+func cutToTokens(text string, budget int) (string, bool) {
+	limit := budget * 4
+	if len(text) <= limit {
+		return text, false
+	}
+	text = text[:limit]
+	for !utf8.ValidString(text) {
+		text = text[:len(text)-1]
+	}
+	if i := strings.LastIndexByte(text, '\n'); i > limit/2 {
+		text = text[:i]
+	}
+	return strings.TrimSpace(text), true
+}
+
+// skippedElements hold no part of a page's text, or only its navigation and
+// chrome.
+var skippedElements = map[atom.Atom]bool{
+	atom.Script: true, atom.Style: true, atom.Noscript: true, atom.Template: true,
+	atom.Svg: true, atom.Canvas: true, atom.Iframe: true, atom.Object: true,
+	atom.Nav: true, atom.Header: true, atom.Footer: true, atom.Aside: true,
+	atom.Form: true, atom.Button: true, atom.Select: true, atom.Dialog: true,
+}
+
+// blockElements start on a line of their own.
+var blockElements = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Section: true, atom.Article: true, atom.Main: true,
+	atom.Ul: true, atom.Ol: true, atom.Li: true, atom.Dl: true, atom.Dt: true, atom.Dd: true,
+	atom.Table: true, atom.Blockquote: true, atom.Figure: true, atom.Figcaption: true,
+	atom.Hr: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+}
+
+// readableText returns a page's title and the text of its main content: its
+// article or main element if it has one, otherwise its body, leaving out
+// scripts, navigation and the like. Headings are marked as in markdown and
+// preformatted text is fenced, so the structure survives.
+func readableText(doc *html.Node) (string, string) {
+	var title string
+	var articles, mains []*html.Node
+	var body *html.Node
+	var find func(n *html.Node)
+	find = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Title:
+				if title == "" && n.FirstChild != nil {
+					title = strings.Join(strings.Fields(n.FirstChild.Data), " ")
+				}
+			case atom.Article:
+				articles = append(articles, n)
+			case atom.Main:
+				mains = append(mains, n)
+			case atom.Body:
+				body = n
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			find(c)
+		}
+	}
+	find(doc)
+
+	root := body
+	switch {
+	case len(mains) == 1:
+		root = mains[0]
+	case len(articles) == 1:
+		root = articles[0]
+	}
+	if root == nil {
+		root = doc
+	}
+	w := &textWriter{}
+	w.write(root)
+	return title, strings.TrimSpace(w.b.String())
+}
+
+// textWriter writes the text of an HTML tree, collapsing the whitespace of
+// inline text and separating blocks with blank lines.
+type textWriter struct {
+	b       strings.Builder
+	pending string // separator owed before the next text
+}
+
+func (w *textWriter) text(s string) {
+	if s == "" {
+		return
+	}
+	if w.b.Len() > 0 {
+		w.b.WriteString(w.pending)
+	}
+	w.pending = ""
+	w.b.WriteString(s)
+}
+
+// breakLine owes a separator of at least sep before the next text.
+func (w *textWriter) breakLine(sep string) {
+	if len(sep) > len(w.pending) {
+		w.pending = sep
+	}
+}
+
+func (w *textWriter) write(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		if words := strings.Fields(n.Data); len(words) > 0 {
+			s := strings.Join(words, " ")
+			// Keep the space between inline elements, as in "a <b>b</b>"
+			if w.pending == "" && w.b.Len() > 0 && isSpace(n.Data[0]) && !strings.HasSuffix(w.b.String(), " ") {
+				s = " " + s
+			}
+			w.text(s)
+			if isSpace(n.Data[len(n.Data)-1]) && w.pending == "" {
+				w.pending = " "
+			}
+		}
+		return
+	case html.ElementNode:
+		if skippedElements[n.DataAtom] || hasAttr(n, "hidden") || attr(n, "aria-hidden") == "true" {
+			return
+		}
+	}
+
+	switch n.DataAtom {
+	case atom.Pre:
+		w.breakLine("\n\n")
+		code := strings.Trim(textContent(n), "\n")
+		fence := fenceFor(code)
+		w.text(fence + "\n" + code + "\n" + fence)
+		w.breakLine("\n\n")
+		return
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		w.breakLine("\n\n")
+		w.text(strings.Repeat("#", int(n.Data[1]-'0')) + " ")
+		w.pending = ""
+	case atom.Li:
+		w.breakLine("\n")
+		w.text("- ")
+		w.pending = ""
+	case atom.Tr, atom.Br:
+		w.breakLine("\n")
+	case atom.Td, atom.Th:
+		// Cells after the first in a row are separated on its line
+		if w.pending == "" || w.pending == " " {
+			w.pending = " | "
+		}
+	case atom.Img:
+		if alt := strings.TrimSpace(attr(n, "alt")); alt != "" {
+			w.text("[image: " + alt + "]")
+		}
+	default:
+		if blockElements[n.DataAtom] {
+			w.breakLine("\n\n")
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.write(c)
+	}
+	switch {
+	case n.DataAtom == atom.Li || n.DataAtom == atom.Tr || n.DataAtom == atom.Br:
+		w.breakLine("\n")
+	case blockElements[n.DataAtom]:
+		w.breakLine("\n\n")
+	}
+}
+
+// textContent returns the text in n as it is, for preformatted text.
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(textContent(c))
+	}
+	return b.String()
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// matchStyle marks the text found in the conversation. Reversing the colours
+// shows on any theme.
+var matchStyle = lipgloss.NewStyle().Reverse(true)
+
+// openFind asks what to find in the conversation.
+func openFind(m *model) tea.Cmd {
+	m.promptFind()
+	return nil
+}
+
+// promptFind asks what to find in the conversation, starting from the last
+// search, and selects the first message containing it after the selected
+// one. Matches are highlighted until the search is cleared with Esc.
+func (m *model) promptFind() {
+	m.prompt = &textPrompt{label: "Find: ", value: m.findQuery, onSubmit: func(m *model, query string) tea.Cmd {
+		if query == "" {
+			return nil
+		}
+		m.findQuery = query
+		m.findNext(1)
+		return nil
+	}}
+}
+
+// findNext selects the next message (step 1) or the previous one (step -1)
+// containing the text last searched for, ignoring case and wrapping around
+// at either end of the conversation.
+func (m *model) findNext(step int) {
+	if m.findQuery == "" {
+		m.notice = "Nothing to find yet, search with Ctrl+F"
+		return
+	}
+	query := strings.ToLower(m.findQuery)
+	var matches []int
+	for i := m.firstVisible; i < len(m.messages); i++ {
+		if strings.Contains(strings.ToLower(m.messages[i].content), query) {
+			matches = append(matches, i)
+		}
+	}
+	if len(matches) == 0 {
+		m.notice = "No matches for " + m.findQuery
+		return
+	}
+	// Without a selection, searching starts after the last message
+	from := m.selected
+	if from < 0 {
+		from = len(m.messages)
+	}
+	n := -1
+	if step > 0 {
+		for j, i := range matches {
+			if i > from {
+				n = j
+				break
+			}
+		}
+		if n < 0 {
+			n = 0
+		}
+	} else {
+		for j := len(matches) - 1; j >= 0; j-- {
+			if matches[j] < from {
+				n = j
+				break
+			}
+		}
+		if n < 0 {
+			n = len(matches) - 1
+		}
+	}
+	m.selectMessage(matches[n])
+	m.notice = fmt.Sprintf("Match %d of %d for %s", n+1, len(matches), m.findQuery)
+}
+
+// scrollToSelected scrolls the conversation as little as it takes for the
+// selected message to be in view.
+func (m *model) scrollToSelected() {
+	if m.selected < 0 {
+		return
+	}
+	top, height := m.messageRows(m.selected)
+	bottom := top + height
+	switch {
+	case top < m.viewport.YOffset:
+		m.viewport.SetYOffset(top)
+	case bottom > m.viewport.YOffset+m.viewport.Height:
+		m.viewport.SetYOffset(min(bottom-m.viewport.Height, top))
+	}
+}
+
+// messageRows returns the line of the conversation message i starts on and
+// how many lines it takes, as wrapped to the screen.
+func (m model) messageRows(i int) (top, height int) {
+	top = strings.Count(m.wrapBody(m.renderTurns(i)), "\n")
+	return top, strings.Count(m.wrapBody(m.renderTurn(i)), "\n")
+}
+
+// highlightMatches marks every occurrence of query in a rendered block,
+// ignoring case. Styling around a match is kept, though the rest of its line
+// may lose its colour after the mark.
+func highlightMatches(block, query string) string {
+	if query == "" {
+		return block
+	}
+	query = strings.ToLower(query)
+	lines := strings.Split(block, "\n")
+	for i, line := range lines {
+		plain := ansi.Strip(line)
+		lower := strings.ToLower(plain)
+		// Offsets into lower only carry over if lowering kept the length
+		if len(lower) != len(plain) || !strings.Contains(lower, query) {
+			continue
+		}
+		var b strings.Builder
+		cell, start := 0, 0
+		for {
+			j := strings.Index(lower[start:], query)
+			if j < 0 {
+				break
+			}
+			from := ansi.StringWidth(plain[:start+j])
+			to := from + ansi.StringWidth(plain[start+j:start+j+len(query)])
+			b.WriteString(ansi.Cut(line, cell, from))
+			b.WriteString(matchStyle.Render(plain[start+j : start+j+len(query)]))
+			cell, start = to, start+j+len(query)
+		}
+		b.WriteString(ansi.Cut(line, cell, ansi.StringWidth(plain)))
+		lines[i] = b.String()
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	geminiBaseURL      = "https://generativelanguage.googleapis.com"
+	defaultGeminiModel = "gemini-2.5-flash"
+)
+
+// geminiProvider talks to Google's Gemini API. Several choices cannot be
+// streamed, so every response is choice 0.
+type geminiProvider struct {
+	apiKey  string
+	baseURL string
+	client  *http.Client
+}
+
+func newGeminiProvider(p profile) (provider, error) {
+	baseURL := p.BaseURL
+	if baseURL == "" {
+		baseURL = geminiBaseURL
+	}
+	return &geminiProvider{
+		apiKey:  p.APIKey,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  http.DefaultClient,
+	}, nil
+}
+
+// geminiError is an error response from the Gemini API.
+type geminiError struct {
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *geminiError) Error() string {
+	return fmt.Sprintf("gemini: %d %s: %s", e.StatusCode, e.Status, e.Message)
+}
+
+// geminiBlocked reports a prompt or response withheld by Gemini's safety
+// filters, which would only be blocked again if retried.
+type geminiBlocked struct {
+	prompt bool // the prompt was blocked, rather than the response
+	reason string
+}
+
+func (e *geminiBlocked) Error() string {
+	if e.prompt {
+		return fmt.Sprintf("gemini: the prompt was blocked (%s)", e.reason)
+	}
+	return fmt.Sprintf("gemini: the response was blocked (%s)", e.reason)
+}
+
+type geminiPart struct {
+	Text string `json:"text,omitempty"`
+	// Thought marks text summarizing the model's thinking.
+	Thought          bool                    `json:"thought,omitempty"`
+	InlineData       *geminiBlob             `json:"inlineData,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type geminiFunctionCall struct {
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+type geminiFunctionResponse struct {
+	Name     string         `json:"name"`
+	Response map[string]any `json:"response"`
+}
+
+// geminiTool declares the tools the model may call.
+type geminiTool struct {
+	FunctionDeclarations []geminiFunctionDeclaration `json:"functionDeclarations"`
+}
+
+type geminiFunctionDeclaration struct {
+	Name                 string         `json:"name"`
+	Description          string         `json:"description"`
+	ParametersJSONSchema map[string]any `json:"parametersJsonSchema"`
+}
+
+// geminiBlob is an image sent inline in a part.
+type geminiBlob struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiRequest struct {
+	Contents          []geminiContent        `json:"contents"`
+	SystemInstruction *geminiContent         `json:"systemInstruction,omitempty"`
+	Tools             []geminiTool           `json:"tools,omitempty"`
+	GenerationConfig  geminiGenerationConfig `json:"generationConfig"`
+}
+
+type geminiGenerationConfig struct {
+	StopSequences      []string `json:"stopSequences,omitempty"`
+	Temperature        *float64 `json:"temperature,omitempty"`
+	TopP               *float64 `json:"topP,omitempty"`
+	MaxOutputTokens    *int64   `json:"maxOutputTokens,omitempty"`
+	PresencePenalty    *float64 `json:"presencePenalty,omitempty"`
+	FrequencyPenalty   *float64 `json:"frequencyPenalty,omitempty"`
+	Seed               *int64   `json:"seed,omitempty"`
+	ResponseMIMEType   string   `json:"responseMimeType,omitempty"`
+	ResponseJSONSchema any      `json:"responseJsonSchema,omitempty"`
+	// ThinkingConfig sets how long models that think may do so, and asks for
+	// summaries of their thoughts.
+	ThinkingConfig *geminiThinkingConfig `json:"thinkingConfig,omitempty"`
+}
+
+type geminiThinkingConfig struct {
+	ThinkingBudget  int64 `json:"thinkingBudget"`
+	IncludeThoughts bool  `json:"includeThoughts"`
+}
+
+// geminiResponse is one streamed piece of a response.
+type geminiResponse struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	PromptFeedback struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	// UsageMetadata counts the tokens so far, complete in the last piece.
+	UsageMetadata struct {
+		PromptTokenCount     int64 `json:"promptTokenCount"`
+		CandidatesTokenCount int64 `json:"candidatesTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+// geminiBlockReasons are the finish reasons meaning the response was
+// withheld rather than complete.
+var geminiBlockReasons = map[string]bool{
+	"SAFETY":             true,
+	"RECITATION":         true,
+	"BLOCKLIST":          true,
+	"PROHIBITED_CONTENT": true,
+	"SPII":               true,
+	"IMAGE_SAFETY":       true,
+}
+
+func (p *geminiProvider) stream(ctx context.Context, req request) (<-chan chunk, error) {
+	resp, err := p.do(ctx, http.MethodPost, "/v1beta/models/"+url.PathEscape(req.model)+":streamGenerateContent?alt=sse", geminiParams(req))
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan chunk)
+	go func() {
+		defer close(ch)
+		defer resp.Body.Close()
+		var end error
+		var used usage
+		var calls []toolCall
+		err := readEvents(resp.Body, func(data []byte) bool {
+			var r geminiResponse
+			if err := json.Unmarshal(data, &r); err != nil {
+				end = fmt.Errorf("gemini: malformed response: %w", err)
+				return false
+			}
+			if reason := r.PromptFeedback.BlockReason; reason != "" {
+				end = &geminiBlocked{prompt: true, reason: reason}
+				return false
+			}
+			used = usage{prompt: r.UsageMetadata.PromptTokenCount, completion: r.UsageMetadata.CandidatesTokenCount}
+			for _, candidate := range r.Candidates {
+				for _, part := range candidate.Content.Parts {
+					if part.Thought {
+						if part.Text != "" && !sendChunk(ctx, ch, chunk{reasoning: part.Text}) {
+							return false
+						}
+						continue
+					}
+					if part.Text != "" && !sendChunk(ctx, ch, chunk{content: part.Text}) {
+						return false
+					}
+					// Calls have no ids of their own
+					if call := part.FunctionCall; call != nil {
+						calls = append(calls, toolCall{id: newCallID(), name: call.Name, arguments: string(call.Args)})
+					}
+				}
+				if geminiBlockReasons[candidate.FinishReason] {
+					end = &geminiBlocked{reason: candidate.FinishReason}
+					return false
+				}
+			}
+			return true
+		})
+		// The stream has no end marker, so only a read error means it was
+		// cut short
+		if end == nil {
+			end = err
+		}
+		if end != nil {
+			sendChunk(ctx, ch, chunk{err: end})
+			return
+		}
+		if len(calls) > 0 && !sendChunk(ctx, ch, chunk{toolCalls: calls}) {
+			return
+		}
+		if used.tokens() > 0 {
+			sendChunk(ctx, ch, chunk{usage: &used})
+		}
+	}()
+	return ch, nil
+}
+
+// models lists the models that can generate chat responses.
+func (p *geminiProvider) models(ctx context.Context) ([]string, error) {
+	resp, err := p.do(ctx, http.MethodGet, "/v1beta/models?pageSize=1000", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var list struct {
+		Models []struct {
+			Name    string   `json:"name"`
+			Methods []string `json:"supportedGenerationMethods"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("gemini: malformed model list: %w", err)
+	}
+	var names []string
+	for _, m := range list.Models {
+		for _, method := range m.Methods {
+			if method == "generateContent" {
+				names = append(names, strings.TrimPrefix(m.Name, "models/"))
+				break
+			}
+		}
+	}
+	return names, nil
+}
+
+// do sends a request to the API, with body encoded as JSON unless it is nil,
+// and returns the response if it succeeded.
+func (p *geminiProvider) do(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(data)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, p.baseURL+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	httpReq.Header.Set("X-Goog-Api-Key", p.apiKey)
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		apiErr := &geminiError{StatusCode: resp.StatusCode, Status: http.StatusText(resp.StatusCode), Message: resp.Status}
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+				Status  string `json:"status"`
+			} `json:"error"`
+		}
+		if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&e); err == nil && e.Error.Message != "" {
+			apiErr.Status = e.Error.Status
+			apiErr.Message = e.Error.Message
+		}
+		return nil, apiErr
+	}
+	return resp, nil
+}
+
+// geminiParams translates a request into the Gemini format, where system
+// messages become the system instruction and the assistant's role is
+// "model".
+func geminiParams(req request) geminiRequest {
+	params := geminiRequest{
+		Contents: []geminiContent{},
+		GenerationConfig: geminiGenerationConfig{
+			StopSequences:    req.stop,
+			Temperature:      req.temperature,
+			TopP:             req.topP,
+			MaxOutputTokens:  req.maxTokens,
+			PresencePenalty:  req.presencePenalty,
+			FrequencyPenalty: req.frequencyPenalty,
+			Seed:             req.seed,
+		},
+	}
+	var system []geminiPart
+	for _, msg := range req.messages {
+		switch msg.role {
+		case "system":
+			system = append(system, geminiPart{Text: msg.content})
+		case "assistant":
+			var parts []geminiPart
+			if msg.content != "" || len(msg.toolCalls) == 0 {
+				parts = append(parts, geminiPart{Text: msg.content})
+			}
+			for _, call := range msg.toolCalls {
+				args := json.RawMessage(call.arguments)
+				if !json.Valid(args) {
+					args = nil
+				}
+				parts = append(parts, geminiPart{FunctionCall: &geminiFunctionCall{Name: call.name, Args: args}})
+			}
+			params.Contents = append(params.Contents, geminiContent{Role: "model", Parts: parts})
+		case "tool":
+			part := geminiPart{FunctionResponse: &geminiFunctionResponse{Name: msg.toolName, Response: map[string]any{"content": msg.content}}}
+			// The results of one response's calls go together
+			if n := len(params.Contents); n > 0 && params.Contents[n-1].Parts[0].FunctionResponse != nil {
+				params.Contents[n-1].Parts = append(params.Contents[n-1].Parts, part)
+				break
+			}
+			params.Contents = append(params.Contents, geminiContent{Role: "user", Parts: []geminiPart{part}})
+		default:
+			parts := []geminiPart{{Text: msg.content}}
+			for _, img := range msg.images {
+				parts = append(parts, geminiPart{InlineData: &geminiBlob{MimeType: img.mime, Data: img.base64()}})
+			}
+			params.Contents = append(params.Contents, geminiContent{Role: "user", Parts: parts})
+		}
+	}
+	if len(system) > 0 {
+		params.SystemInstruction = &geminiContent{Parts: system}
+	}
+	if len(req.tools) > 0 {
+		var declarations []geminiFunctionDeclaration
+		for _, t := range req.tools {
+			declarations = append(declarations, geminiFunctionDeclaration{Name: t.name, Description: t.description, ParametersJSONSchema: t.parameters})
+		}
+		params.Tools = []geminiTool{{FunctionDeclarations: declarations}}
+	}
+	if req.json {
+		params.GenerationConfig.ResponseMIMEType = "application/json"
+		if req.schema != nil {
+			params.GenerationConfig.ResponseJSONSchema = req.schema.schema
+		}
+	}
+	// Models that cannot think reject the setting, so it is only sent when
+	// asked for
+	if req.reasoningEffort != "" {
+		params.GenerationConfig.ThinkingConfig = &geminiThinkingConfig{ThinkingBudget: thinkingBudget(req.reasoningEffort), IncludeThoughts: true}
+	}
+	return params
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxSearchResults caps how many messages a search across sessions lists.
+const maxSearchResults = 100
+
+// snippetContext is about how many characters of a message are shown on each
+// side of the first match.
+const snippetContext = 40
+
+// messageMatch is a saved message found by a search across sessions.
+type messageMatch struct {
+	session  string
+	title    string
+	position int // index of the message in the session
+	role     string
+	content  string
+	time     time.Time
+}
+
+// searchMessages finds the saved messages containing every word of query,
+// ignoring case, in the most recently saved sessions first.
+func searchMessages(query string) ([]messageMatch, error) {
+	words := strings.Fields(query)
+	if len(words) == 0 {
+		return nil, nil
+	}
+	db, err := openStore()
+	if err != nil {
+		return nil, err
+	}
+	q := `SELECT s.name, s.title, m.position, m.role, m.content, m.time
+		FROM messages m JOIN sessions s ON s.id = m.session_id WHERE m.error = ''`
+	var args []any
+	for _, w := range words {
+		q += ` AND m.content LIKE ? ESCAPE '\'`
+		args = append(args, "%"+escapeLike(w)+"%")
+	}
+	q += ` ORDER BY s.saved DESC, s.name, m.position LIMIT ?`
+	args = append(args, maxSearchResults)
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("search sessions: %w", err)
+	}
+	defer rows.Close()
+	var matches []messageMatch
+	for rows.Next() {
+		var match messageMatch
+		var sent string
+		if err := rows.Scan(&match.session, &match.title, &match.position, &match.role, &match.content, &sent); err != nil {
+			return nil, fmt.Errorf("search sessions: %w", err)
+		}
+		match.time = parseTime(sent)
+		matches = append(matches, match)
+	}
+	return matches, rows.Err()
+}
+
+// snippet returns the line of a message around the first word of query it
+// contains, with about snippetContext characters on either side.
+func (match messageMatch) snippet(query string) string {
+	text := strings.Join(strings.Fields(match.content), " ")
+	i := -1
+	if words := strings.Fields(query); len(words) > 0 {
+		i = strings.Index(strings.ToLower(text), strings.ToLower(words[0]))
+	}
+	if i < 0 || len(text) != len(strings.ToLower(text)) {
+		i = 0
+	}
+	start, end := max(i-snippetContext, 0), min(i+snippetContext*2, len(text))
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+	s := text[start:end]
+	if start > 0 {
+		s = "…" + s
+	}
+	if end < len(text) {
+		s += "…"
+	}
+	return s
+}
+
+// who names the sender of a matched message.
+func (match messageMatch) who() string {
+	switch match.role {
+	case "user":
+		return activeLabels.User
+	case "assistant":
+		return activeLabels.Assistant
+	}
+	return match.role
+}
+
+// printSearch lists the saved messages matching query, a line each with the
+// session and message number to open it at, as in --session name:N.
+func printSearch(w io.Writer, query string) error {
+	matches, err := searchMessages(query)
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("no saved messages match %q", query)
+	}
+	for _, match := range matches {
+		when := ""
+		if !match.time.IsZero() {
+			when = formatTimestamp(match.time) + "  "
+		}
+		fmt.Fprintf(w, "%s:%d  %s%s: %s\n", match.session, match.position+1, when, match.who(), match.snippet(query))
+	}
+	return nil
+}
+
+func runSearch(m *model, args string) tea.Cmd {
+	m.palette = &palette{query: args, entries: searchEntries, search: true, noMatches: "No saved messages match", verb: "open the session there"}
+	return nil
+}
+
+// searchEntries lists the saved messages matching what is typed in the
+// palette, each opening its session with the message selected.
+func searchEntries(m model) []paletteEntry {
+	query := m.palette.query
+	matches, err := searchMessages(query)
+	if err != nil {
+		return nil
+	}
+	entries := make([]paletteEntry, len(matches))
+	for i, match := range matches {
+		name := match.session
+		if match.title != "" {
+			name = match.title
+		}
+		entries[i] = paletteEntry{
+			label: truncateLine(fmt.Sprintf("%s · %s: %s", name, match.who(), match.snippet(query)), m.width-2),
+			run: func(m *model) tea.Cmd {
+				cmd := m.openSession(sessionSummary{name: match.session})
+				if m.sessionName != match.session {
+					return cmd
+				}
+				// Highlight the words searched for, together if they are
+				// found together
+				m.findQuery = query
+				if !strings.Contains(strings.ToLower(match.content), strings.ToLower(query)) {
+					m.findQuery = strings.Fields(query)[0]
+				}
+				// Scrolling to the message needs the session laid out first
+				m.syncViewport()
+				m.selectMessage(match.position)
+				return cmd
+			},
+		}
+	}
+	return entries
+}
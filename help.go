@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// helpGroups is the order categories of commands and key bindings are listed
+// in on the help screen.
+var helpGroups = []string{"General", "Conversation", "Messages", "Tabs", "Models", "Generation", "Saving"}
+
+// helpScreen is the full-screen list of key bindings and commands.
+type helpScreen struct {
+	offset int
+}
+
+var helpKeyStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#04B575"))
+
+func openHelp(m *model) tea.Cmd {
+	m.help = &helpScreen{}
+	return nil
+}
+
+// helpLines renders the help screen contents, grouped by category, from the
+// command and key registries.
+func helpLines() []string {
+	type row struct{ label, help string }
+	rows := make(map[string][]row)
+	width := 0
+	for _, b := range keyBindings {
+		rows[b.group] = append(rows[b.group], row{b.label(), b.help})
+		width = max(width, lipgloss.Width(b.label()))
+	}
+	for _, c := range commands {
+		rows[c.group] = append(rows[c.group], row{c.usage, c.help})
+		width = max(width, lipgloss.Width(c.usage))
+	}
+
+	var lines []string
+	for _, group := range helpGroups {
+		if len(rows[group]) == 0 {
+			continue
+		}
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, titleStyle.Render(group))
+		for _, r := range rows[group] {
+			lines = append(lines, "  "+helpKeyStyle.Width(width+2).Render(r.label)+r.help)
+		}
+	}
+	return lines
+}
+
+// helpRows is how many lines of help fit on screen below the title and above
+// the footer.
+func (m model) helpRows() int {
+	if m.height <= 0 {
+		return len(helpLines())
+	}
+	return max(m.height-4, 1)
+}
+
+// handleHelpKey scrolls or closes the help screen.
+func (m *model) handleHelpKey(msg tea.KeyMsg) {
+	maxOffset := max(len(helpLines())-m.helpRows(), 0)
+	switch msg.String() {
+	case "esc", "?", "q":
+		m.help = nil
+	case "up", "k":
+		m.help.offset = max(m.help.offset-1, 0)
+	case "down", "j":
+		m.help.offset = min(m.help.offset+1, maxOffset)
+	case "pgup":
+		m.help.offset = max(m.help.offset-m.helpRows(), 0)
+	case "pgdown", " ":
+		m.help.offset = min(m.help.offset+m.helpRows(), maxOffset)
+	}
+}
+
+func (m model) helpView() string {
+	lines := helpLines()
+	start := min(m.help.offset, len(lines))
+	end := min(start+m.helpRows(), len(lines))
+
+	var b strings.Builder
+	b.WriteString(titleStyle.Render("Keys and commands") + "\n\n")
+	b.WriteString(strings.Join(lines[start:end], "\n") + "\n\n")
+	b.WriteString(helpStyle.Render("Up/Down or PgUp/PgDn to scroll, Esc or ? to close"))
+	return b.String()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxImageSize caps the size of an attached image, the most providers accept.
+const maxImageSize = 20 * 1024 * 1024
+
+// imageTokens is roughly what an image takes of the context window. It
+// depends on the image's size and the model, so this only keeps estimates
+// from ignoring images.
+const imageTokens = 1000
+
+// imageExtensions are the extensions of the image formats vision models read.
+var imageExtensions = []string{".png", ".jpg", ".jpeg", ".gif", ".webp"}
+
+// attachedImage is an image sent with a user message.
+type attachedImage struct {
+	name string // the file's name, shown in its place
+	mime string
+	data []byte
+}
+
+// isImage reports whether path names an image by its extension.
+func isImage(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range imageExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// readImage reads an image file to attach, checking it is one and not too
+// large.
+func readImage(path string) (attachedImage, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return attachedImage{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return attachedImage{}, fmt.Errorf("%s is not a file", path)
+	}
+	if info.Size() > maxImageSize {
+		return attachedImage{}, fmt.Errorf("%s is %d MB, over the %d MB limit for images", path, info.Size()>>20, maxImageSize>>20)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return attachedImage{}, err
+	}
+	mime := http.DetectContentType(data)
+	if !strings.HasPrefix(mime, "image/") {
+		return attachedImage{}, fmt.Errorf("%s is not an image", path)
+	}
+	return attachedImage{name: filepath.Base(path), mime: mime, data: data}, nil
+}
+
+// base64 returns the image's data encoded as base64, as providers take it.
+func (img attachedImage) base64() string {
+	return base64.StdEncoding.EncodeToString(img.data)
+}
+
+// dataURL returns the image as a data: URL.
+func (img attachedImage) dataURL() string {
+	return "data:" + img.mime + ";base64," + img.base64()
+}
+
+// placeholder stands in for the image in the conversation.
+func (img attachedImage) placeholder() string {
+	return "[image: " + img.name + "]"
+}
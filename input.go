@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/cursor"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textarea"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// newInput returns the message composer. It always has focus, since prompts
+// and dialogs take keys before it gets them. Enter sends, so newlines are
+// typed with Alt+Enter or Ctrl+J, and the composer grows with its text up to
+// maxHeight rows.
+func newInput(maxHeight int) textarea.Model {
+	in := textarea.New()
+	in.ShowLineNumbers = false
+	// Long messages are checked against max_input_length when sent instead
+	in.CharLimit = 0
+	in.MaxHeight = maxHeight
+	in.FocusedStyle = textarea.Style{}
+	in.Cursor.Style = inputStyle
+	in.Cursor.SetMode(cursor.CursorStatic)
+	in.KeyMap.InsertNewline = key.NewBinding(key.WithKeys("alt+enter", "ctrl+j"))
+	// Alt+Left and Alt+Right switch tabs
+	in.KeyMap.WordForward = key.NewBinding(key.WithKeys("ctrl+right", "alt+f"))
+	in.KeyMap.WordBackward = key.NewBinding(key.WithKeys("ctrl+left", "alt+b"))
+
+	// The label only goes before the first row, with later rows lined up
+	label := userLabel(inputStyle)
+	indent := strings.Repeat(" ", lipgloss.Width(label))
+	in.SetPromptFunc(lipgloss.Width(label), func(row int) string {
+		if row == 0 {
+			return label
+		}
+		return indent
+	})
+	in.Focus()
+	in.SetHeight(1)
+	return in
+}
+
+// setInput replaces the input, leaving the cursor at the end of it.
+func (m *model) setInput(s string) {
+	m.input.SetValue(s)
+	m.input.CursorEnd()
+	m.fitInput()
+	m.mentions = mentionsIn(s)
+}
+
+// editInput passes a key on to the input: typing, pasting, moving the cursor
+// and deleting characters or words.
+func (m *model) editInput(msg tea.KeyMsg) tea.Cmd {
+	var cmd tea.Cmd
+	before := m.input.Value()
+	m.input, cmd = m.input.Update(msg)
+	m.fitInput()
+	if value := m.input.Value(); value != before {
+		m.mentions = mentionsIn(value)
+	}
+	return cmd
+}
+
+// fitInput sizes the input to the terminal width and to the rows its text
+// wraps to, up to its maximum height.
+func (m *model) fitInput() {
+	if m.width > 0 {
+		// Leave a column for the cursor at the end of a row
+		m.input.SetWidth(m.width - 1)
+	}
+	width := max(m.input.Width(), 1)
+	rows := 0
+	for _, line := range strings.Split(m.input.Value(), "\n") {
+		rows += max(int(math.Ceil(float64(lipgloss.Width(line)+1)/float64(width))), 1)
+	}
+	if rows == m.input.Height() {
+		return
+	}
+	m.input.SetHeight(rows)
+	// Updating without a message scrolls the cursor back into view
+	m.input, _ = m.input.Update(nil)
+}
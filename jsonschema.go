@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// errNotJSON marks a response in JSON mode that is not valid JSON at all.
+var errNotJSON = errors.New("is not valid JSON")
+
+// checkJSON reports why a response in JSON mode is not valid JSON, or does
+// not follow schema when one is given.
+func checkJSON(content string, schema *jsonSchema) error {
+	var v any
+	if err := json.Unmarshal([]byte(strings.TrimSpace(content)), &v); err != nil {
+		return errNotJSON
+	}
+	if schema == nil {
+		return nil
+	}
+	if err := matchSchema(v, schema.schema, "$"); err != nil {
+		return fmt.Errorf("does not follow the schema: %w", err)
+	}
+	return nil
+}
+
+// matchSchema checks a decoded JSON value, found at path, against a schema.
+// Only the keywords structured outputs support are checked: type, enum,
+// const, properties, required, additionalProperties, items and anyOf. The
+// others, such as $ref, are taken to match.
+func matchSchema(v any, schema map[string]any, path string) error {
+	if types := schemaTypes(schema["type"]); len(types) > 0 && !slices.ContainsFunc(types, func(t string) bool { return hasType(v, t) }) {
+		return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(types, " or "), jsonType(v))
+	}
+	if enum, ok := schema["enum"].([]any); ok && !slices.ContainsFunc(enum, func(e any) bool { return reflect.DeepEqual(e, v) }) {
+		return fmt.Errorf("%s: %s is not one of the allowed values", path, compactJSON(v))
+	}
+	if c, ok := schema["const"]; ok && !reflect.DeepEqual(c, v) {
+		return fmt.Errorf("%s: expected %s, got %s", path, compactJSON(c), compactJSON(v))
+	}
+	if anyOf, ok := schema["anyOf"].([]any); ok && len(anyOf) > 0 {
+		// Matching none, the last is reported
+		var err error
+		for _, option := range anyOf {
+			s, _ := option.(map[string]any)
+			if err = matchSchema(v, s, path); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		properties, _ := schema["properties"].(map[string]any)
+		if required, ok := schema["required"].([]any); ok {
+			for _, name := range required {
+				if name, ok := name.(string); ok {
+					if _, ok := v[name]; !ok {
+						return fmt.Errorf("%s: missing %q", path, name)
+					}
+				}
+			}
+		}
+		// Properties are checked in order, so the same one is always
+		// reported first
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		for _, name := range names {
+			if s, ok := properties[name].(map[string]any); ok {
+				if err := matchSchema(v[name], s, path+"."+name); err != nil {
+					return err
+				}
+				continue
+			}
+			switch extra := schema["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					return fmt.Errorf("%s: unexpected %q", path, name)
+				}
+			case map[string]any:
+				if err := matchSchema(v[name], extra, path+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				if err := matchSchema(item, items, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// schemaTypes returns the types a schema's type keyword allows, which is a
+// name or a list of them.
+func schemaTypes(t any) []string {
+	switch t := t.(type) {
+	case string:
+		return []string{t}
+	case []any:
+		var types []string
+		for _, name := range t {
+			if name, ok := name.(string); ok {
+				types = append(types, name)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+// hasType reports whether a decoded JSON value is of the named schema type.
+func hasType(v any, t string) bool {
+	switch t {
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f)
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	}
+	return jsonType(v) == t
+}
+
+// jsonType names the type of a decoded JSON value as schemas do.
+func jsonType(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	}
+	return "object"
+}
+
+func compactJSON(v any) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
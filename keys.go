@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// keyBinding documents a keyboard shortcut. Global bindings are dispatched
+// from the registry whenever no prompt or dialog has focus; the rest depend on
+// context (such as an empty input) and are handled in Update, but can still be
+// run from the command palette when run is set.
+type keyBinding struct {
+	// name identifies a global binding in the config's [keys] table.
+	name string
+	keys []string
+	help string
+	// group is the category the binding is listed under on the help screen.
+	group  string
+	global bool
+	run    func(m *model) tea.Cmd
+}
+
+// keyBindings is the registry of keyboard shortcuts. It is populated in init
+// because some bindings refer back to the registry.
+var keyBindings []keyBinding
+
+func init() {
+	keyBindings = []keyBinding{
+		{keys: []string{"enter"}, help: "Send the message or run the command", group: "General"},
+		{keys: []string{"alt+enter", "ctrl+j"}, help: "Start a new line in the message", group: "General"},
+		{name: "palette", keys: []string{"ctrl+p"}, help: "Open the command palette", group: "General", global: true, run: openPalette},
+		{name: "next_model", keys: []string{"ctrl+n"}, help: "Switch to the next recently used model", group: "Models", global: true, run: func(m *model) tea.Cmd {
+			m.cycleModel()
+			return nil
+		}},
+		{name: "pick_model", keys: []string{"alt+m"}, help: "Pick a model to switch to", group: "Models", global: true, run: openModelPicker},
+		{name: "pick_profile", keys: []string{"alt+p"}, help: "Pick a profile to switch to", group: "Models", global: true, run: openProfilePicker},
+		{name: "retry", keys: []string{"ctrl+g"}, help: "Regenerate the last response, or retry a failed one", group: "Conversation", global: true, run: func(m *model) tea.Cmd {
+			return m.regenerate("", nil)
+		}},
+		{name: "stop", keys: []string{"ctrl+x"}, help: "Stop the response, keeping what has arrived", group: "Generation", global: true, run: func(m *model) tea.Cmd {
+			if !m.stopResponse() {
+				m.notice = "No response to stop"
+			}
+			return nil
+		}},
+		{name: "find", keys: []string{"ctrl+f"}, help: "Find text in the conversation", group: "Messages", global: true, run: openFind},
+		{name: "search_prompts", keys: []string{"ctrl+r"}, help: "Search the prompts sent before", group: "General", global: true, run: openPromptSearch},
+		{name: "sessions", keys: []string{"ctrl+o"}, help: "Open the session picker", group: "Saving", global: true, run: openSessionPicker},
+		{name: "save_template", keys: []string{"ctrl+s"}, help: "Save the input as a prompt template", group: "Saving", global: true, run: func(m *model) tea.Cmd {
+			m.promptSaveTemplate()
+			return nil
+		}},
+		{name: "copy_last", keys: []string{"ctrl+y"}, help: "Copy the last response to the clipboard", group: "Messages", global: true, run: func(m *model) tea.Cmd {
+			m.copyLastResponse()
+			return nil
+		}},
+		{name: "toggle_raw", keys: []string{"alt+r"}, help: "Switch a response between formatted and raw text", group: "Messages", global: true, run: func(m *model) tea.Cmd {
+			m.toggleRaw()
+			return nil
+		}},
+		{name: "toggle_thinking", keys: []string{"alt+h"}, help: "Show or hide the thinking of a reasoning model's response", group: "Messages", global: true, run: func(m *model) tea.Cmd {
+			m.toggleThinking()
+			return nil
+		}},
+		{name: "open_editor", keys: []string{"ctrl+e"}, help: "Open a response in $EDITOR", group: "Messages", global: true, run: func(m *model) tea.Cmd {
+			return m.openInEditor()
+		}},
+		{name: "new_tab", keys: []string{"ctrl+t", "alt+t"}, help: "Open a new tab", group: "Tabs", global: true, run: newTab},
+		{name: "close_tab", keys: []string{"alt+w"}, help: "Close the tab", group: "Tabs", global: true, run: closeTab},
+		{name: "next_tab", keys: []string{"ctrl+tab", "alt+right"}, help: "Switch to the next tab", group: "Tabs", global: true, run: func(m *model) tea.Cmd {
+			m.cycleTab(1)
+			return nil
+		}},
+		{name: "previous_tab", keys: []string{"ctrl+shift+tab", "alt+left"}, help: "Switch to the previous tab", group: "Tabs", global: true, run: func(m *model) tea.Cmd {
+			m.cycleTab(-1)
+			return nil
+		}},
+		{keys: []string{"alt+1…9"}, help: "Switch to the numbered tab", group: "Tabs"},
+		{name: "page_up", keys: []string{"pgup"}, help: "Scroll the conversation up a page", group: "Conversation", global: true, run: func(m *model) tea.Cmd {
+			m.viewport.PageUp()
+			return nil
+		}},
+		{name: "page_down", keys: []string{"pgdown"}, help: "Scroll the conversation down a page", group: "Conversation", global: true, run: func(m *model) tea.Cmd {
+			m.viewport.PageDown()
+			return nil
+		}},
+		{keys: []string{"up"}, help: "Recall the previous prompt sent (input empty)", group: "General", run: func(m *model) tea.Cmd {
+			m.recallPrompt(1)
+			return nil
+		}},
+		{keys: []string{"down"}, help: "Recall the next prompt sent (recalling)", group: "General"},
+		{keys: []string{"alt+up", "k"}, help: "Select the previous message (k, Up and Down once one is selected)", group: "Messages", run: func(m *model) tea.Cmd {
+			m.selectPrevious()
+			return nil
+		}},
+		{keys: []string{"alt+down", "j"}, help: "Select the next message", group: "Messages", run: func(m *model) tea.Cmd {
+			m.selectNext()
+			return nil
+		}},
+		{keys: []string{"n", "N"}, help: "Select the next or previous match of the text found (input empty)", group: "Messages", run: func(m *model) tea.Cmd {
+			m.findNext(1)
+			return nil
+		}},
+		{keys: []string{"tab"}, help: "Collapse or expand a long response (input empty)", group: "Messages", run: func(m *model) tea.Cmd {
+			m.toggleCollapsed()
+			return nil
+		}},
+		{keys: []string{"e"}, help: "Edit the selected message and send it again, dropping the messages after it", group: "Messages", run: func(m *model) tea.Cmd {
+			if m.selected < 0 {
+				m.notice = "Select the message to edit first"
+				return nil
+			}
+			m.editMessage()
+			return nil
+		}},
+		{keys: []string{"d", "delete"}, help: "Delete the selected message", group: "Messages", run: func(m *model) tea.Cmd {
+			m.confirmDelete(false)
+			return nil
+		}},
+		{keys: []string{"D"}, help: "Delete the selected message and its pair", group: "Messages", run: func(m *model) tea.Cmd {
+			m.confirmDelete(true)
+			return nil
+		}},
+		{keys: []string{"y"}, help: "Copy the selected message as raw markdown", group: "Messages", run: func(m *model) tea.Cmd {
+			m.copyMessage(true)
+			return nil
+		}},
+		{keys: []string{"Y"}, help: "Copy the selected message as plain text", group: "Messages", run: func(m *model) tea.Cmd {
+			m.copyMessage(false)
+			return nil
+		}},
+		{keys: []string{"esc"}, help: "Cancel a queued message, stop the response, drop attachments, or clear the search or selection", group: "Messages"},
+		{keys: []string{"?"}, help: "Show all keys and commands (input empty)", group: "General", run: openHelp},
+		{keys: []string{"ctrl+c", "q"}, help: "Quit (q with the input empty)", group: "General", run: func(m *model) tea.Cmd { return m.quit() }},
+	}
+}
+
+// rebindKeys replaces the keys of global bindings as configured, each action
+// name mapping to a key or a comma separated list of keys.
+func rebindKeys(keys map[string]string) error {
+	for name, value := range keys {
+		i := slices.IndexFunc(keyBindings, func(b keyBinding) bool { return b.name == name })
+		if i < 0 {
+			return fmt.Errorf("keys: unknown action %q", name)
+		}
+		var bound []string
+		for _, k := range strings.Split(value, ",") {
+			if k = strings.TrimSpace(k); k != "" {
+				bound = append(bound, k)
+			}
+		}
+		if len(bound) == 0 {
+			return fmt.Errorf("keys: no key given for %s", name)
+		}
+		keyBindings[i].keys = bound
+	}
+	return nil
+}
+
+// globalBinding returns the global binding for key, if there is one.
+func globalBinding(key string) (keyBinding, bool) {
+	for _, b := range keyBindings {
+		if !b.global {
+			continue
+		}
+		for _, k := range b.keys {
+			if k == key {
+				return b, true
+			}
+		}
+	}
+	return keyBinding{}, false
+}
+
+func (b keyBinding) label() string {
+	return strings.Join(b.keys, "/")
+}
+
+// bindingLabel returns the keys bound to the named action, as configured, for
+// hints that mention them.
+func bindingLabel(name string) string {
+	i := slices.IndexFunc(keyBindings, func(b keyBinding) bool { return b.name == name })
+	if i < 0 {
+		return name
+	}
+	return keyBindings[i].label()
+}
+
+// extendedKeys names the sequences terminals that tell more keys apart send
+// for keys Bubble Tea does not know, by how it reports them. Ctrl+Tab is sent
+// as a plain Tab by most terminals, but as one of these by those using
+// xterm's modifyOtherKeys or the CSI u encoding.
+var extendedKeys = map[string]string{
+	unknownSequence("\x1b[27;5;9~"): "ctrl+tab",
+	unknownSequence("\x1b[27;6;9~"): "ctrl+shift+tab",
+	unknownSequence("\x1b[9;5u"):    "ctrl+tab",
+	unknownSequence("\x1b[9;6u"):    "ctrl+shift+tab",
+}
+
+// unknownSequence formats a CSI sequence as Bubble Tea describes one it does
+// not recognize.
+func unknownSequence(seq string) string {
+	return fmt.Sprintf("?CSI%+v?", []byte(seq)[2:])
+}
+
+// extendedKey returns the name of the key msg reports, if it is one of
+// extendedKeys.
+func extendedKey(msg tea.Msg) (string, bool) {
+	s, ok := msg.(fmt.Stringer)
+	if !ok {
+		return "", false
+	}
+	if _, isKey := msg.(tea.KeyMsg); isKey {
+		return "", false
+	}
+	key, ok := extendedKeys[s.String()]
+	return key, ok
+}
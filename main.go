@@ -2,57 +2,174 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"log"
+	"net"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
+	"github.com/charmbracelet/bubbles/textarea"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
-	"github.com/joho/godotenv"
-	"github.com/openai/openai-go"
-	"github.com/openai/openai-go/option"
+	"github.com/charmbracelet/x/ansi"
 )
 
 type model struct {
-	client      *openai.Client
-	modelName   string
-	messages    []chatMessage
-	input       string
-	viewport    string
-	loading     bool
-	streaming   bool
-	partialResp string
-	err         error
-	streamChan  chan string
+	// conversation is the active tab; its fields are promoted so most code
+	// works on the active conversation without knowing about tabs.
+	*conversation
+	tabs    []*conversation
+	nextTab int // id of the next tab to be opened
+
+	input      textarea.Model
+	err        error  // fatal startup or configuration error
+	queueSends bool   // queue messages sent while a response is in flight
+	notice     string // transient hint shown in the footer
+	transcript *transcript
+	// mentions are the files the input mentions, looked up as it changes.
+	mentions mentions
+
+	config       config
+	confirm      *confirmation // pending yes/no question, if any
+	prompt       *textPrompt   // pending single-line question, if any
+	width        int           // terminal size, zero until first reported
+	height       int
+	setup        *profile // settings gathered by the first-run setup, if running
+	recentModels []string // recently used models, most recent first
+	// providerModels are the models the provider offers, if it can list
+	// them, for completion.
+	providerModels []string
+	jsonSchema     *jsonSchema
+	palette        *palette
+	help           *helpScreen
+	sessions       *sessionPicker
+	// mcpClients are the connected MCP servers, shared by every tab.
+	mcpClients []*mcpClient
+	// baseTemperature is the configured temperature, which switching to a
+	// profile without one of its own goes back to.
+	baseTemperature *float64
+	// vim is the mode keys are taken in with vim_mode set, vimPending a key
+	// waiting for the next to complete it, as the g of gg, and visualStart
+	// the message the range selected in visual mode starts at.
+	vim         vimMode
+	vimPending  string
+	visualStart int
 }
 
-type chatMessage struct {
-	role    string
-	content string
+// textPrompt asks for a line of text, such as a name, in the footer.
+type textPrompt struct {
+	label    string
+	value    string
+	mask     bool // hide what is typed, for secrets
+	onSubmit func(m *model, value string) tea.Cmd
 }
 
-type msgResponse struct {
-	content string
-	err     error
+// confirmation is a yes/no question guarding a destructive action.
+type confirmation struct {
+	prompt    string
+	action    func(m *model) tea.Cmd
+	saveFirst bool // offer to save the conversation before going ahead
+	// decline, if set, is run when the answer is no.
+	decline func(m *model) tea.Cmd
+	// stream, if set, is the streamDone of the response the question is
+	// about. The question is dropped when that response ends.
+	stream chan struct{}
 }
 
-type msgStreamChunk struct {
-	chunk string
-	done  bool
-	err   error
+type chatMessage struct {
+	role    string
+	content string
+	err     error     // set on an assistant turn whose request failed
+	sent    time.Time // when the message was sent or the response completed
+	// collapsed shows only the first few lines of a long response.
+	collapsed bool
+	// discarded counts the messages that followed this one before the
+	// conversation was branched here.
+	discarded int
+	// interrupted is the error that cut off a partially received response.
+	interrupted error
+	// json marks a response requested in JSON mode, shown pretty-printed,
+	// and jsonErr says why it is not valid JSON or does not follow the
+	// schema it was requested with.
+	json    bool
+	jsonErr error
+	// raw shows the response exactly as received, without any formatting.
+	raw bool
+	// model is set on a response from a model other than the conversation's,
+	// such as one regenerated with /retry <model>.
+	model string
+	// usage is what the request for a response used, when reported.
+	usage usage
+	// compacted marks a message replaced by the conversation's summary in
+	// requests.
+	compacted bool
+	// images are sent with a user message, shown by placeholders.
+	images []attachedImage
+	// toolCalls are the tools a response asked to run. Their results follow
+	// it as messages of the role "tool", naming the call they answer.
+	toolCalls  []toolCall
+	toolCallID string
+	toolName   string
+	// sources are the indexed documents the response was given, cited under
+	// it by number.
+	sources []string
+	// reasoning is the thinking a reasoning model did before responding,
+	// shown folded away unless showReasoning is set.
+	reasoning     string
+	showReasoning bool
 }
 
+// The stream messages carry the id of the tab whose request they belong to.
 type (
-	streamStartMsg  struct{}
+	streamStartMsg  struct{ tab int }
 	streamUpdateMsg struct {
-		content string
+		tab          int
+		choices      []string // text received so far for each choice
+		reasoning    string   // thinking received so far, with a single choice
+		reconnecting int      // reconnection attempt, if the connection dropped
+		overBudget   string   // why the response went over budget, if it did
+		tokens       int      // tokens received so far
 	}
 )
 
 type streamCompleteMsg struct {
-	content string
-	err     error
+	tab       int
+	choices   []string
+	reasoning string
+	err       error
+	tokens    int
+	usage     usage
+	toolCalls []toolCall
+}
+
+// streamEvent is sent from the streaming goroutine to the UI. content holds
+// the accumulated text of every choice, indexed by choice, and reasoning the
+// thinking of the first.
+type streamEvent struct {
+	content   []string
+	reasoning string
+	done      bool
+	err       error
+	// reconnecting is the attempt number when the connection dropped and
+	// the request is being resumed.
+	reconnecting int
+	// overBudget reports that the response went over its budget.
+	overBudget string
+	// tokens is roughly how many tokens have been received, counting one
+	// per delta.
+	tokens int
+	// usage is what the provider reported the request used, once it ends.
+	usage usage
+	// toolCalls are the tools the response asked to run, once it ends.
+	toolCalls []toolCall
 }
 
 var (
@@ -80,252 +197,1774 @@ var (
 	helpStyle = lipgloss.NewStyle().
 			Foreground(lipgloss.Color("#6B7280")).
 			Italic(true)
+
+	toolStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#A855F7")).
+			Bold(true)
+
+	selectedStyle = lipgloss.NewStyle().
+			Border(lipgloss.ThickBorder(), false, false, false, true).
+			BorderForeground(lipgloss.Color("#7C3AED")).
+			PaddingLeft(1)
 )
 
-func initialModel() model {
-	godotenv.Load()
+// activeLabels are the message labels in use, set from the config at startup.
+var activeLabels = labels{}.withDefaults()
+
+func initialModel(profileName string) model {
+	loadEnv()
 
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		return model{err: fmt.Errorf("OPENAI_API_KEY not found in environment or .env file")}
+	cfg, err := loadConfig()
+	if err != nil {
+		return failedModel(errUsage{err})
+	}
+	activeLabels = cfg.Labels.withDefaults()
+	if err := applyTheme(cfg.Theme); err != nil {
+		return failedModel(errUsage{err})
+	}
+	if err := rebindKeys(cfg.Keys); err != nil {
+		return failedModel(errUsage{err})
+	}
+	if err := cfg.validateTools(); err != nil {
+		return failedModel(errUsage{err})
+	}
+	if err := cfg.Search.validate(cfg.Tools); err != nil {
+		return failedModel(errUsage{err})
+	}
+	if err := cfg.validateMCPServers(); err != nil {
+		return failedModel(errUsage{err})
 	}
 
-	modelName := os.Getenv("OPENAI_MODEL")
-	if modelName == "" {
-		modelName = "gpt-4o"
+	queueSends, _ := strconv.ParseBool(os.Getenv("LLMTUI_QUEUE_SENDS"))
+	if env := os.Getenv("LLMTUI_SYSTEM_PROMPT"); env != "" {
+		cfg.SystemPrompt = env
+	}
+	if env := os.Getenv("LLMTUI_STOP"); env != "" {
+		if cfg.Stop, err = parseStopSequences(env); err != nil {
+			return failedModel(errUsage{fmt.Errorf("LLMTUI_STOP: %w", err)})
+		}
+	}
+	for _, setting := range samplingSettings {
+		if env := os.Getenv(setting.env); env != "" {
+			if err := setting.set(&cfg, env); err != nil {
+				return failedModel(errUsage{fmt.Errorf("%s: %w", setting.env, err)})
+			}
+		} else if err := setting.check(cfg); err != nil {
+			return failedModel(errUsage{err})
+		}
+	}
+	temperature, _ := findSamplingSetting("temperature")
+	for _, name := range cfg.profileNames() {
+		if err := temperature.check(config{Temperature: cfg.Profiles[name].Temperature}); err != nil {
+			return failedModel(errUsage{fmt.Errorf("profile %s: %w", name, err)})
+		}
+	}
+	var schema *jsonSchema
+	if cfg.JSONSchema != "" {
+		if schema, err = loadJSONSchema(cfg.JSONSchema); err != nil {
+			return failedModel(errUsage{err})
+		}
+	}
+
+	m := model{
+		config:       cfg,
+		input:        newInput(cfg.inputMaxHeight()),
+		queueSends:   queueSends,
+		recentModels: loadRecentModels(),
+		jsonSchema:   schema,
+		// Profiles replace the temperature, so keep the configured one
+		baseTemperature: cfg.Temperature,
+	}
+	m.openTab()
+	m.systemPrompt = cfg.SystemPrompt
+
+	if profileName == "" {
+		profileName = cfg.DefaultProfile
+	}
+	if profileName != "" {
+		if err := m.useProfile(profileName); err != nil {
+			return failedModel(err)
+		}
+		m.rememberModel()
+		return m
 	}
 
-	client := openai.NewClient(option.WithAPIKey(apiKey))
+	if b, err := lookupBackend(""); err == nil && !b.configured() && !configExists() {
+		m.startSetup()
+		return m
+	}
 
-	return model{
-		client:    &client,
-		modelName: modelName,
-		messages:  []chatMessage{},
-		input:     "",
-		viewport:  "",
-		loading:   false,
+	p, modelName, err := cfg.profile.withEnv().connect()
+	if err != nil {
+		return failedModel(err)
 	}
+	m.provider = p
+	m.modelName = modelName
+	m.rememberModel()
+
+	return m
 }
 
 func (m model) Init() tea.Cmd {
-	return nil
+	return tea.Batch(m.listModels(true), m.connectMCP())
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	_, resized := msg.(tea.WindowSizeMsg)
+	firstSize := resized && m.width == 0
+	var place scrollPlace
+	if resized && !firstSize {
+		place = m.scrollPlace()
+	}
+	next, cmd := m.update(msg)
+	m = next.(model)
+	m.syncViewport()
+	switch {
+	case firstSize:
+		// A message selected on start, as with --session name:N, can only be
+		// scrolled to once the screen size is known
+		m.scrollToSelected()
+	case resized:
+		m.restoreScrollPlace(place)
+		m.scrollToSelected()
+	}
+	return m, cmd
+}
+
+// scrollPlace is where the conversation is scrolled to, kept across a resize:
+// the message at the top of the screen and how far into it.
+type scrollPlace struct {
+	message  int // -1 when following the end of the conversation
+	fraction float64
+}
+
+// scrollPlace notes the message at the top of the screen, since re-wrapping
+// the conversation to a new width moves it to another line.
+func (m model) scrollPlace() scrollPlace {
+	if m.conversation == nil || m.palette != nil || m.viewport.AtBottom() {
+		return scrollPlace{message: -1}
+	}
+	i := m.messageAt(strings.Count(m.headerView(), "\n"))
+	if i < 0 {
+		return scrollPlace{message: -1}
+	}
+	top, height := m.messageRows(i)
+	return scrollPlace{message: i, fraction: float64(m.viewport.YOffset-top) / float64(max(height, 1))}
+}
+
+// restoreScrollPlace scrolls the re-wrapped conversation back to the same
+// point in the message that was at the top of the screen.
+func (m *model) restoreScrollPlace(place scrollPlace) {
+	if place.message < 0 || place.message >= len(m.messages) || m.palette != nil {
+		return
+	}
+	top, height := m.messageRows(place.message)
+	m.viewport.SetYOffset(top + int(place.fraction*float64(height)))
+}
+
+func (m model) update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tabMsg); ok && msg.tabID() != m.id {
+		return m.updateTab(msg)
+	}
+	// Keys Bubble Tea does not know only run global bindings, outside dialogs
+	if key, ok := extendedKey(msg); ok {
+		if b, ok := globalBinding(key); ok && m.confirm == nil && m.prompt == nil && len(m.choices) == 0 && m.help == nil && m.palette == nil && m.sessions == nil {
+			return m, b.run(&m)
+		}
+		return m, nil
+	}
 	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.resize(msg.Width, msg.Height)
+	case tea.MouseMsg:
+		m.handleMouse(msg)
 	case tea.KeyMsg:
+		m.notice = ""
+		if m.confirm != nil && msg.String() != "ctrl+c" {
+			return m, m.answerConfirm(msg.String())
+		}
+		if m.prompt != nil && msg.String() != "ctrl+c" {
+			return m, m.answerPrompt(msg)
+		}
+		if len(m.choices) > 0 && msg.String() != "ctrl+c" {
+			return m, m.pickChoice(msg.String())
+		}
+		if m.help != nil && msg.String() != "ctrl+c" {
+			m.handleHelpKey(msg)
+			return m, nil
+		}
+		if m.palette != nil && msg.String() != "ctrl+c" {
+			return m, m.handlePaletteKey(msg)
+		}
+		if m.sessions != nil && msg.String() != "ctrl+c" {
+			return m, m.handlePickerKey(msg)
+		}
+		if b, ok := globalBinding(msg.String()); ok {
+			return m, b.run(&m)
+		}
+		if m.config.VimMode {
+			if handled, cmd := m.handleVimKey(msg); handled {
+				return m, cmd
+			}
+		}
 		switch msg.String() {
-		case "ctrl+c", "q":
-			return m, tea.Quit
+		case "ctrl+c":
+			return m, m.quit()
+		case "q":
+			if m.input.Value() == "" {
+				return m, m.quit()
+			}
+			return m, m.editInput(msg)
+		case "alt+1", "alt+2", "alt+3", "alt+4", "alt+5", "alt+6", "alt+7", "alt+8", "alt+9":
+			m.switchTab(int(msg.String()[len("alt+")] - '1'))
 		case "enter":
-			if m.input != "" && !m.loading {
-				userMsg := chatMessage{role: "user", content: m.input}
-				m.messages = append(m.messages, userMsg)
-				m.viewport += userStyle.Render("You: ") + m.input + "\n\n"
-
-				m.input = ""
-				m.loading = true
-
-				return m, m.sendMessage()
+			input := m.input.Value()
+			if input == "" {
+				break
 			}
-		case "backspace":
-			if len(m.input) > 0 {
-				m.input = m.input[:len(m.input)-1]
+			// Commands run even while a response streams; "//" escapes a
+			// message that starts with a slash
+			if strings.HasPrefix(input, "//") {
+				input = input[1:]
+			} else if strings.HasPrefix(input, "/") {
+				m.keepPrompt(input)
+				m.setInput("")
+				return m, m.runCommand(input)
 			}
-		default:
-			if !m.loading {
-				m.input += msg.String()
+			if m.loading {
+				if utf8.RuneCountInString(input) > m.config.maxInputLength() {
+					m.notice = fmt.Sprintf("Message is over the limit of %d characters", m.config.maxInputLength())
+				} else if m.queueSends && m.queued == "" {
+					m.keepPrompt(input)
+					m.queued = input
+					m.setInput("")
+					m.notice = "Message queued, it will be sent when the current response completes (Esc to cancel)"
+				} else {
+					m.notice = "Please wait for the current response..."
+				}
+				break
+			}
+			if m.confirmLongInput(input) {
+				break
+			}
+			if err := m.attachMentions(input); err != nil {
+				m.notice = err.Error()
+				break
+			}
+			m.keepPrompt(input)
+			m.setInput("")
+			if m.editing >= 0 {
+				m.truncateForEdit()
+			}
+			return m, m.submit(input)
+		case "esc":
+			// Cancel the queued send, handing the text back to the input
+			if m.queued != "" {
+				m.setInput(m.queued + m.input.Value())
+				m.queued = ""
+				m.notice = "Queued message cancelled"
+			} else if m.stopResponse() {
+				break
+			} else if m.editing >= 0 {
+				m.editing = -1
+				m.setInput("")
+				m.images = nil
+				m.notice = "Edit cancelled"
+			} else if m.attachment != "" || len(m.images) > 0 {
+				m.attachment = ""
+				m.images = nil
+				m.notice = "Attachments dropped"
+			} else if m.findQuery != "" {
+				m.findQuery = ""
+				m.notice = "Search cleared"
+			} else if m.selected >= 0 {
+				m.selectMessage(-1)
+			}
+		case "up", "k", "alt+up":
+			if m.input.Value() == "" && (m.selected >= 0 || msg.String() == "alt+up") {
+				m.selectPrevious()
+				break
+			}
+			// Up recalls prompts sent before, like a shell
+			if msg.String() == "up" && (m.input.Value() == "" || m.recalling()) {
+				m.recallPrompt(1)
+				break
+			}
+			return m, m.editInput(msg)
+		case "down", "j", "alt+down":
+			if m.input.Value() == "" && (m.selected >= 0 || msg.String() == "alt+down") {
+				m.selectNext()
+				break
+			}
+			if msg.String() == "down" && m.recalling() {
+				m.recallPrompt(-1)
+				break
+			}
+			return m, m.editInput(msg)
+		case "e":
+			if m.input.Value() == "" && m.selected >= 0 {
+				m.editMessage()
+				break
+			}
+			return m, m.editInput(msg)
+		case "d", "D", "delete":
+			if m.input.Value() == "" && m.selected >= 0 {
+				m.confirmDelete(msg.String() == "D")
+				break
+			}
+			return m, m.editInput(msg)
+		case "y", "Y":
+			if m.input.Value() == "" && m.selected >= 0 {
+				m.copyMessage(msg.String() == "y")
+				break
+			}
+			return m, m.editInput(msg)
+		case "n", "N":
+			// Vim mode has them in normal mode, leaving insert mode for typing
+			if m.input.Value() == "" && m.findQuery != "" && !m.config.VimMode {
+				if msg.String() == "n" {
+					m.findNext(1)
+				} else {
+					m.findNext(-1)
+				}
+				break
+			}
+			return m, m.editInput(msg)
+		case "?":
+			if m.input.Value() == "" {
+				return m, openHelp(&m)
 			}
+			return m, m.editInput(msg)
+		case "tab":
+			if m.input.Value() == "" {
+				m.toggleCollapsed()
+			} else if !m.completeInput() {
+				m.notice = "No completions"
+			}
+		default:
+			return m, m.editInput(msg)
 		}
-	case msgResponse:
-		m.loading = false
+	case compareMsg:
+		return m, m.updateCompare(msg)
+	case runOutputMsg:
+		m.attachOutput(msg)
+	case fetchMsg:
+		m.attachPage(msg)
+	case memoryEditedMsg:
+		m.saveEditedMemories(msg)
+	case editorFinishedMsg:
 		if msg.err != nil {
-			m.err = msg.err
+			m.notice = "Editor failed: " + msg.err.Error()
+		}
+	case mcpConnectedMsg:
+		m.addMCPClient(msg)
+	case modelsMsg:
+		if msg.err != nil {
+			if !msg.quiet {
+				m.notice = "Could not list models: " + msg.err.Error()
+			}
+			break
+		}
+		m.providerModels = msg.models
+		if msg.quiet {
+			break
+		}
+		if len(msg.models) == 0 {
+			m.notice = "The provider has no models"
 		} else {
-			assistantMsg := chatMessage{role: "assistant", content: msg.content}
-			m.messages = append(m.messages, assistantMsg)
-			m.viewport += assistantStyle.Render("LLM: ") + msg.content + "\n\n"
+			m.notice = "Models: " + strings.Join(msg.models, ", ")
+		}
+	case autosaveMsg:
+		m.notice = "Autosave failed: " + msg.err.Error()
+	case compactMsg:
+		m.applyCompact(msg)
+	case titleMsg:
+		// A title set by the user in the meantime wins
+		if msg.err != nil {
+			m.notice = "Could not generate a title: " + msg.err.Error()
+		} else if m.title == "" {
+			m.title = msg.title
+			return m, m.autosave()
 		}
 	case streamStartMsg:
 		m.streaming = true
 		m.partialResp = ""
+		m.partialReasoning = ""
+		m.partialChoices = nil
 	case streamStarted:
+		if msg.dropped > 0 {
+			m.notice = trimmedNotice(msg.dropped, msg.req.model, m.config.contextWindow(msg.req.model))
+		}
+		if msg.ragErr != nil {
+			m.notice = "Could not search the indexed documents: " + msg.ragErr.Error()
+		}
+		m.sources = msg.sources
 		// Start streaming with a new subscription
-		m.streamChan = make(chan string, 100)
-		go startStreamingInBackground(m.streamChan, msg.client, msg.messages, msg.modelName)
-		return m, listenForStreamUpdates(m.streamChan)
+		ctx, cancel := context.WithCancelCause(context.Background())
+		m.streamChan = make(chan streamEvent, 100)
+		m.cancelStream = cancel
+		m.streamDone = make(chan struct{})
+		m.rate = startThroughput(time.Now())
+		m.reconnecting = 0
+		go func(streamChan chan streamEvent, done chan struct{}) {
+			defer close(done)
+			startStreamingInBackground(ctx, streamChan, msg.provider, msg.req, m.config.budget())
+		}(m.streamChan, m.streamDone)
+		return m, listenForStreamUpdates(m.id, m.streamChan, m.config.renderThrottle())
 	case streamUpdateMsg:
-		if msg.content != "" {
-			m.partialResp = msg.content
+		if msg.reconnecting > 0 {
+			m.notice = fmt.Sprintf("Connection lost, reconnecting (attempt %d of %d)...", msg.reconnecting, maxReconnects)
+			m.reconnecting = msg.reconnecting
+		} else if msg.tokens > 0 {
+			m.reconnecting = 0
+		}
+		if msg.overBudget != "" {
+			m.confirmOverBudget(msg.overBudget)
+		}
+		if msg.tokens > 0 {
+			m.rate.record(msg.tokens, time.Now())
+		}
+		switch len(msg.choices) {
+		case 0:
+		case 1:
+			m.partialResp = msg.choices[0]
+			m.partialReasoning = msg.reasoning
+			if err := m.transcript.delta(m.respondingModel(), msg.choices[0]); err != nil {
+				m.notice = err.Error()
+			}
+		default:
+			m.partialChoices = msg.choices
 		}
 		// Continue listening for updates using a stored channel
 		if m.streamChan != nil {
-			return m, listenForStreamUpdates(m.streamChan)
+			return m, listenForStreamUpdates(m.id, m.streamChan, m.config.renderThrottle())
 		}
 		return m, nil
 	case streamCompleteMsg:
+		if msg.tokens > 0 {
+			m.rate.record(msg.tokens, time.Now())
+		}
+		m.rate.ended = time.Now()
+		m.reconnecting = 0
 		m.loading = false
 		m.streaming = false
+		m.dropStreamConfirm()
 		m.streamChan = nil
-		if msg.err != nil {
-			m.err = msg.err
-		} else {
-			assistantMsg := chatMessage{role: "assistant", content: msg.content}
-			m.messages = append(m.messages, assistantMsg)
-			m.viewport += assistantStyle.Render("LLM: ") + msg.content + "\n\n"
-		}
+		m.cancelStream = nil
+		m.streamDone = nil
 		m.partialResp = ""
-	case msgStreamChunk:
-		if msg.err != nil {
-			m.err = msg.err
-			m.loading = false
-			m.streaming = false
-			m.partialResp = ""
-		} else if msg.done {
-			m.loading = false
-			m.streaming = false
-			assistantMsg := chatMessage{role: "assistant", content: msg.chunk}
-			m.messages = append(m.messages, assistantMsg)
-			m.viewport += assistantStyle.Render("LLM: ") + msg.chunk + "\n\n"
-			m.partialResp = ""
-		} else {
-			m.partialResp = msg.chunk
-			m.streaming = true
+		m.partialReasoning = ""
+		m.partialChoices = nil
+		if errors.Is(msg.err, errStopped) {
+			m.notice = "Response stopped"
+		}
+		used := m.recordUsage(msg.usage, m.respondingModel())
+		switch {
+		case msg.err != nil && len(msg.choices) == 1 && msg.choices[0] != "":
+			// Keep the partial response and mark where it broke off
+			m.completeTurn(msg.choices[0])
+			m.messages[len(m.messages)-1].interrupted = msg.err
+		case msg.err != nil:
+			m.failTurn(msg.err)
+			return m, m.afterTurn()
+		case len(msg.choices) > 1:
+			// Wait for the user to pick the choice to keep
+			m.choices = msg.choices
+			m.choicesUsage = used
+			return m, nil
+		case len(msg.choices) == 1:
+			m.completeTurn(msg.choices[0])
+		default:
+			m.completeTurn("")
+		}
+		m.messages[len(m.messages)-1].usage = used
+		m.messages[len(m.messages)-1].reasoning = msg.reasoning
+		if len(msg.toolCalls) > 0 {
+			// The sources are cited under the answer that follows the results
+			m.messages[len(m.messages)-1].sources = nil
+			m.messages[len(m.messages)-1].toolCalls = msg.toolCalls
+			return m, m.approveTools(toolApprovalMsg{tab: m.id, calls: msg.toolCalls, refused: make(map[string]error)})
 		}
+		return m, m.afterTurn()
+	case toolApprovalMsg:
+		return m, m.approveTools(msg)
+	case toolResultsMsg:
+		return m, m.applyToolResults(msg)
 	}
 	return m, nil
 }
 
+// errStopped is why a response stopped with Esc or Ctrl+X stopped.
+var errStopped = errors.New("stopped")
+
+// stopResponse cancels the response in flight, reporting whether there was
+// one. Whatever has arrived is kept as the answer, marked where it stopped.
+func (m *model) stopResponse() bool {
+	if m.cancelStream == nil {
+		return false
+	}
+	m.cancelStream(errStopped)
+	m.dropStreamConfirm()
+	m.notice = "Stopping the response..."
+	return true
+}
+
+// shutdownTimeout bounds how long quitting waits for a cancelled stream to
+// wind down.
+const shutdownTimeout = time.Second
+
+// quit cancels the requests in flight in every tab and waits briefly for their
+// goroutines to return, so nothing is left running against a stopped program.
+func (m *model) quit() tea.Cmd {
+	for _, c := range m.tabs {
+		if c.cancelStream != nil {
+			c.cancelStream(nil)
+		}
+	}
+	deadline := time.After(shutdownTimeout)
+	for _, c := range m.tabs {
+		if c.streamDone == nil {
+			continue
+		}
+		select {
+		case <-c.streamDone:
+		case <-deadline:
+			return tea.Quit
+		}
+	}
+	return tea.Quit
+}
+
+// regenerate replaces the response to the last prompt, or the error it
+// failed with, with a new one. The prompt is sent again with the same
+// history, to modelName if it is not empty and with settings in place of the
+// configured generation settings if they are not nil.
+func (m *model) regenerate(modelName string, settings *config) tea.Cmd {
+	if m.loading {
+		m.notice = "Please wait for the current response..."
+		return nil
+	}
+	last := -1
+	for i, msg := range m.messages {
+		if msg.role == "user" {
+			last = i
+		}
+	}
+	if last < 0 {
+		m.notice = "There is no prompt to retry"
+		return nil
+	}
+
+	forked := m.fork(last + 1)
+	m.messages = m.messages[:last+1]
+	if m.selected > last {
+		m.selected = -1
+	}
+	if modelName != "" && modelName != m.modelName {
+		m.override = modelName
+	}
+	m.settings = settings
+	m.notice = "Regenerating..."
+	if forked {
+		m.notice = fmt.Sprintf("Regenerating, the previous response is kept as branch %d", len(m.branches))
+	}
+	m.loading = true
+	m.viewport.GotoBottom()
+	return m.sendRequest(m.messages, m.respondingModel())
+}
+
+// submit appends a user turn to the conversation and starts a request for it.
+func (m *model) submit(input string) tea.Cmd {
+	if m.attachment != "" {
+		input += "\n\n" + m.attachment
+		m.attachment = ""
+	}
+	userMsg := chatMessage{role: "user", content: input, images: m.images, sent: time.Now()}
+	m.images = nil
+	m.messages = append(m.messages, userMsg)
+	m.trimScrollback()
+	// Follow the reply as it streams in, even if scrolled up before sending
+	m.viewport.GotoBottom()
+	m.loading = true
+	if err := m.transcript.userTurn(input); err != nil {
+		m.notice = err.Error()
+	}
+
+	if len(m.comparing) > 0 {
+		return m.streamCompare(m.comparing)
+	}
+	return m.sendMessage()
+}
+
+// confirmLongInput checks input against the length limit. Over it, the input
+// is kept for editing and the user is asked whether to send it truncated
+// instead; it reports whether that happened.
+func (m *model) confirmLongInput(input string) bool {
+	limit := m.config.maxInputLength()
+	length := utf8.RuneCountInString(input)
+	if length <= limit {
+		return false
+	}
+	prompt := fmt.Sprintf("Message is %d characters, over the limit of %d. Send only the first %d?", length, limit, limit)
+	// Not skipped with confirmations disabled, since this is a guard
+	m.confirm = &confirmation{prompt: prompt, action: func(m *model) tea.Cmd {
+		input := string([]rune(input)[:limit])
+		if err := m.attachMentions(input); err != nil {
+			m.notice = err.Error()
+			return nil
+		}
+		m.setInput("")
+		return m.submit(input)
+	}}
+	return true
+}
+
+// afterTurn runs the follow-up work once a response has finished.
+func (m *model) afterTurn() tea.Cmd {
+	m.override = ""
+	m.settings = nil
+	return tea.Batch(m.autosave(), m.autoTitle(), m.autoCompact(), m.sendQueued())
+}
+
+// respondingModel returns the model answering the current request.
+func (m model) respondingModel() string {
+	if m.override != "" {
+		return m.override
+	}
+	return m.modelName
+}
+
+// session captures the conversation for saving.
+func (m model) session() session {
+	return newSession(m.title, m.modelName, m.systemPrompt, m.summary, m.messages, m.branches)
+}
+
+// sendQueued sends the message queued during the previous response. If that
+// response failed the queued text is returned to the input instead, since it
+// most likely depended on an answer that never arrived.
+func (m *model) sendQueued() tea.Cmd {
+	if m.queued == "" {
+		return nil
+	}
+	queued := m.queued
+	m.queued = ""
+	if last := m.messages[len(m.messages)-1]; last.err != nil {
+		m.setInput(queued + m.input.Value())
+		m.notice = "Queued message was not sent because the request failed"
+		return nil
+	}
+	if err := m.attachMentions(queued); err != nil {
+		m.setInput(queued + m.input.Value())
+		m.notice = "Queued message was not sent: " + err.Error()
+		return nil
+	}
+	return m.submit(queued)
+}
+
+// confirmDelete asks to delete the selected message and, if withPair is set,
+// the user prompt or response paired with it.
+func (m *model) confirmDelete(withPair bool) {
+	if m.loading {
+		m.notice = "Please wait for the current response..."
+		return
+	}
+	start, end := m.selected, m.selected+1
+	if withPair {
+		if m.messages[start].role == "user" {
+			if end < len(m.messages) && m.messages[end].role == "assistant" {
+				end++
+			}
+		} else if start > m.firstVisible && m.messages[start-1].role == "user" {
+			start--
+		}
+	}
+	prompt := "Delete the selected message?"
+	if end-start > 1 {
+		prompt = "Delete the selected message and its pair?"
+	}
+	m.confirmAction(prompt, false, func(m *model) tea.Cmd {
+		m.deleteMessages(start, end)
+		return nil
+	})
+}
+
+// editMessage puts the selected message in the input to be edited and sent
+// again in its place.
+func (m *model) editMessage() {
+	if m.loading {
+		m.notice = "Please wait for the current response..."
+		return
+	}
+	msg := m.messages[m.selected]
+	if msg.role != "user" {
+		m.notice = "Only your own messages can be edited"
+		return
+	}
+	m.editing = m.selected
+	m.setInput(msg.content)
+	// Sent again unless dropped with Esc
+	m.images = slices.Clone(msg.images)
+}
+
+// truncateForEdit drops the message being edited and everything after it,
+// keeping them as a branch, for the edited text to be sent in its place.
+func (m *model) truncateForEdit() {
+	i := m.editing
+	m.editing = -1
+	if i >= len(m.messages) {
+		return
+	}
+	forked := m.fork(i)
+	if m.messages[i].compacted {
+		// The summary describes messages being dropped
+		m.summary = ""
+		for j := range m.messages {
+			m.messages[j].compacted = false
+		}
+	}
+	m.messages = m.messages[:i]
+	if m.selected >= i {
+		m.selected = -1
+	}
+	if forked {
+		m.notice = fmt.Sprintf("Sent the edited message, the original is kept as branch %d", len(m.branches))
+	}
+}
+
+// deleteMessages removes messages[start:end] from the conversation, so they
+// are no longer sent with later requests.
+func (m *model) deleteMessages(start, end int) {
+	m.editing = -1
+	m.messages = append(m.messages[:start], m.messages[end:]...)
+	m.selected = min(start, len(m.messages)-1)
+	if m.selected < m.firstVisible {
+		m.selected = -1
+	}
+	if end-start == 1 {
+		m.notice = "Deleted 1 message"
+	} else {
+		m.notice = fmt.Sprintf("Deleted %d messages", end-start)
+	}
+}
+
+// collapsedLines is how many lines of a collapsed response stay visible.
+const collapsedLines = 5
+
+// targetMessage returns the index of the message that per-message actions
+// apply to: the selected message, or else the last assistant response. It
+// returns -1 if there is none.
+func (m model) targetMessage() int {
+	if m.selected >= 0 {
+		return m.selected
+	}
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].role == "assistant" && m.messages[i].err == nil {
+			return i
+		}
+	}
+	return -1
+}
+
+// toggleRaw switches the target message between its formatted and raw text.
+func (m *model) toggleRaw() {
+	i := m.targetMessage()
+	if i < 0 {
+		return
+	}
+	if m.messages[i].role != "assistant" || m.messages[i].err != nil {
+		m.notice = "Only responses have a raw view"
+		return
+	}
+	m.messages[i].raw = !m.messages[i].raw
+}
+
+// toggleCollapsed collapses or expands the target message.
+func (m *model) toggleCollapsed() {
+	i := m.targetMessage()
+	if i < 0 {
+		return
+	}
+	if m.messages[i].role == "user" || m.messages[i].err != nil {
+		m.notice = "Only responses and tool results can be collapsed"
+		return
+	}
+	if strings.Count(m.messages[i].content, "\n") < collapsedLines {
+		m.notice = "Message is short enough to show in full"
+		return
+	}
+	m.messages[i].collapsed = !m.messages[i].collapsed
+}
+
+// trimScrollback evicts the oldest messages from the screen once there are
+// more than the scrollback limit, so marathon sessions do not grow without
+// bound. Unless configured to keep them, they are dropped from the
+// conversation too.
+func (m *model) trimScrollback() {
+	limit := m.config.scrollbackLimit()
+	excess := len(m.messages) - m.firstVisible - limit
+	if excess <= 0 {
+		return
+	}
+	if m.config.ScrollbackKeepHistory {
+		m.firstVisible += excess
+	} else {
+		m.messages = slices.Delete(m.messages, 0, excess)
+		if m.selected >= 0 {
+			m.selected = max(m.selected-excess, -1)
+		}
+		if m.editing >= 0 {
+			m.editing = max(m.editing-excess, -1)
+		}
+	}
+	if m.selected >= 0 && m.selected < m.firstVisible {
+		m.selected = -1
+	}
+}
+
+// selectPrevious moves the selection to the previous message, starting from
+// the last one when nothing is selected.
+func (m *model) selectPrevious() {
+	switch {
+	case m.selected > 0:
+		m.selectMessage(m.selected - 1)
+	case m.selected < 0:
+		m.selectMessage(len(m.messages) - 1)
+	}
+}
+
+// selectNext moves the selection to the next message. Moving past the last
+// message returns to the input.
+func (m *model) selectNext() {
+	if m.selected >= 0 {
+		m.selectMessage(m.selected + 1)
+	}
+}
+
+// selectMessage moves the selection cursor to message i. An index outside the
+// conversation clears the selection.
+func (m *model) selectMessage(i int) {
+	if i < 0 || i >= len(m.messages) {
+		i = -1
+	} else if i < m.firstVisible {
+		// Evicted messages cannot be selected
+		i = m.firstVisible
+	}
+	m.selected = i
+	m.scrollToSelected()
+}
+
+// confirmAction asks prompt before running action, unless confirmations are
+// disabled.
+func (m *model) confirmAction(prompt string, saveFirst bool, action func(m *model) tea.Cmd) tea.Cmd {
+	if m.config.SkipConfirmations {
+		return action(m)
+	}
+	m.confirm = &confirmation{prompt: prompt, action: action, saveFirst: saveFirst}
+	return nil
+}
+
+// confirmDiscard guards an action that throws the conversation away, offering
+// to save it first. There is nothing to confirm for an empty conversation.
+func (m *model) confirmDiscard(prompt string, action func(m *model) tea.Cmd) tea.Cmd {
+	if len(m.messages) == 0 {
+		return action(m)
+	}
+	return m.confirmAction(prompt, true, action)
+}
+
+// answerConfirm handles a key press while a confirmation is pending. "s"
+// saves the conversation before going ahead.
+func (m *model) answerConfirm(key string) tea.Cmd {
+	c := m.confirm
+	switch key {
+	case "y", "Y":
+		m.confirm = nil
+		return c.action(m)
+	case "s", "S":
+		if !c.saveFirst {
+			break
+		}
+		name := defaultSessionName(m.title)
+		if err := saveSession(name, m.session()); err != nil {
+			m.notice = err.Error()
+			return nil
+		}
+		m.confirm = nil
+		cmd := c.action(m)
+		m.notice = fmt.Sprintf("Saved session %s. %s", name, m.notice)
+		return cmd
+	case "n", "N", "esc":
+		m.confirm = nil
+		m.notice = "Cancelled"
+		if c.decline != nil {
+			return c.decline(m)
+		}
+	}
+	return nil
+}
+
+// pickChoice keeps the candidate response chosen with a number key and adds
+// it to the conversation.
+func (m *model) pickChoice(key string) tea.Cmd {
+	i, err := strconv.Atoi(key)
+	if err != nil || i < 1 || i > len(m.choices) {
+		m.notice = fmt.Sprintf("Press 1-%d to keep a choice", len(m.choices))
+		return nil
+	}
+	choice := m.choices[i-1]
+	var interrupted error
+	used := m.choicesUsage
+	if c := m.compare; c != nil {
+		if choice == "" {
+			m.notice = c.models[i-1] + " failed, keep another answer"
+			return nil
+		}
+		m.override, interrupted, used = c.models[i-1], c.errs[i-1], c.usage[i-1]
+		m.compare = nil
+	}
+	m.choices = nil
+	m.choicesUsage = usage{}
+	m.completeTurn(choice)
+	m.messages[len(m.messages)-1].usage = used
+	if interrupted != nil {
+		m.messages[len(m.messages)-1].interrupted = interrupted
+	}
+	return m.afterTurn()
+}
+
+// answerPrompt edits the pending text prompt. Enter submits it and Esc
+// cancels it.
+func (m *model) answerPrompt(msg tea.KeyMsg) tea.Cmd {
+	p := m.prompt
+	switch msg.Type {
+	case tea.KeyEnter:
+		m.prompt = nil
+		return p.onSubmit(m, strings.TrimSpace(p.value))
+	case tea.KeyEsc:
+		m.prompt = nil
+		if m.setup != nil {
+			// There is nothing to fall back to without a finished setup
+			return tea.Quit
+		}
+		m.notice = "Cancelled"
+	case tea.KeyBackspace:
+		if r := []rune(p.value); len(r) > 0 {
+			p.value = string(r[:len(r)-1])
+		}
+	case tea.KeySpace:
+		p.value += " "
+	case tea.KeyRunes:
+		p.value += string(msg.Runes)
+	}
+	return nil
+}
+
+func (p *textPrompt) render() string {
+	value := p.value
+	if p.mask {
+		value = strings.Repeat("*", len([]rune(value)))
+	}
+	return inputStyle.Render(p.label) + value + inputStyle.Render("█")
+}
+
+// promptSaveTemplate asks for a name to save the input as a template under.
+func (m *model) promptSaveTemplate() {
+	if strings.TrimSpace(m.input.Value()) == "" {
+		m.notice = "Type a prompt to save as a template first"
+		return
+	}
+	m.prompt = &textPrompt{label: "Save template as: ", onSubmit: saveInputAsTemplate}
+}
+
+// saveInputAsTemplate saves the current input under name without sending it.
+func saveInputAsTemplate(m *model, name string) tea.Cmd {
+	if err := saveTemplate(name, m.input.Value()); err != nil {
+		m.notice = err.Error()
+		return nil
+	}
+	m.notice = fmt.Sprintf("Saved template %s, fill it in with /prompt %s", name, name)
+	return nil
+}
+
+// resetConversation discards the current conversation.
+func (m *model) resetConversation() {
+	m.messages = []chatMessage{}
+	m.firstVisible = 0
+	m.title = ""
+	m.selected = -1
+	m.lastStats = ""
+	m.spent = usage{}
+	m.summary = ""
+	m.editing = -1
+	m.branches = nil
+	m.sessionName = ""
+}
+
+// completeTurn appends a finished assistant response to the conversation.
+func (m *model) completeTurn(content string) {
+	assistantMsg := chatMessage{role: "assistant", content: content, sent: time.Now(), json: m.config.JSONMode, model: m.override, sources: m.sources}
+	if assistantMsg.json {
+		assistantMsg.jsonErr = checkJSON(content, m.jsonSchema)
+	}
+	m.messages = append(m.messages, assistantMsg)
+	m.trimScrollback()
+	m.lastStats = responseStats(content)
+	if rate := m.rate.average(); rate > 0 {
+		m.lastStats += fmt.Sprintf(", %.1f tokens/s", rate)
+	}
+	if latency := m.rate.latency(); latency != "" {
+		m.lastStats += ", " + latency
+	}
+	if err := m.transcript.assistantTurn(m.respondingModel(), assistantMsg); err != nil {
+		m.notice = err.Error()
+	}
+}
+
+// failTurn records a failed request as an assistant turn so the error is shown
+// inline and the conversation stays usable. Failed turns are never sent back to
+// the model and can be retried with the retry key.
+func (m *model) failTurn(err error) {
+	failed := chatMessage{role: "assistant", err: err, sent: time.Now(), model: m.override}
+	m.messages = append(m.messages, failed)
+	m.trimScrollback()
+	if err := m.transcript.assistantTurn(m.respondingModel(), failed); err != nil {
+		m.notice = err.Error()
+	}
+}
+
+// The smallest terminal the chat layout fits in: the header takes five lines
+// (title, rule and status line with their margins) and the input, notice and
+// status bar another five, leaving room for at least a short input line.
+const (
+	minWidth  = 40
+	minHeight = 10
+)
+
+// tooSmall reports whether the terminal is below the minimum size. The size is
+// unknown, and assumed to fit, until the first resize message arrives.
+func (m model) tooSmall() bool {
+	return m.width > 0 && (m.width < minWidth || m.height < minHeight)
+}
+
 func (m model) View() string {
 	if m.err != nil {
 		return errorStyle.Render(fmt.Sprintf("Error: %v", m.err)) + "\n\n" +
 			helpStyle.Render("Press q to quit.")
 	}
 
+	if m.setup != nil {
+		return m.setupView()
+	}
+
+	if m.help != nil {
+		return m.helpView()
+	}
+
+	if m.sessions != nil {
+		return m.pickerView()
+	}
+
+	if m.tooSmall() {
+		return errorStyle.Render(fmt.Sprintf("Terminal too small (need at least %dx%d)", minWidth, minHeight))
+	}
+
+	header, footer := m.headerView(), m.footerView()
+	if m.palette != nil {
+		// The palette lists its query first, so it is cut off at the bottom
+		lines := strings.Split(strings.TrimRight(m.paletteView(), "\n"), "\n")
+		if rows := m.bodyRows(header, footer); m.height > 0 && len(lines) > rows {
+			lines = lines[:rows]
+		}
+		return header + strings.Join(lines, "\n") + "\n\n" + m.wrapBody(footer)
+	}
+	// Bubble Tea cuts off lines wider than the screen, so long notices are
+	// wrapped as bodyRows counted them
+	return header + m.viewport.View() + "\n\n" + m.wrapBody(footer)
+}
+
+func (m model) headerView() string {
 	var b strings.Builder
 
+	if tabs := m.tabBar(); tabs != "" {
+		b.WriteString(truncateLine(tabs, m.width) + "\n")
+	}
 	b.WriteString(titleStyle.Render("LLM TUI Chat"))
 	b.WriteString("\n")
 	b.WriteString(titleStyle.Render("================"))
-	b.WriteString("\n\n")
+	b.WriteString("\n")
+	if status := m.statusLine(); status != "" {
+		b.WriteString(truncateLine(status, m.width))
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+
+	return b.String()
+}
 
-	b.WriteString(m.viewport)
+// bodyView renders the conversation, including any response in progress.
+func (m model) bodyView() string {
+	var b strings.Builder
 
-	if m.loading {
-		if m.streaming && m.partialResp != "" {
-			b.WriteString(assistantStyle.Render("LLM: ") + m.partialResp + assistantStyle.Render("█"))
-		} else {
-			b.WriteString(assistantStyle.Render("LLM is typing..."))
+	b.WriteString(renderConversation(m))
+
+	if m.queued != "" {
+		b.WriteString(helpStyle.Render("Queued: ") + m.queued + "\n\n")
+	}
+	if m.attachment != "" || len(m.images) > 0 {
+		attached := message{content: m.attachment, images: m.images}
+		b.WriteString(helpStyle.Render("Attached to your next message, "+m.estimateCost(attached)+":") + "\n")
+		for _, img := range m.images {
+			b.WriteString(helpStyle.Render(img.placeholder()) + "\n")
 		}
-		b.WriteString("\n\n")
+		if m.attachment != "" {
+			b.WriteString(m.attachment + "\n")
+		}
+		b.WriteString("\n")
 	}
 
-	b.WriteString(inputStyle.Render("You: ") + m.input)
-	if !m.loading {
-		b.WriteString(inputStyle.Render("█"))
+	return b.String()
+}
+
+func (m model) footerView() string {
+	var b strings.Builder
+
+	b.WriteString(m.input.View())
+	b.WriteString("\n")
+	if suggestions := m.suggestions(); len(suggestions) > 0 {
+		if len(suggestions) > maxSuggestions {
+			suggestions = append(suggestions[:maxSuggestions], "…")
+		}
+		b.WriteString(helpStyle.Render("Tab: " + strings.Join(suggestions, "  ")))
+	} else if preview := m.mentionPreview(); preview != "" {
+		b.WriteString(helpStyle.Render(preview))
 	}
-	b.WriteString("\n\n")
+	b.WriteString("\n")
 
-	b.WriteString(helpStyle.Render("Press Enter to send, Ctrl+C or q to quit"))
+	if m.prompt != nil {
+		b.WriteString(m.prompt.render())
+		b.WriteString("\n")
+	} else if m.confirm != nil {
+		choices := " (y)es / (n)o"
+		if m.confirm.saveFirst {
+			choices += " / (s)ave first"
+		}
+		b.WriteString(inputStyle.Render(m.confirm.prompt + choices))
+		b.WriteString("\n")
+	} else if len(m.choices) > 0 && m.notice == "" {
+		b.WriteString(inputStyle.Render(fmt.Sprintf("Press 1-%d to keep a choice", len(m.choices))))
+		b.WriteString("\n")
+	} else if m.editing >= 0 && m.notice == "" {
+		b.WriteString(inputStyle.Render("Editing a message: Enter sends it in place of the original, dropping everything after it; Esc cancels"))
+		b.WriteString("\n")
+	} else if m.notice != "" {
+		b.WriteString(helpStyle.Render(m.notice))
+		b.WriteString("\n")
+	} else if m.loading && !m.rate.started.IsZero() && m.rate.ended.IsZero() {
+		b.WriteString(helpStyle.Render(m.rate.live(time.Now())))
+		b.WriteString("\n")
+	} else if m.lastStats != "" {
+		b.WriteString(helpStyle.Render("Last response: " + m.lastStats))
+		b.WriteString("\n")
+	}
+	b.WriteString(m.statusBar())
 
 	return b.String()
 }
 
+// bodyRows is how many rows are left for the conversation between the header
+// and the footer, as wrapped on screen, keeping one blank row above the footer.
+func (m model) bodyRows(header, footer string) int {
+	return max(m.height-strings.Count(header, "\n")-strings.Count(m.wrapBody(footer), "\n")-2, 1)
+}
+
+// syncViewport fits the viewport between the header and footer and fills it
+// with the conversation, following the end of it unless the user has
+// scrolled up.
+func (m *model) syncViewport() {
+	if m.conversation == nil || m.palette != nil {
+		return
+	}
+	header, footer := m.headerView(), m.footerView()
+	follow := m.viewport.AtBottom()
+	m.viewport.Width = m.width
+	m.viewport.SetContent(strings.TrimRight(m.wrapBody(m.bodyView()), "\n"))
+	if m.height > 0 {
+		m.viewport.Height = m.bodyRows(header, footer)
+	} else {
+		// The size is unknown until the first resize message, so show it all
+		m.viewport.Height = m.viewport.TotalLineCount()
+	}
+	if follow {
+		m.viewport.GotoBottom()
+	}
+}
+
+// wrapBody wraps s to the terminal width, so that the viewport counts the rows
+// long lines take on screen.
+func (m model) wrapBody(s string) string {
+	if m.width <= 0 {
+		return s
+	}
+	return ansi.Wrap(s, m.width, "")
+}
+
+// resize lays the screen out for a new terminal size. The conversation is
+// wrapped and rendered again at the new width by syncViewport; here the input
+// is fitted to it and the lists scrolled so their cursors stay on screen.
+func (m *model) resize(width, height int) {
+	m.width, m.height = width, height
+	m.fitInput()
+	if m.help != nil {
+		m.help.offset = min(m.help.offset, max(len(helpLines())-m.helpRows(), 0))
+	}
+	if p := m.sessions; p != nil {
+		p.offset = min(p.offset, p.cursor)
+		p.offset = max(p.offset, p.cursor-m.pickerRows()+1)
+	}
+}
+
+// scrollBy scrolls the conversation by delta lines, positive values moving
+// towards older messages.
+func (m *model) scrollBy(delta int) {
+	if delta > 0 {
+		m.viewport.ScrollUp(delta)
+	} else {
+		m.viewport.ScrollDown(-delta)
+	}
+}
+
+// messageAt returns the index of the message rendered at screen row y, or -1.
+func (m model) messageAt(y int) int {
+	top := strings.Count(m.headerView(), "\n")
+	if y < top || y-top >= m.viewport.Height {
+		return -1
+	}
+	line := m.viewport.YOffset + y - top
+
+	// Walk the rendered messages in the same order renderConversation does
+	row := 0
+	if m.firstVisible > 0 {
+		row = 2
+	}
+	for i := m.firstVisible; i < len(m.messages); i++ {
+		row += strings.Count(m.wrapBody(m.renderTurn(i)), "\n")
+		if line < row {
+			return i
+		}
+	}
+	return -1
+}
+
+// handleMouse scrolls with the wheel, selects the clicked message and goes
+// back to typing when the input is clicked.
+func (m *model) handleMouse(msg tea.MouseMsg) {
+	if m.palette != nil || m.help != nil || m.sessions != nil {
+		return
+	}
+	switch msg.Button {
+	case tea.MouseButtonWheelUp:
+		m.scrollBy(mouseScrollLines)
+	case tea.MouseButtonWheelDown:
+		m.scrollBy(-mouseScrollLines)
+	case tea.MouseButtonLeft:
+		if msg.Action != tea.MouseActionPress || m.confirm != nil || m.prompt != nil {
+			break
+		}
+		if i := m.messageAt(msg.Y); i >= 0 {
+			m.clickMessage(i)
+		} else if m.inputAt(msg.Y) {
+			m.focusInput()
+		}
+	}
+}
+
+// clickMessage selects message i, or clears the selection if it already was,
+// and says which keys act on it. In vim mode this leaves insert mode, where
+// the keys would be typed.
+func (m *model) clickMessage(i int) {
+	if m.selected == i {
+		m.selectMessage(-1)
+		m.notice = ""
+		return
+	}
+	m.selectMessage(i)
+	if m.config.VimMode {
+		m.setVimMode(vimNormal)
+	} else if m.input.Value() != "" {
+		return
+	}
+	actions := "y copies it, "
+	if m.messages[m.selected].role == "user" {
+		actions += "e edits it, "
+	}
+	m.notice = actions + "d deletes it and Esc clears the selection"
+}
+
+// focusInput clears the selection, so that keys go to the input again, and
+// switches vim mode back to insert mode.
+func (m *model) focusInput() {
+	m.selectMessage(-1)
+	m.notice = ""
+	if m.config.VimMode {
+		m.setVimMode(vimInsert)
+	}
+}
+
+// inputAt reports whether screen row y is one of the input's.
+func (m model) inputAt(y int) bool {
+	top := strings.Count(m.headerView(), "\n") + m.viewport.Height + 1
+	return y >= top && y < top+strings.Count(m.input.View(), "\n")+1
+}
+
+// mouseScrollLines is how far one step of the mouse wheel scrolls.
+const mouseScrollLines = 3
+
+// statusLine lists the title, mode and generation settings in effect, shown
+// under the title.
+func (m model) statusLine() string {
+	// The model, provider and session are in the status bar at the bottom
+	var status []string
+	if m.config.VimMode {
+		status = append(status, m.vim.String())
+	}
+	if m.title != "" {
+		status = append(status, m.title)
+	}
+	if m.config.JSONMode {
+		status = append(status, "JSON")
+	}
+	if len(m.config.Stop) > 0 {
+		status = append(status, "Stop: "+formatStopSequences(m.config.Stop))
+	}
+	if m.config.Temperature != nil {
+		status = append(status, "Temperature: "+formatPenalty(m.config.Temperature))
+	}
+	if m.config.TopP != nil {
+		status = append(status, "Top-p: "+formatPenalty(m.config.TopP))
+	}
+	if m.config.MaxTokens != nil {
+		status = append(status, fmt.Sprintf("Max tokens: %d", *m.config.MaxTokens))
+	}
+	if m.config.Seed != nil {
+		status = append(status, fmt.Sprintf("Seed: %d", *m.config.Seed))
+	}
+	if m.config.ReasoningEffort != "" {
+		status = append(status, "Reasoning: "+m.config.ReasoningEffort)
+	}
+	// Last, since it is the most likely to be cut off at the edge
+	if m.systemPrompt != "" {
+		status = append(status, "System: "+strings.Join(strings.Fields(m.systemPrompt), " "))
+	}
+	if len(status) == 0 {
+		return ""
+	}
+	return helpStyle.Render(strings.Join(status, " · "))
+}
+
+// wordsPerMinute is the average silent reading speed used for estimates.
+const wordsPerMinute = 200
+
+// responseStats summarizes the length of a response as a word count and an
+// approximate reading time.
+func responseStats(content string) string {
+	words := len(strings.Fields(content))
+	minutes := (words + wordsPerMinute - 1) / wordsPerMinute
+	if minutes < 1 {
+		minutes = 1
+	}
+	unit := "words"
+	if words == 1 {
+		unit = "word"
+	}
+	return fmt.Sprintf("%d %s, ~%d min read", words, unit, minutes)
+}
+
 func (m model) sendMessage() tea.Cmd {
+	return m.sendRequest(m.messages, m.modelName)
+}
+
+// sendRequest asks modelName to respond to history.
+func (m model) sendRequest(history []chatMessage, modelName string) tea.Cmd {
 	return tea.Batch(
-		func() tea.Msg { return streamStartMsg{} },
-		m.streamResponse(),
+		func() tea.Msg { return streamStartMsg{tab: m.id} },
+		m.streamResponse(history, modelName),
 	)
 }
 
-func (m model) streamResponse() tea.Cmd {
+func (m model) streamResponse(history []chatMessage, modelName string) tea.Cmd {
 	return func() tea.Msg {
-		messages := make([]openai.ChatCompletionMessageParamUnion, len(m.messages))
-		for i, msg := range m.messages {
-			if msg.role == "user" {
-				messages[i] = openai.UserMessage(msg.content)
-			} else {
-				messages[i] = openai.AssistantMessage(msg.content)
-			}
-		}
-
 		// Start streaming and return the subscription
+		req, dropped := m.newRequest(history, modelName)
+		var sources []string
+		var ragErr error
+		if !m.config.RAG.Disabled {
+			ctx, cancel := context.WithTimeout(context.Background(), ragTimeout)
+			sources, ragErr = m.config.RAG.augment(ctx, &req)
+			cancel()
+		}
 		return streamStarted{
-			client:    m.client,
-			messages:  messages,
-			modelName: m.modelName,
+			tab:      m.id,
+			provider: m.provider,
+			req:      req,
+			dropped:  dropped,
+			sources:  sources,
+			ragErr:   ragErr,
 		}
 	}
 }
 
 type streamStarted struct {
-	client    *openai.Client
-	messages  []openai.ChatCompletionMessageParamUnion
-	modelName string
+	tab      int
+	provider provider
+	req      request
+	dropped  int // oldest messages left out to fit the context window
+	// sources are the indexed documents given with the request, and ragErr
+	// why they could not be searched. The request goes ahead either way.
+	sources []string
+	ragErr  error
 }
 
-func startStreamingInBackground(streamChan chan string, client *openai.Client, messages []openai.ChatCompletionMessageParamUnion, modelName string) {
+// maxReconnects is how many times a stream dropped by a network error is
+// resumed before giving up.
+const maxReconnects = 3
+
+// resumePrompt asks the model to carry on with a response that was cut off.
+const resumePrompt = "Your previous response was cut off by a network error. " +
+	"Continue it exactly where it stopped, without repeating anything."
+
+func startStreamingInBackground(ctx context.Context, streamChan chan streamEvent, p provider, req request, b budget) {
 	defer close(streamChan)
+	watchdog := b.watch(ctx, streamChan)
+	defer watchdog.close()
 
-	ctx := context.Background()
-	stream := client.Chat.Completions.NewStreaming(ctx, openai.ChatCompletionNewParams{
-		Messages: messages,
-		Model:    openai.ChatModel(modelName),
-	})
+	messages := req.messages
+	tokens := 0
+	// Resumed attempts are requests of their own, so their usage adds up
+	var used usage
+	var calls []toolCall
 
-	var fullResponse strings.Builder
-	for stream.Next() {
-		chunk := stream.Current()
-		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
-			fullResponse.WriteString(chunk.Choices[0].Delta.Content)
-			// Send accumulated content to channel
-			select {
-			case streamChan <- fullResponse.String():
-			default:
+	// Chunks of different choices are interleaved, so demultiplex them by
+	// choice index
+	responses := make([]strings.Builder, max(req.choices, 1))
+	// Only the first choice's thinking is kept, since it is only shown for
+	// a single response
+	var thinking strings.Builder
+	for attempt := 1; ; attempt++ {
+		chunks, err := p.stream(ctx, req)
+		if err == nil {
+			for c := range chunks {
+				if c.err != nil {
+					err = c.err
+					continue
+				}
+				if c.usage != nil {
+					used = used.add(*c.usage)
+					continue
+				}
+				if c.toolCalls != nil {
+					calls = append(calls, c.toolCalls...)
+					continue
+				}
+				if c.choice < 0 || c.choice >= len(responses) {
+					continue
+				}
+				responses[c.choice].WriteString(c.content)
+				if c.choice == 0 {
+					thinking.WriteString(c.reasoning)
+				}
+				// Each chunk is about one token
+				tokens++
+				watchdog.received(tokens)
+				// Send accumulated content to channel, skipping the update if
+				// the UI is behind since the next one supersedes it
+				answers, thought := splitResponses(responses, thinking.String())
+				select {
+				case streamChan <- streamEvent{content: completeRunes(answers), reasoning: completeRunes([]string{thought})[0], tokens: tokens}:
+				default:
+				}
 			}
 		}
-	}
+		if err == nil && ctx.Err() != nil {
+			// The provider stopped short because the request was cancelled
+			err = ctx.Err()
+		}
+		received, thought := splitResponses(responses, thinking.String())
+		if err == nil {
+			// The final result must not be dropped
+			sendEvent(ctx, streamChan, streamEvent{content: received, reasoning: thought, done: true, tokens: tokens, usage: used, toolCalls: calls})
+			return
+		}
 
-	// Send final result
-	if stream.Err() == nil {
-		select {
-		case streamChan <- "DONE:" + fullResponse.String():
-		default:
+		if ctx.Err() != nil {
+			stopped(ctx, streamChan, received, thought)
+			return
 		}
-	} else {
+		partial := strings.Join(received, "")
+		// A single response can be resumed by asking the model to continue
+		// it. Candidates of a multi-choice request cannot, so those are only
+		// retried while nothing has arrived yet.
+		canResume := partial == "" || len(responses) == 1
+		if !isNetworkError(err) || attempt > maxReconnects || !canResume {
+			// Keep whatever arrived so the break can be shown in place
+			sendEvent(ctx, streamChan, streamEvent{content: received, reasoning: thought, err: err, tokens: tokens, usage: used})
+			return
+		}
+
+		sendEvent(ctx, streamChan, streamEvent{content: received, reasoning: thought, reconnecting: attempt})
 		select {
-		case streamChan <- "ERROR:" + stream.Err().Error():
-		default:
+		case <-time.After(time.Duration(attempt) * time.Second):
+		case <-ctx.Done():
+			stopped(ctx, streamChan, received, thought)
+			return
+		}
+		if partial == "" {
+			// The model starts thinking over again
+			thinking.Reset()
+			for i := range responses {
+				responses[i].Reset()
+			}
+		} else {
+			req.messages = append(messages[:len(messages):len(messages)],
+				message{role: "assistant", content: partial},
+				message{role: "user", content: resumePrompt},
+			)
 		}
 	}
 }
 
-func listenForStreamUpdates(streamChan <-chan string) tea.Cmd {
+// isNetworkError reports whether err looks like a dropped connection rather
+// than an error returned by the API.
+func isNetworkError(err error) bool {
+	if _, ok := apiStatus(err); ok {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// sendEvent delivers an event that must not be dropped, unless the request
+// was cancelled and nobody is listening any more.
+func sendEvent(ctx context.Context, streamChan chan<- streamEvent, event streamEvent) {
+	select {
+	case streamChan <- event:
+	case <-ctx.Done():
+	}
+}
+
+// stopped reports why a cancelled request stopped, along with whatever it
+// received, so the partial response can be kept. Requests cancelled without a
+// reason, as when quitting, report nothing since nobody is listening. The
+// report is given up on after a moment, in case that is so anyway.
+func stopped(ctx context.Context, streamChan chan<- streamEvent, received []string, reasoning string) {
+	cause := context.Cause(ctx)
+	if errors.Is(cause, context.Canceled) {
+		return
+	}
+	select {
+	case streamChan <- streamEvent{content: received, reasoning: reasoning, err: cause}:
+	case <-time.After(shutdownTimeout):
+	}
+}
+
+// completeRunes drops an incomplete multibyte character from the end of each
+// response, so a character split across deltas is not drawn as a replacement
+// glyph. It is shown with the next update, once the rest has arrived.
+func completeRunes(responses []string) []string {
+	for i, s := range responses {
+		// An incomplete character is at most UTFMax-1 bytes long
+		for n := 1; n < utf8.UTFMax && n <= len(s); n++ {
+			if utf8.RuneStart(s[len(s)-n]) {
+				if !utf8.FullRuneInString(s[len(s)-n:]) {
+					responses[i] = s[:len(s)-n]
+				}
+				break
+			}
+		}
+	}
+	return responses
+}
+
+func builderStrings(builders []strings.Builder) []string {
+	s := make([]string, len(builders))
+	for i := range builders {
+		s[i] = builders[i].String()
+	}
+	return s
+}
+
+// listenForStreamUpdates waits for the next stream event. With a throttle,
+// updates arriving within the throttle interval are coalesced into one so
+// slow terminals re-render less often; content is cumulative, so only the
+// latest update matters.
+func listenForStreamUpdates(tab int, streamChan <-chan streamEvent, throttle time.Duration) tea.Cmd {
 	return func() tea.Msg {
 		select {
-		case content, ok := <-streamChan:
-			if !ok {
-				// Channel closed - streaming is done
-				return streamCompleteMsg{content: "", err: nil}
+		case event, ok := <-streamChan:
+			if !ok || throttle <= 0 || !event.isUpdate() {
+				return streamEventMsg(tab, event, ok)
 			}
-			if strings.HasPrefix(content, "DONE:") {
-				return streamCompleteMsg{content: content[5:], err: nil}
+			deadline := time.After(throttle)
+			for {
+				select {
+				case next, ok := <-streamChan:
+					if !ok || !next.isUpdate() {
+						return streamEventMsg(tab, next, ok)
+					}
+					event = next
+				case <-deadline:
+					return streamEventMsg(tab, event, true)
+				}
 			}
-			if strings.HasPrefix(content, "ERROR:") {
-				return streamCompleteMsg{content: "", err: fmt.Errorf("%s", content[6:])}
-			}
-			return streamUpdateMsg{content: content}
 		case <-time.After(50 * time.Millisecond):
 			// No update yet, return empty update and continue listening
-			return streamUpdateMsg{}
+			return streamUpdateMsg{tab: tab}
 		}
 	}
 }
 
+// isUpdate reports whether the event is plain progress that a later event
+// supersedes.
+func (e streamEvent) isUpdate() bool {
+	return !e.done && e.err == nil && e.reconnecting == 0 && e.overBudget == ""
+}
+
+// streamEventMsg converts a stream event for the given tab into the message
+// for Update. ok is false once the channel is closed.
+func streamEventMsg(tab int, event streamEvent, ok bool) tea.Msg {
+	if !ok {
+		// Channel closed - streaming is done
+		return streamCompleteMsg{tab: tab}
+	}
+	if event.err != nil {
+		return streamCompleteMsg{tab: tab, choices: event.content, reasoning: event.reasoning, err: event.err, tokens: event.tokens, usage: event.usage}
+	}
+	if event.done {
+		return streamCompleteMsg{tab: tab, choices: event.content, reasoning: event.reasoning, tokens: event.tokens, usage: event.usage, toolCalls: event.toolCalls}
+	}
+	return streamUpdateMsg{
+		tab:          tab,
+		choices:      event.content,
+		reasoning:    event.reasoning,
+		reconnecting: event.reconnecting,
+		overBudget:   event.overBudget,
+		tokens:       event.tokens,
+	}
+}
+
 func main() {
-	p := tea.NewProgram(initialModel(), tea.WithAltScreen())
-	if _, err := p.Run(); err != nil {
-		fmt.Printf("Error running program: %v", err)
-		os.Exit(1)
+	transcriptPath := flag.String("transcript", "", "append every finalized turn to this markdown `file`")
+	profileName := flag.String("profile", "", "start with the named profile from the config file")
+	transcriptDeltas := flag.Bool("transcript-deltas", false, "also write responses to the transcript as they stream")
+	debugPath := flag.String("debug", "", "write debug logs to this `file`")
+	resume := flag.Bool("resume", false, "reopen the most recently saved session")
+	exportName := flag.String("export", "", "print the saved `session` and exit")
+	exportFormat := flag.String("format", "markdown", "the `format` --export prints, markdown or json")
+	pipeSystem := flag.String("p", "", "answer the arguments and stdin without the TUI, following this system `prompt`")
+	var cli cliOptions
+	flag.StringVar(&cli.provider, "provider", "", "use this `provider` instead of the configured one")
+	flag.StringVar(&cli.model, "model", "", "use this `model` instead of the configured one")
+	flag.StringVar(&cli.system, "system", "", "use this system `prompt`")
+	flag.StringVar(&cli.session, "session", "", "open the saved `session`, or continue it when answering without the TUI")
+	flag.BoolVar(&cli.noStream, "no-stream", false, "show responses only once they are complete")
+	flag.StringVar(&configFile, "config", "", "read the config from this `file`")
+	flag.Usage = printUsage
+	flag.Parse()
+
+	if *exportName != "" {
+		if err := exportSession(*exportName, *exportFormat); err != nil {
+			exitWithError(err)
+		}
+		return
+	}
+	if ok, err := runSubcommand(flag.Args(), *exportFormat); ok {
+		if err != nil {
+			exitWithError(err)
+		}
+		return
+	}
+	if cli.provider != "" {
+		// Picked up wherever the provider is chosen, as if set in the environment
+		os.Setenv("LLMTUI_PROVIDER", cli.provider)
+	}
+
+	// The terminal belongs to the TUI, so logs go to a file or nowhere
+	if *debugPath != "" {
+		f, err := tea.LogToFile(*debugPath, "debug")
+		if err != nil {
+			exitWithError(err)
+		}
+		defer f.Close()
+	} else {
+		log.SetOutput(io.Discard)
+	}
+
+	// A prompt given as arguments or piped in is answered on stdout
+	if *pipeSystem != "" || flag.NArg() > 0 || stdinPiped() {
+		var stdin io.Reader
+		if stdinPiped() {
+			stdin = os.Stdin
+		}
+		prompt, err := pipePrompt(flag.Args(), stdin)
+		if err != nil {
+			exitWithError(err)
+		}
+		m := initialModel(*profileName)
+		if err := cli.apply(&m); err != nil {
+			exitWithError(err)
+		}
+		if *pipeSystem != "" {
+			m.systemPrompt = *pipeSystem
+		}
+		if err := runPipe(m, prompt, os.Stdout); err != nil {
+			exitWithError(err)
+		}
+		return
+	}
+
+	detectMarkdownStyle()
+	m := initialModel(*profileName)
+	if *resume && m.err == nil {
+		m.resumeLatest()
+	} else if m.err == nil && m.setup == nil {
+		m.pickSessionOnStart()
+	}
+	if err := cli.apply(&m); err != nil {
+		exitWithError(err)
+	}
+	if *transcriptPath != "" {
+		t, err := openTranscript(*transcriptPath, *transcriptDeltas)
+		if err != nil {
+			exitWithError(err)
+		}
+		defer t.Close()
+		m.transcript = t
+	}
+
+	opts := []tea.ProgramOption{tea.WithAltScreen()}
+	if !m.config.DisableMouse {
+		opts = append(opts, tea.WithMouseCellMotion())
+	}
+	p := tea.NewProgram(m, opts...)
+	final, err := p.Run()
+	if final != nil {
+		final.(model).closeMCP()
+	}
+	// Autosaves still waiting when the program quit
+	if err := flushAutosaves(); err != nil {
+		fmt.Fprintln(os.Stderr, "llmtui: autosave failed:", err)
+	}
+	if err != nil {
+		exitWithError(fmt.Errorf("running program: %w", err))
+	}
+	// A fatal error was shown in the TUI, report it for scripts too
+	if err := final.(model).err; err != nil {
+		exitWithError(err)
 	}
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// slowProvider streams the start of a response and then nothing more until
+// the request is cancelled, like a model taking its time.
+type slowProvider struct {
+	running sync.WaitGroup
+}
+
+func (p *slowProvider) stream(ctx context.Context, req request) (<-chan chunk, error) {
+	ch := make(chan chunk)
+	p.running.Add(1)
+	go func() {
+		defer p.running.Done()
+		defer close(ch)
+		if sendChunk(ctx, ch, chunk{content: "Hello"}) {
+			<-ctx.Done()
+		}
+	}()
+	return ch, nil
+}
+
+func testModel(t *testing.T) model {
+	t.Helper()
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("OPENAI_API_KEY", "test")
+	return initialModel("")
+}
+
+func TestQuitStopsStreaming(t *testing.T) {
+	m := testModel(t)
+	p := &slowProvider{}
+	next, _ := m.Update(streamStarted{tab: m.id, provider: p, req: request{model: "test"}})
+	m = next.(model)
+	events, done := m.streamChan, m.streamDone
+
+	select {
+	case <-events:
+	case <-time.After(time.Second):
+		t.Fatal("no update from the stream")
+	}
+
+	m.quit()
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout):
+		t.Fatalf("the stream was still running %v after quitting", shutdownTimeout)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		p.running.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(shutdownTimeout):
+		t.Fatal("the provider's request was still running after quitting")
+	}
+	// Whatever was sent before the stream ended can still be read, and
+	// nothing is sent once it is closed
+	for range events {
+	}
+}
+
+func TestCompleteRunes(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"two bytes", "café"},
+		{"three bytes", "price in €"},
+		{"four bytes", "done 🎉"},
+		{"several", "é🎉€ and more é"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Split the text at every byte, as deltas may be
+			for i := 1; i < len(tt.text); i++ {
+				got := completeRunes([]string{tt.text[:i]})[0]
+				if !utf8.ValidString(got) || strings.ContainsRune(got, utf8.RuneError) {
+					t.Errorf("after %d bytes got %q, with a broken character", i, got)
+				}
+				want := tt.text[:i]
+				for !utf8.ValidString(want) {
+					want = want[:len(want)-1]
+				}
+				if got != want {
+					t.Errorf("after %d bytes got %q, want %q", i, got, want)
+				}
+			}
+			if got := completeRunes([]string{tt.text})[0]; got != tt.text {
+				t.Errorf("complete text became %q", got)
+			}
+		})
+	}
+}
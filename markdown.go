@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/glamour"
+	"github.com/charmbracelet/glamour/styles"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+)
+
+// markdownStyle is the glamour style responses are rendered in. It is picked
+// by detectMarkdownStyle before the program starts, since asking the terminal
+// for its background once it is running would read the answer as keypresses.
+var markdownStyle = styles.DarkStyle
+
+func detectMarkdownStyle() {
+	switch {
+	case lipgloss.ColorProfile() == termenv.Ascii:
+		markdownStyle, codeStyle = styles.NoTTYStyle, ""
+	case !lipgloss.HasDarkBackground():
+		markdownStyle, codeStyle = styles.LightStyle, "monokailight"
+	}
+}
+
+// defaultMarkdownWidth is the wrapping width used until the terminal size is
+// known.
+const defaultMarkdownWidth = 80
+
+// maxMarkdownCache bounds how many rendered responses are kept. The whole
+// conversation is rendered on every update, so finished responses are only
+// rendered again when the width changes. A response still streaming is
+// different text on every update, so it is not cached at all.
+const maxMarkdownCache = 1000
+
+type markdownKey struct {
+	text  string
+	width int
+}
+
+// maxMarkdownRenderers bounds how many glamour renderers are kept, one for
+// each width, as dragging the edge of a window passes through many.
+const maxMarkdownRenderers = 8
+
+var (
+	markdownRenderers = map[int]*glamour.TermRenderer{}
+	markdownCache     = map[markdownKey]string{}
+)
+
+// renderMarkdown renders the text of a finished message as markdown wrapped
+// to width columns, with fenced code highlighted in boxes of its own.
+func renderMarkdown(text string, width int) string {
+	if width <= 0 {
+		width = defaultMarkdownWidth
+	}
+	key := markdownKey{text, width}
+	if out, ok := markdownCache[key]; ok {
+		return out
+	}
+	out := renderPartialMarkdown(text, width)
+	if len(markdownCache) >= maxMarkdownCache {
+		clear(markdownCache)
+	}
+	markdownCache[key] = out
+	return out
+}
+
+// renderPartialMarkdown renders markdown as renderMarkdown does, without
+// caching it, for a response that is still arriving.
+func renderPartialMarkdown(text string, width int) string {
+	if width <= 0 {
+		width = defaultMarkdownWidth
+	}
+	var rendered []string
+	for _, part := range splitFences(text) {
+		if part.code {
+			rendered = append(rendered, renderCode(part.lang, part.text, width))
+		} else {
+			rendered = append(rendered, renderProse(part.text, width))
+		}
+	}
+	return strings.Join(rendered, "\n\n")
+}
+
+// renderProse renders markdown without fenced code through glamour. Text that
+// cannot be rendered is returned as it is.
+func renderProse(text string, width int) string {
+	r, ok := markdownRenderers[width]
+	if !ok {
+		var err error
+		r, err = glamour.NewTermRenderer(
+			glamour.WithStandardStyle(markdownStyle),
+			glamour.WithColorProfile(lipgloss.ColorProfile()),
+			glamour.WithWordWrap(width),
+		)
+		if err != nil {
+			return text
+		}
+		if len(markdownRenderers) >= maxMarkdownRenderers {
+			clear(markdownRenderers)
+		}
+		markdownRenderers[width] = r
+	}
+	out, err := r.Render(text)
+	if err != nil {
+		return text
+	}
+	// Glamour surrounds the document with blank lines
+	return strings.Trim(out, "\n")
+}
@@ -0,0 +1,782 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Model Context Protocol servers offer tools, and resources to read, to any
+// client. Those named in the config's [mcp_servers] table are started (or
+// connected to, given a URL) when llmtui starts, and their tools are
+// declared alongside the built-in ones.
+
+// mcpServer is how to reach an MCP server: a command speaking the protocol
+// over its standard input and output, or the URL of its server-sent events
+// endpoint.
+type mcpServer struct {
+	Command string            `toml:"command,omitempty"`
+	Args    []string          `toml:"args,omitempty"`
+	Env     map[string]string `toml:"env,omitempty"` // added to llmtui's environment
+	URL     string            `toml:"url,omitempty"`
+	// Tools limits which of the server's tools are offered to the model.
+	// All of them are when it is empty.
+	Tools []string `toml:"tools,omitempty"`
+}
+
+// mcpProtocolVersion is the protocol version llmtui asks servers for.
+const mcpProtocolVersion = "2024-11-05"
+
+// mcpConnectTimeout bounds starting a server and learning what it offers.
+const mcpConnectTimeout = 30 * time.Second
+
+// maxListedResources caps how many of a server's resources are named in the
+// description of the tool that reads them.
+const maxListedResources = 50
+
+// validateMCPServers checks each server has one way of reaching it.
+func (c config) validateMCPServers() error {
+	for name, s := range c.MCPServers {
+		if invalidToolChars.MatchString(name) {
+			return fmt.Errorf("MCP server name %q may only have letters, digits, - and _", name)
+		}
+		if (s.Command == "") == (s.URL == "") {
+			return fmt.Errorf("MCP server %s needs either a command or a url", name)
+		}
+	}
+	return nil
+}
+
+// mcpClient is a connection to an MCP server, with what it offers.
+type mcpClient struct {
+	name      string
+	transport mcpTransport
+	tools     []tool // as offered to the model, named after the server
+	resources int    // how many resources the server lists
+
+	mu      sync.Mutex
+	nextID  int64
+	pending map[int64]chan mcpResponse
+	err     error // why the connection ended, once it has
+}
+
+// mcpTransport carries JSON-RPC messages to and from a server.
+type mcpTransport interface {
+	send(ctx context.Context, msg []byte) error
+	// receive blocks until the next message arrives or the connection ends.
+	receive() ([]byte, error)
+	close() error
+}
+
+// mcpMessage is any JSON-RPC message: a request, a notification or a
+// response.
+type mcpMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  any             `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *mcpError       `json:"error,omitempty"`
+}
+
+type mcpResponse struct {
+	result json.RawMessage
+	err    error
+}
+
+type mcpError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *mcpError) Error() string { return e.Message }
+
+// mcpConnectedMsg reports the outcome of connecting to a server.
+type mcpConnectedMsg struct {
+	client *mcpClient
+	name   string
+	err    error
+	// reconnected marks a connection asked for with /mcp, which is
+	// reported even if it succeeds.
+	reconnected bool
+}
+
+// connectMCP connects to every configured server in the background.
+func (m model) connectMCP() tea.Cmd {
+	var cmds []tea.Cmd
+	for name, s := range m.config.MCPServers {
+		cmds = append(cmds, func() tea.Msg {
+			ctx, cancel := context.WithTimeout(context.Background(), mcpConnectTimeout)
+			defer cancel()
+			c, err := dialMCP(ctx, name, s)
+			return mcpConnectedMsg{client: c, name: name, err: err}
+		})
+	}
+	return tea.Batch(cmds...)
+}
+
+// addMCPClient makes a connected server's tools available.
+func (m *model) addMCPClient(msg mcpConnectedMsg) {
+	if msg.err != nil {
+		m.notice = fmt.Sprintf("MCP server %s: %v", msg.name, msg.err)
+		return
+	}
+	m.mcpClients = append(slices.Clone(m.mcpClients), msg.client)
+	slices.SortFunc(m.mcpClients, func(a, b *mcpClient) int { return strings.Compare(a.name, b.name) })
+	if msg.reconnected {
+		m.notice = fmt.Sprintf("Connected to %s, with %d tools", msg.name, len(msg.client.tools))
+	}
+}
+
+func runMCP(m *model, args string) tea.Cmd {
+	if args != "" {
+		m.notice = "Usage: /mcp"
+		return nil
+	}
+	if len(m.config.MCPServers) == 0 {
+		m.notice = "No MCP servers are configured, add them under [mcp_servers]"
+		return nil
+	}
+	m.palette = &palette{entries: mcpEntries, noMatches: "No matching servers", verb: "reconnect"}
+	return nil
+}
+
+// mcpEntries lists the configured servers with what they offer, each
+// reconnecting to the server, as after it has been restarted or changed.
+func mcpEntries(m model) []paletteEntry {
+	names := make([]string, 0, len(m.config.MCPServers))
+	for name := range m.config.MCPServers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	entries := make([]paletteEntry, len(names))
+	for i, name := range names {
+		label, help := name+" (not connected)", ""
+		if c := m.mcpClient(name); c != nil {
+			label = fmt.Sprintf("%s (%d tools, %d resources)", name, len(c.tools), c.resources)
+			tools := make([]string, len(c.tools))
+			for j, t := range c.tools {
+				tools[j] = t.name
+			}
+			help = strings.Join(tools, ", ")
+			if err := c.failed(); err != nil {
+				label, help = name+" (disconnected)", err.Error()
+			}
+		}
+		entries[i] = paletteEntry{label: label, help: help, run: func(m *model) tea.Cmd {
+			if c := m.mcpClient(name); c != nil {
+				c.close()
+				m.mcpClients = slices.DeleteFunc(slices.Clone(m.mcpClients), func(c *mcpClient) bool { return c.name == name })
+			}
+			m.notice = "Connecting to " + name + "..."
+			s := m.config.MCPServers[name]
+			return func() tea.Msg {
+				ctx, cancel := context.WithTimeout(context.Background(), mcpConnectTimeout)
+				defer cancel()
+				c, err := dialMCP(ctx, name, s)
+				return mcpConnectedMsg{client: c, name: name, err: err, reconnected: true}
+			}
+		}}
+	}
+	return entries
+}
+
+// mcpClient returns the connection to the named server, if there is one.
+func (m model) mcpClient(name string) *mcpClient {
+	for _, c := range m.mcpClients {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// closeMCP ends the connections to every server, stopping those llmtui
+// started.
+func (m model) closeMCP() {
+	for _, c := range m.mcpClients {
+		c.close()
+	}
+}
+
+// dialMCP starts or connects to a server and learns what it offers.
+func dialMCP(ctx context.Context, name string, s mcpServer) (*mcpClient, error) {
+	var t mcpTransport
+	var err error
+	if s.Command != "" {
+		t, err = startStdioTransport(name, s)
+	} else {
+		t, err = dialSSETransport(ctx, s.URL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &mcpClient{name: name, transport: t, pending: make(map[int64]chan mcpResponse)}
+	go c.readLoop()
+	if err := c.initialize(ctx, s); err != nil {
+		c.close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// initialize agrees on the protocol with the server and lists its tools and
+// resources.
+func (c *mcpClient) initialize(ctx context.Context, s mcpServer) error {
+	version := "(devel)"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		version = info.Main.Version
+	}
+	var init struct {
+		Capabilities struct {
+			Tools     *struct{} `json:"tools"`
+			Resources *struct{} `json:"resources"`
+		} `json:"capabilities"`
+	}
+	err := c.call(ctx, "initialize", map[string]any{
+		"protocolVersion": mcpProtocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo":      map[string]any{"name": "llmtui", "version": version},
+	}, &init)
+	if err != nil {
+		return err
+	}
+	if err := c.notify(ctx, "notifications/initialized", nil); err != nil {
+		return err
+	}
+	if init.Capabilities.Tools != nil {
+		if err := c.listTools(ctx, s.Tools); err != nil {
+			return fmt.Errorf("listing tools: %w", err)
+		}
+	}
+	if init.Capabilities.Resources != nil {
+		if err := c.listResources(ctx); err != nil {
+			return fmt.Errorf("listing resources: %w", err)
+		}
+	}
+	return nil
+}
+
+// listTools offers the server's tools, or those of them in allowed, to the
+// model.
+func (c *mcpClient) listTools(ctx context.Context, allowed []string) error {
+	var cursor string
+	for {
+		var page struct {
+			Tools []struct {
+				Name        string         `json:"name"`
+				Description string         `json:"description"`
+				InputSchema map[string]any `json:"inputSchema"`
+			} `json:"tools"`
+			NextCursor string `json:"nextCursor"`
+		}
+		if err := c.call(ctx, "tools/list", pageParams(cursor), &page); err != nil {
+			return err
+		}
+		for _, t := range page.Tools {
+			if len(allowed) > 0 && !slices.Contains(allowed, t.Name) {
+				continue
+			}
+			schema := t.InputSchema
+			if schema == nil {
+				schema = map[string]any{"type": "object", "properties": map[string]any{}}
+			}
+			c.tools = append(c.tools, tool{
+				name:        mcpToolName(c.name, t.Name),
+				description: t.Description,
+				parameters:  schema,
+				run: func(ctx context.Context, _ config, args json.RawMessage) (string, error) {
+					return c.callTool(ctx, t.Name, args)
+				},
+			})
+		}
+		if cursor = page.NextCursor; cursor == "" {
+			return nil
+		}
+	}
+}
+
+// listResources offers a tool reading the server's resources, described by
+// listing them, if it has any.
+func (c *mcpClient) listResources(ctx context.Context) error {
+	var lines []string
+	var cursor string
+	for {
+		var page struct {
+			Resources []struct {
+				URI         string `json:"uri"`
+				Name        string `json:"name"`
+				Description string `json:"description"`
+			} `json:"resources"`
+			NextCursor string `json:"nextCursor"`
+		}
+		if err := c.call(ctx, "resources/list", pageParams(cursor), &page); err != nil {
+			return err
+		}
+		for _, r := range page.Resources {
+			line := "- " + r.URI
+			if r.Name != "" {
+				line += " (" + r.Name + ")"
+			}
+			if r.Description != "" {
+				line += ": " + r.Description
+			}
+			lines = append(lines, line)
+		}
+		if cursor = page.NextCursor; cursor == "" {
+			break
+		}
+	}
+	c.resources = len(lines)
+	if len(lines) == 0 {
+		return nil
+	}
+	description := fmt.Sprintf("Read a resource from the %s server by its URI. It has these resources:\n", c.name)
+	if len(lines) > maxListedResources {
+		lines = append(lines[:maxListedResources], fmt.Sprintf("- and %d more", len(lines)-maxListedResources))
+	}
+	c.tools = append(c.tools, tool{
+		name:        mcpToolName(c.name, "read_resource"),
+		description: description + strings.Join(lines, "\n"),
+		parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"uri": map[string]any{"type": "string", "description": "The resource's URI."},
+			},
+			"required": []string{"uri"},
+		},
+		run: c.readResource,
+	})
+	return nil
+}
+
+func pageParams(cursor string) any {
+	if cursor == "" {
+		return nil
+	}
+	return map[string]any{"cursor": cursor}
+}
+
+// invalidToolChars matches what providers do not allow in tool names.
+var invalidToolChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// mcpToolName names a server's tool for the model, prefixed with the server's
+// name so servers' tools cannot clash, within the 64 characters providers
+// allow.
+func mcpToolName(server, name string) string {
+	full := invalidToolChars.ReplaceAllString(server+"_"+name, "_")
+	if len(full) > 64 {
+		full = full[:64]
+	}
+	return full
+}
+
+// callTool runs one of the server's tools, returning the text of its result.
+func (c *mcpClient) callTool(ctx context.Context, name string, args json.RawMessage) (string, error) {
+	var result struct {
+		Content []mcpContent `json:"content"`
+		IsError bool         `json:"isError"`
+	}
+	if err := c.call(ctx, "tools/call", map[string]any{"name": name, "arguments": args}, &result); err != nil {
+		return "", err
+	}
+	parts := make([]string, len(result.Content))
+	for i, content := range result.Content {
+		parts[i] = content.text()
+	}
+	text := strings.Join(parts, "\n")
+	if result.IsError {
+		return "", errors.New(text)
+	}
+	return text, nil
+}
+
+// readResource reads one of the server's resources as a tool call.
+func (c *mcpClient) readResource(ctx context.Context, _ config, args json.RawMessage) (string, error) {
+	var params struct {
+		URI string `json:"uri"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", err
+	}
+	var result struct {
+		Contents []mcpResource `json:"contents"`
+	}
+	if err := c.call(ctx, "resources/read", map[string]any{"uri": params.URI}, &result); err != nil {
+		return "", err
+	}
+	parts := make([]string, len(result.Contents))
+	for i, r := range result.Contents {
+		parts[i] = r.text()
+	}
+	return strings.Join(parts, "\n"), nil
+}
+
+// mcpContent is part of a tool's result.
+type mcpContent struct {
+	Type     string       `json:"type"`
+	Text     string       `json:"text"`
+	MIMEType string       `json:"mimeType"`
+	Resource *mcpResource `json:"resource"`
+}
+
+// text returns the content as text, with a placeholder for anything else,
+// since results are given back to the model as text.
+func (c mcpContent) text() string {
+	switch {
+	case c.Type == "text":
+		return c.Text
+	case c.Resource != nil:
+		return c.Resource.text()
+	}
+	return fmt.Sprintf("[%s: %s]", c.Type, c.MIMEType)
+}
+
+// mcpResource is the contents of a resource, as text or as base64 data.
+type mcpResource struct {
+	URI      string  `json:"uri"`
+	MIMEType string  `json:"mimeType"`
+	Text     *string `json:"text"`
+}
+
+func (r mcpResource) text() string {
+	if r.Text != nil {
+		return *r.Text
+	}
+	return fmt.Sprintf("[resource: %s, %s]", r.URI, r.MIMEType)
+}
+
+// call sends a request and decodes its result into result, or stops waiting
+// for it, and tells the server so, when ctx is done.
+func (c *mcpClient) call(ctx context.Context, method string, params any, result any) error {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.nextID++
+	id := c.nextID
+	reply := make(chan mcpResponse, 1)
+	c.pending[id] = reply
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.write(ctx, mcpMessage{ID: json.RawMessage(fmt.Sprint(id)), Method: method, Params: params}); err != nil {
+		return err
+	}
+	select {
+	case r := <-reply:
+		if r.err != nil {
+			return r.err
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(r.result, result)
+	case <-ctx.Done():
+		// Best effort, as the server may be what is stuck
+		go c.notify(context.Background(), "notifications/cancelled", map[string]any{"requestId": id, "reason": context.Cause(ctx).Error()})
+		return context.Cause(ctx)
+	}
+}
+
+// notify sends a notification, which has no response.
+func (c *mcpClient) notify(ctx context.Context, method string, params any) error {
+	return c.write(ctx, mcpMessage{Method: method, Params: params})
+}
+
+func (c *mcpClient) write(ctx context.Context, msg mcpMessage) error {
+	msg.JSONRPC = "2.0"
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.transport.send(ctx, data)
+}
+
+// readLoop hands responses to the calls waiting for them and answers the
+// server's own requests, until the connection ends.
+func (c *mcpClient) readLoop() {
+	for {
+		data, err := c.transport.receive()
+		if err != nil {
+			c.fail(fmt.Errorf("MCP server %s: %w", c.name, err))
+			return
+		}
+		var msg mcpMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			log.Printf("MCP server %s sent an invalid message: %v", c.name, err)
+			continue
+		}
+		switch {
+		case msg.Method != "" && msg.ID != nil:
+			c.answer(msg)
+		case msg.Method != "":
+			// Notifications, such as changes to the tools, are not followed
+		default:
+			var id int64
+			if json.Unmarshal(msg.ID, &id) != nil {
+				continue
+			}
+			c.mu.Lock()
+			reply, ok := c.pending[id]
+			c.mu.Unlock()
+			if !ok {
+				continue
+			}
+			if msg.Error != nil {
+				reply <- mcpResponse{err: msg.Error}
+			} else {
+				reply <- mcpResponse{result: msg.Result}
+			}
+		}
+	}
+}
+
+// answer replies to a request from the server. Only pings are supported,
+// since llmtui does not offer sampling or roots.
+func (c *mcpClient) answer(req mcpMessage) {
+	reply := mcpMessage{ID: req.ID}
+	if req.Method == "ping" {
+		reply.Result = json.RawMessage("{}")
+	} else {
+		reply.Error = &mcpError{Code: -32601, Message: "method not found: " + req.Method}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mcpConnectTimeout)
+	defer cancel()
+	if err := c.write(ctx, reply); err != nil {
+		log.Printf("MCP server %s: %v", c.name, err)
+	}
+}
+
+// fail ends the calls waiting on a connection that has ended.
+func (c *mcpClient) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	}
+	for id, reply := range c.pending {
+		reply <- mcpResponse{err: c.err}
+		delete(c.pending, id)
+	}
+}
+
+// failed returns why the connection ended, or nil while it is open.
+func (c *mcpClient) failed() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *mcpClient) close() {
+	c.fail(fmt.Errorf("MCP server %s: connection closed", c.name))
+	c.transport.close()
+}
+
+// stdioTransport speaks to a server llmtui started, a message per line.
+type stdioTransport struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	mu     sync.Mutex // serializes writes
+}
+
+func startStdioTransport(name string, s mcpServer) (*stdioTransport, error) {
+	cmd := exec.Command(s.Command, s.Args...)
+	cmd.Env = os.Environ()
+	keys := make([]string, 0, len(s.Env))
+	for k := range s.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		cmd.Env = append(cmd.Env, k+"="+s.Env[k])
+	}
+	// The terminal belongs to the TUI, so the server's logs go to the debug log
+	cmd.Stderr = log.Writer()
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("MCP server %s: %w", name, err)
+	}
+	return &stdioTransport{cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout)}, nil
+}
+
+func (t *stdioTransport) send(ctx context.Context, msg []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, err := t.stdin.Write(append(msg, '\n'))
+	return err
+}
+
+func (t *stdioTransport) receive() ([]byte, error) {
+	for {
+		line, err := t.stdout.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			return line, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// close asks the server to exit by closing its input, killing it if it has
+// not shortly after.
+func (t *stdioTransport) close() error {
+	t.stdin.Close()
+	exited := make(chan struct{})
+	go func() {
+		t.cmd.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+	case <-time.After(shutdownTimeout):
+		t.cmd.Process.Kill()
+	}
+	return nil
+}
+
+// sseTransport speaks to a server over HTTP: messages arrive as server-sent
+// events and are sent by posting them to the endpoint the first event names.
+type sseTransport struct {
+	body     io.ReadCloser
+	events   *bufio.Scanner
+	endpoint string
+}
+
+func dialSSETransport(ctx context.Context, rawURL string) (*sseTransport, error) {
+	// The stream outlives connecting, so it is not bound to ctx
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", rawURL, resp.Status)
+	}
+	events := bufio.NewScanner(resp.Body)
+	events.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	t := &sseTransport{body: resp.Body, events: events}
+
+	found := make(chan error, 1)
+	go func() {
+		event, data, err := t.next()
+		switch {
+		case err != nil:
+		case event != "endpoint":
+			err = fmt.Errorf("%s: expected an endpoint event, got %q", rawURL, event)
+		default:
+			base, _ := url.Parse(rawURL)
+			var endpoint *url.URL
+			if endpoint, err = base.Parse(string(data)); err == nil {
+				t.endpoint = endpoint.String()
+			}
+		}
+		found <- err
+	}()
+	select {
+	case err = <-found:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// next reads the next event's name and data.
+func (t *sseTransport) next() (string, []byte, error) {
+	event := "message"
+	var data [][]byte
+	for t.events.Scan() {
+		line := t.events.Bytes()
+		switch {
+		case len(line) == 0:
+			if data != nil {
+				return event, bytes.Join(data, []byte("\n")), nil
+			}
+			event = "message"
+		case bytes.HasPrefix(line, []byte("event:")):
+			event = string(bytes.TrimSpace(line[len("event:"):]))
+		case bytes.HasPrefix(line, []byte("data:")):
+			value := bytes.TrimPrefix(line[len("data:"):], []byte(" "))
+			data = append(data, slices.Clone(value))
+		}
+	}
+	if err := t.events.Err(); err != nil {
+		return "", nil, err
+	}
+	return "", nil, io.EOF
+}
+
+func (t *sseTransport) send(ctx context.Context, msg []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(msg))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func (t *sseTransport) receive() ([]byte, error) {
+	for {
+		event, data, err := t.next()
+		if err != nil {
+			return nil, err
+		}
+		if event == "message" {
+			return data, nil
+		}
+	}
+}
+
+func (t *sseTransport) close() error {
+	return t.body.Close()
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Memories are facts about the user kept across conversations, such as the
+// tools they use or how they like answers. They are stored with the sessions
+// and given to the model with every request. The user manages them with
+// /memory, and the model with the remember and forget tools.
+
+// memory is a fact to be remembered.
+type memory struct {
+	id      int64
+	content string
+	created time.Time
+}
+
+// memoryPrompt introduces the memories to the model.
+const memoryPrompt = "You know these things about the user from earlier conversations. " +
+	"Keep them in mind without mentioning them unless they matter:"
+
+// loadMemories returns the stored memories, oldest first.
+func loadMemories() ([]memory, error) {
+	db, err := openStore()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT id, content, created FROM memories ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("load memories: %w", err)
+	}
+	defer rows.Close()
+	var memories []memory
+	for rows.Next() {
+		var mem memory
+		var created string
+		if err := rows.Scan(&mem.id, &mem.content, &created); err != nil {
+			return nil, fmt.Errorf("load memories: %w", err)
+		}
+		mem.created = parseTime(created)
+		memories = append(memories, mem)
+	}
+	return memories, rows.Err()
+}
+
+// addMemory stores a memory, returning its id.
+func addMemory(content string) (int64, error) {
+	db, err := openStore()
+	if err != nil {
+		return 0, err
+	}
+	res, err := db.Exec(`INSERT INTO memories (content, created) VALUES (?, ?)`, content, formatTime(time.Now()))
+	if err != nil {
+		return 0, fmt.Errorf("save memory: %w", err)
+	}
+	return res.LastInsertId()
+}
+
+// errNoMemory is returned for a memory id that is not stored.
+var errNoMemory = errors.New("no such memory")
+
+func deleteMemory(id int64) error {
+	db, err := openStore()
+	if err != nil {
+		return err
+	}
+	res, err := db.Exec(`DELETE FROM memories WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("forget memory: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w %d", errNoMemory, id)
+	}
+	return nil
+}
+
+// replaceMemories stores contents in place of every memory, keeping the
+// creation time of those that are unchanged.
+func replaceMemories(contents []string) error {
+	memories, err := loadMemories()
+	if err != nil {
+		return err
+	}
+	created := make(map[string]time.Time)
+	for _, mem := range memories {
+		created[mem.content] = mem.created
+	}
+	db, err := openStore()
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM memories`); err != nil {
+		return fmt.Errorf("save memories: %w", err)
+	}
+	for _, content := range contents {
+		t, ok := created[content]
+		if !ok {
+			t = time.Now()
+		}
+		if _, err := tx.Exec(`INSERT INTO memories (content, created) VALUES (?, ?)`, content, formatTime(t)); err != nil {
+			return fmt.Errorf("save memories: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// memoryMessage gives the memories to the model, with their ids so it can
+// forget them, or returns false if there are none. Memories that cannot be
+// loaded are left out rather than holding up the request.
+func memoryMessage() (message, bool) {
+	memories, err := loadMemories()
+	if err != nil || len(memories) == 0 {
+		return message{}, false
+	}
+	var b strings.Builder
+	b.WriteString(memoryPrompt)
+	for _, mem := range memories {
+		fmt.Fprintf(&b, "\n- %s (memory %d)", mem.content, mem.id)
+	}
+	return message{role: "system", content: b.String()}, true
+}
+
+func rememberTool(ctx context.Context, c config, args json.RawMessage) (string, error) {
+	var params struct {
+		Content string `json:"content"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", err
+	}
+	content := strings.Join(strings.Fields(params.Content), " ")
+	if content == "" {
+		return "", errors.New("nothing to remember")
+	}
+	id, err := addMemory(content)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Remembered as memory %d", id), nil
+}
+
+func forgetTool(ctx context.Context, c config, args json.RawMessage) (string, error) {
+	var params struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", err
+	}
+	if err := deleteMemory(params.ID); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Forgot memory %d", params.ID), nil
+}
+
+// memoryEditedMsg carries the file the memories were edited in.
+type memoryEditedMsg struct {
+	path string
+	err  error
+}
+
+// memoryFileHeader explains the file the memories are edited in. Lines
+// starting with # are left out when it is read back.
+const memoryFileHeader = `# One memory per line. Delete a line to forget it, or add one to remember
+# something new. Lines starting with # are ignored.
+`
+
+func runMemory(m *model, args string) tea.Cmd {
+	sub, rest, _ := strings.Cut(args, " ")
+	rest = strings.Join(strings.Fields(rest), " ")
+	switch sub {
+	case "":
+		memories, err := loadMemories()
+		if err != nil {
+			m.notice = err.Error()
+			return nil
+		}
+		if len(memories) == 0 {
+			m.notice = "Nothing remembered yet, add something with /memory add <text>"
+			return nil
+		}
+		m.palette = &palette{entries: memoryEntries, noMatches: "No matching memories", verb: "forget"}
+	case "add":
+		if rest == "" {
+			m.notice = "Usage: /memory add <text>"
+			return nil
+		}
+		id, err := addMemory(rest)
+		if err != nil {
+			m.notice = err.Error()
+			return nil
+		}
+		m.notice = fmt.Sprintf("Remembered as memory %d, the model is given it in every conversation", id)
+	case "forget":
+		id, err := strconv.ParseInt(rest, 10, 64)
+		if err != nil {
+			m.notice = "Usage: /memory forget <id>"
+			return nil
+		}
+		if err := deleteMemory(id); err != nil {
+			m.notice = err.Error()
+			return nil
+		}
+		m.notice = fmt.Sprintf("Forgot memory %d", id)
+	case "edit":
+		memories, err := loadMemories()
+		if err != nil {
+			m.notice = err.Error()
+			return nil
+		}
+		var b strings.Builder
+		b.WriteString(memoryFileHeader)
+		for _, mem := range memories {
+			b.WriteString(mem.content + "\n")
+		}
+		return m.editText("llmtui-memory-*.txt", b.String(), func(path string, err error) tea.Msg {
+			return memoryEditedMsg{path: path, err: err}
+		})
+	case "clear":
+		return m.confirmAction("Forget everything remembered?", false, func(m *model) tea.Cmd {
+			if err := replaceMemories(nil); err != nil {
+				m.notice = err.Error()
+				return nil
+			}
+			m.notice = "Forgot everything"
+			return nil
+		})
+	default:
+		m.notice = "Usage: /memory [add <text>|forget <id>|edit|clear]"
+	}
+	return nil
+}
+
+// saveEditedMemories stores the memories as edited in the user's editor.
+func (m *model) saveEditedMemories(msg memoryEditedMsg) {
+	defer os.Remove(msg.path)
+	if msg.err != nil {
+		m.notice = "Editor failed: " + msg.err.Error()
+		return
+	}
+	data, err := os.ReadFile(msg.path)
+	if err != nil {
+		m.notice = "Could not read the edited memories: " + err.Error()
+		return
+	}
+	var contents []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line != "" && !strings.HasPrefix(line, "#") {
+			contents = append(contents, line)
+		}
+	}
+	if err := replaceMemories(contents); err != nil {
+		m.notice = err.Error()
+		return
+	}
+	m.notice = fmt.Sprintf("%d memories saved", len(contents))
+	if len(contents) == 1 {
+		m.notice = "1 memory saved"
+	}
+}
+
+// memoryEntries lists the memories, each forgotten when picked.
+func memoryEntries(m model) []paletteEntry {
+	memories, err := loadMemories()
+	if err != nil {
+		return nil
+	}
+	entries := make([]paletteEntry, len(memories))
+	for i, mem := range memories {
+		entries[i] = paletteEntry{
+			label: fmt.Sprintf("%d. %s", mem.id, mem.content),
+			help:  "remembered " + formatTimestamp(mem.created),
+			run: func(m *model) tea.Cmd {
+				if err := deleteMemory(mem.id); err != nil {
+					m.notice = err.Error()
+					return nil
+				}
+				m.notice = fmt.Sprintf("Forgot memory %d, %s", mem.id, mem.content)
+				return nil
+			},
+		}
+	}
+	return entries
+}
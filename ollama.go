@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultOllamaHost  = "http://localhost:11434"
+	defaultOllamaModel = "llama3.2"
+)
+
+// ollamaProvider talks to a local Ollama server, which needs no API key. It
+// cannot generate several choices, so every response is choice 0.
+type ollamaProvider struct {
+	host   string
+	client *http.Client
+}
+
+// newOllamaProvider connects to the server at the profile's base URL, or else
+// the default local address. The API key is ignored.
+func newOllamaProvider(p profile) (provider, error) {
+	host := p.BaseURL
+	if host == "" {
+		host = defaultOllamaHost
+	}
+	// OLLAMA_HOST is often just host:port
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	return &ollamaProvider{host: strings.TrimSuffix(host, "/"), client: http.DefaultClient}, nil
+}
+
+// ollamaError is an error response from the Ollama API.
+type ollamaError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *ollamaError) Error() string {
+	if e.StatusCode == 0 {
+		return "ollama: " + e.Message
+	}
+	return fmt.Sprintf("ollama: %d: %s", e.StatusCode, e.Message)
+}
+
+type ollamaMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	// Images are base64 encoded.
+	Images    []string         `json:"images,omitempty"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	// ToolName names the tool whose result a message of the role "tool"
+	// gives.
+	ToolName string `json:"tool_name,omitempty"`
+	// Thinking is what a model that thinks did so before responding.
+	Thinking string `json:"thinking,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+// ollamaTool declares a tool the model may call, as in the OpenAI API.
+type ollamaTool struct {
+	Type     string `json:"type"` // always "function"
+	Function struct {
+		Name        string         `json:"name"`
+		Description string         `json:"description"`
+		Parameters  map[string]any `json:"parameters"`
+	} `json:"function"`
+}
+
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Tools    []ollamaTool    `json:"tools,omitempty"`
+	Stream   bool            `json:"stream"`
+	// Format is "json" or a JSON schema.
+	Format  any            `json:"format,omitempty"`
+	Options map[string]any `json:"options,omitempty"`
+	// Think is the reasoning effort. Models without levels of effort take
+	// any as thinking at all.
+	Think string `json:"think,omitempty"`
+}
+
+// ollamaResponse is one line of a streamed chat response.
+type ollamaResponse struct {
+	Message ollamaMessage `json:"message"`
+	Done    bool          `json:"done"`
+	Error   string        `json:"error"`
+	// The token counts are only set on the last line.
+	PromptEvalCount int64 `json:"prompt_eval_count"`
+	EvalCount       int64 `json:"eval_count"`
+}
+
+func (p *ollamaProvider) stream(ctx context.Context, req request) (<-chan chunk, error) {
+	resp, err := p.do(ctx, http.MethodPost, "/api/chat", ollamaParams(req))
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan chunk)
+	go func() {
+		defer close(ch)
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		var calls []toolCall
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			var r ollamaResponse
+			if err := json.Unmarshal(line, &r); err != nil {
+				sendChunk(ctx, ch, chunk{err: fmt.Errorf("ollama: malformed response: %w", err)})
+				return
+			}
+			if r.Error != "" {
+				sendChunk(ctx, ch, chunk{err: &ollamaError{Message: r.Error}})
+				return
+			}
+			if r.Message.Thinking != "" && !sendChunk(ctx, ch, chunk{reasoning: r.Message.Thinking}) {
+				return
+			}
+			if r.Message.Content != "" && !sendChunk(ctx, ch, chunk{content: r.Message.Content}) {
+				return
+			}
+			// Calls come whole, without ids
+			for _, call := range r.Message.ToolCalls {
+				calls = append(calls, toolCall{id: newCallID(), name: call.Function.Name, arguments: string(call.Function.Arguments)})
+			}
+			if r.Done {
+				if len(calls) > 0 && !sendChunk(ctx, ch, chunk{toolCalls: calls}) {
+					return
+				}
+				sendChunk(ctx, ch, chunk{usage: &usage{prompt: r.PromptEvalCount, completion: r.EvalCount}})
+				return
+			}
+		}
+		err := scanner.Err()
+		if err == nil {
+			// The connection closed before the response was complete
+			err = io.ErrUnexpectedEOF
+		}
+		sendChunk(ctx, ch, chunk{err: err})
+	}()
+	return ch, nil
+}
+
+// models lists the models pulled to the server.
+func (p *ollamaProvider) models(ctx context.Context) ([]string, error) {
+	resp, err := p.do(ctx, http.MethodGet, "/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, fmt.Errorf("ollama: malformed model list: %w", err)
+	}
+	names := make([]string, len(tags.Models))
+	for i, m := range tags.Models {
+		names[i] = m.Name
+	}
+	return names, nil
+}
+
+func (p *ollamaProvider) embed(ctx context.Context, model string, texts []string) ([][]float32, error) {
+	resp, err := p.do(ctx, http.MethodPost, "/api/embed", map[string]any{"model": model, "input": texts})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var embedded struct {
+		Embeddings [][]float32 `json:"embeddings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&embedded); err != nil {
+		return nil, fmt.Errorf("ollama: malformed embeddings: %w", err)
+	}
+	if len(embedded.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("ollama: %d embeddings for %d texts", len(embedded.Embeddings), len(texts))
+	}
+	return embedded.Embeddings, nil
+}
+
+// do sends a request to the server, with body encoded as JSON unless it is
+// nil, and returns the response if it succeeded.
+func (p *ollamaProvider) do(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(data)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, p.host+path, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		apiErr := &ollamaError{StatusCode: resp.StatusCode, Message: resp.Status}
+		var e struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&e); err == nil && e.Error != "" {
+			apiErr.Message = e.Error
+		}
+		return nil, apiErr
+	}
+	return resp, nil
+}
+
+// ollamaParams translates a request into the chat API's format. Generation
+// settings go in its options.
+func ollamaParams(req request) ollamaRequest {
+	params := ollamaRequest{
+		Model:    req.model,
+		Messages: make([]ollamaMessage, len(req.messages)),
+		Stream:   true,
+		Think:    req.reasoningEffort,
+	}
+	for i, msg := range req.messages {
+		params.Messages[i] = ollamaMessage{Role: msg.role, Content: msg.content, ToolName: msg.toolName}
+		for _, img := range msg.images {
+			params.Messages[i].Images = append(params.Messages[i].Images, img.base64())
+		}
+		for _, call := range msg.toolCalls {
+			var c ollamaToolCall
+			c.Function.Name = call.name
+			c.Function.Arguments = json.RawMessage(call.arguments)
+			if !json.Valid(c.Function.Arguments) {
+				c.Function.Arguments = json.RawMessage("{}")
+			}
+			params.Messages[i].ToolCalls = append(params.Messages[i].ToolCalls, c)
+		}
+	}
+	for _, t := range req.tools {
+		tool := ollamaTool{Type: "function"}
+		tool.Function.Name, tool.Function.Description, tool.Function.Parameters = t.name, t.description, t.parameters
+		params.Tools = append(params.Tools, tool)
+	}
+	if req.json {
+		if req.schema != nil {
+			params.Format = req.schema.schema
+		} else {
+			params.Format = "json"
+		}
+	}
+	options := map[string]any{}
+	if len(req.stop) > 0 {
+		options["stop"] = req.stop
+	}
+	if req.temperature != nil {
+		options["temperature"] = *req.temperature
+	}
+	if req.topP != nil {
+		options["top_p"] = *req.topP
+	}
+	if req.maxTokens != nil {
+		options["num_predict"] = *req.maxTokens
+	}
+	if req.presencePenalty != nil {
+		options["presence_penalty"] = *req.presencePenalty
+	}
+	if req.frequencyPenalty != nil {
+		options["frequency_penalty"] = *req.frequencyPenalty
+	}
+	if req.seed != nil {
+		options["seed"] = *req.seed
+	}
+	if len(options) > 0 {
+		params.Options = options
+	}
+	return params
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"github.com/openai/openai-go/shared"
+)
+
+// defaultAzureAPIVersion is the Azure OpenAI API version used unless another
+// is configured.
+const defaultAzureAPIVersion = "2024-10-21"
+
+// openaiProvider talks to the OpenAI chat completions API, or any server
+// compatible with it, including Azure OpenAI.
+type openaiProvider struct {
+	client openai.Client
+	// azureEndpoint is set for Azure OpenAI, where each model is a
+	// deployment with its own path under the endpoint.
+	azureEndpoint string
+}
+
+func newOpenAIProvider(p profile) (provider, error) {
+	var opts []option.RequestOption
+	if p.APIKey != "" {
+		opts = append(opts, option.WithAPIKey(p.APIKey))
+	}
+	if p.BaseURL != "" {
+		opts = append(opts, option.WithBaseURL(p.BaseURL))
+	}
+	return &openaiProvider{client: newOpenAIClient(opts...)}, nil
+}
+
+// newOpenAIClient builds a client with only the options given.
+// openai.NewClient starts from OPENAI_API_KEY, OPENAI_BASE_URL and the like,
+// which would send the OpenAI key to whatever server a profile names, and
+// let the environment move a profile to another server.
+func newOpenAIClient(opts ...option.RequestOption) openai.Client {
+	opts = append([]option.RequestOption{option.WithEnvironmentProduction()}, opts...)
+	return openai.Client{
+		Options:     opts,
+		Chat:        openai.NewChatService(opts...),
+		Embeddings:  openai.NewEmbeddingService(opts...),
+		Models:      openai.NewModelService(opts...),
+		Completions: openai.NewCompletionService(opts...),
+	}
+}
+
+// newAzureProvider connects to an Azure OpenAI resource at the profile's base
+// URL, such as https://example.openai.azure.com. The API version is the
+// profile's, or else AZURE_OPENAI_API_VERSION or defaultAzureAPIVersion.
+func newAzureProvider(p profile) (provider, error) {
+	endpoint := p.BaseURL
+	if endpoint == "" {
+		return nil, errUsage{errors.New("AZURE_OPENAI_ENDPOINT not found in environment or .env file")}
+	}
+	version := p.APIVersion
+	if version == "" {
+		version = os.Getenv("AZURE_OPENAI_API_VERSION")
+	}
+	if version == "" {
+		version = defaultAzureAPIVersion
+	}
+	client := newOpenAIClient(
+		// Azure expects the key in its own header rather than as a bearer
+		// token
+		option.WithHeader("Api-Key", p.APIKey),
+		option.WithQuery("api-version", version),
+	)
+	return &openaiProvider{client: client, azureEndpoint: strings.TrimSuffix(endpoint, "/")}, nil
+}
+
+func (p *openaiProvider) stream(ctx context.Context, req request) (<-chan chunk, error) {
+	params := openaiParams(req)
+	opts := p.deploymentOptions(req.model)
+	ch := make(chan chunk)
+	go func() {
+		defer close(ch)
+		stream := p.client.Chat.Completions.NewStreaming(ctx, params, opts...)
+		defer stream.Close()
+		// Tool calls arrive in pieces, the name first and the arguments
+		// after, indexed by call
+		var calls []toolCall
+		for stream.Next() {
+			current := stream.Current()
+			// Usage comes in a final chunk of its own
+			if u := current.Usage; u.PromptTokens > 0 || u.CompletionTokens > 0 {
+				if !sendChunk(ctx, ch, chunk{usage: &usage{prompt: u.PromptTokens, completion: u.CompletionTokens}}) {
+					return
+				}
+			}
+			for _, choice := range current.Choices {
+				for _, delta := range choice.Delta.ToolCalls {
+					for int(delta.Index) >= len(calls) {
+						calls = append(calls, toolCall{})
+					}
+					call := &calls[delta.Index]
+					call.id += delta.ID
+					call.name += delta.Function.Name
+					call.arguments += delta.Function.Arguments
+				}
+				if thinking := deltaReasoning(choice.Delta); thinking != "" {
+					if !sendChunk(ctx, ch, chunk{choice: int(choice.Index), reasoning: thinking}) {
+						return
+					}
+				}
+				if choice.Delta.Content == "" {
+					continue
+				}
+				if !sendChunk(ctx, ch, chunk{choice: int(choice.Index), content: choice.Delta.Content}) {
+					return
+				}
+			}
+		}
+		if err := stream.Err(); err != nil {
+			sendChunk(ctx, ch, chunk{err: err})
+			return
+		}
+		if len(calls) > 0 {
+			sendChunk(ctx, ch, chunk{toolCalls: calls})
+		}
+	}()
+	return ch, nil
+}
+
+// openaiParams translates a request into chat completion parameters.
+func openaiParams(req request) openai.ChatCompletionNewParams {
+	messages := make([]openai.ChatCompletionMessageParamUnion, 0, len(req.messages)+1)
+	if req.json && req.schema == nil {
+		// The API rejects JSON object mode unless the conversation asks for
+		// JSON
+		messages = append(messages, openai.SystemMessage(jsonModePrompt))
+	}
+	for _, msg := range req.messages {
+		switch msg.role {
+		case "system":
+			messages = append(messages, openai.SystemMessage(msg.content))
+		case "user":
+			if len(msg.images) == 0 {
+				messages = append(messages, openai.UserMessage(msg.content))
+				break
+			}
+			parts := []openai.ChatCompletionContentPartUnionParam{openai.TextContentPart(msg.content)}
+			for _, img := range msg.images {
+				parts = append(parts, openai.ImageContentPart(openai.ChatCompletionContentPartImageImageURLParam{URL: img.dataURL()}))
+			}
+			messages = append(messages, openai.UserMessage(parts))
+		case "tool":
+			messages = append(messages, openai.ToolMessage(msg.content, msg.toolCallID))
+		default:
+			if len(msg.toolCalls) == 0 {
+				messages = append(messages, openai.AssistantMessage(msg.content))
+				break
+			}
+			assistant := openai.ChatCompletionAssistantMessageParam{}
+			if msg.content != "" {
+				assistant.Content.OfString = openai.String(msg.content)
+			}
+			for _, call := range msg.toolCalls {
+				assistant.ToolCalls = append(assistant.ToolCalls, openai.ChatCompletionMessageToolCallParam{
+					ID:       call.id,
+					Function: openai.ChatCompletionMessageToolCallFunctionParam{Name: call.name, Arguments: call.arguments},
+				})
+			}
+			messages = append(messages, openai.ChatCompletionMessageParamUnion{OfAssistant: &assistant})
+		}
+	}
+
+	params := openai.ChatCompletionNewParams{
+		Messages: messages,
+		Model:    openai.ChatModel(req.model),
+		StreamOptions: openai.ChatCompletionStreamOptionsParam{
+			IncludeUsage: openai.Bool(true),
+		},
+	}
+	if req.choices > 1 {
+		params.N = openai.Int(int64(req.choices))
+	}
+	for _, t := range req.tools {
+		params.Tools = append(params.Tools, openai.ChatCompletionToolParam{
+			Function: openai.FunctionDefinitionParam{
+				Name:        t.name,
+				Description: openai.String(t.description),
+				Parameters:  openai.FunctionParameters(t.parameters),
+			},
+		})
+	}
+	if len(req.stop) > 0 {
+		params.Stop = openai.ChatCompletionNewParamsStopUnion{OfStringArray: req.stop}
+	}
+	if req.temperature != nil {
+		params.Temperature = openai.Float(*req.temperature)
+	}
+	if req.topP != nil {
+		params.TopP = openai.Float(*req.topP)
+	}
+	if req.maxTokens != nil {
+		params.MaxCompletionTokens = openai.Int(*req.maxTokens)
+	}
+	if req.presencePenalty != nil {
+		params.PresencePenalty = openai.Float(*req.presencePenalty)
+	}
+	if req.frequencyPenalty != nil {
+		params.FrequencyPenalty = openai.Float(*req.frequencyPenalty)
+	}
+	if req.seed != nil {
+		params.Seed = openai.Int(*req.seed)
+	}
+	if req.reasoningEffort != "" {
+		params.ReasoningEffort = shared.ReasoningEffort(req.reasoningEffort)
+	}
+	if req.json {
+		if req.schema != nil {
+			params.ResponseFormat.OfJSONSchema = &openai.ResponseFormatJSONSchemaParam{
+				JSONSchema: openai.ResponseFormatJSONSchemaJSONSchemaParam{
+					Name:   req.schema.name,
+					Schema: req.schema.schema,
+					Strict: openai.Bool(true),
+				},
+			}
+		} else {
+			params.ResponseFormat.OfJSONObject = &openai.ResponseFormatJSONObjectParam{}
+		}
+	}
+	return params
+}
+
+// deltaReasoning returns the thinking in a delta. OpenAI keeps its models'
+// thinking to itself, but compatible servers send that of models like DeepSeek
+// R1 as reasoning_content, or reasoning.
+func deltaReasoning(delta openai.ChatCompletionChunkChoiceDelta) string {
+	for _, name := range []string{"reasoning_content", "reasoning"} {
+		var s string
+		if field, ok := delta.JSON.ExtraFields[name]; ok && json.Unmarshal([]byte(field.Raw()), &s) == nil && s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// deploymentOptions points a request for model at its deployment on Azure.
+func (p *openaiProvider) deploymentOptions(model string) []option.RequestOption {
+	if p.azureEndpoint == "" {
+		return nil
+	}
+	return []option.RequestOption{option.WithBaseURL(p.azureEndpoint + "/openai/deployments/" + url.PathEscape(model) + "/")}
+}
+
+func (p *openaiProvider) embed(ctx context.Context, model string, texts []string) ([][]float32, error) {
+	params := openai.EmbeddingNewParams{
+		Input: openai.EmbeddingNewParamsInputUnion{OfArrayOfStrings: texts},
+		Model: openai.EmbeddingModel(model),
+	}
+	resp, err := p.client.Embeddings.New(ctx, params, p.deploymentOptions(model)...)
+	if err != nil {
+		return nil, err
+	}
+	vectors := make([][]float32, len(texts))
+	for _, d := range resp.Data {
+		if d.Index < 0 || int(d.Index) >= len(vectors) {
+			continue
+		}
+		v := make([]float32, len(d.Embedding))
+		for i, f := range d.Embedding {
+			v[i] = float32(f)
+		}
+		vectors[d.Index] = v
+	}
+	if slices.ContainsFunc(vectors, func(v []float32) bool { return v == nil }) {
+		return nil, errors.New("openai: embeddings missing from the response")
+	}
+	return vectors, nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// palette is a fuzzy-searchable list of actions: the command palette, with
+// every command and key binding, or a picker such as the model picker.
+type palette struct {
+	query  string
+	cursor int
+	// entries lists what can be picked. It is called as the list is shown,
+	// so entries can arrive while it is open.
+	entries func(m model) []paletteEntry
+	// fallback, if set, runs with the query when Enter is pressed and nothing
+	// matches.
+	fallback  func(m *model, query string) tea.Cmd
+	noMatches string
+	verb      string // what Enter does, for the help line
+	// search is set when entries are looked up with the query, so they are
+	// listed as they are rather than filtered by it.
+	search bool
+}
+
+// paletteEntry is an action listed in the palette.
+type paletteEntry struct {
+	label string
+	help  string
+	run   func(m *model) tea.Cmd
+}
+
+// paletteEntries builds the palette contents from the command and key
+// registries, so new commands show up without further work.
+func paletteEntries(m model) []paletteEntry {
+	var entries []paletteEntry
+	for _, c := range commands {
+		entries = append(entries, paletteEntry{label: c.usage, help: c.help, run: c.palette})
+	}
+	for _, b := range keyBindings {
+		if b.run == nil {
+			continue
+		}
+		entries = append(entries, paletteEntry{label: b.label(), help: b.help, run: b.run})
+	}
+	return entries
+}
+
+// palette runs a command picked from the palette. Commands that need
+// arguments are put in the input to be completed instead.
+func (c command) palette(m *model) tea.Cmd {
+	if strings.Contains(c.usage, "<") {
+		m.setInput("/" + c.name + " ")
+		return nil
+	}
+	return c.run(m, "")
+}
+
+// matches returns the entries matching the query, best match first.
+func (p *palette) matches(m model) []paletteEntry {
+	type scored struct {
+		entry paletteEntry
+		score int
+	}
+	if p.search {
+		return p.entries(m)
+	}
+	var results []scored
+	for _, e := range p.entries(m) {
+		if score, ok := fuzzyMatch(p.query, e.label+" "+e.help); ok {
+			results = append(results, scored{e, score})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].score > results[j].score })
+
+	entries := make([]paletteEntry, len(results))
+	for i, r := range results {
+		entries[i] = r.entry
+	}
+	return entries
+}
+
+// fuzzyMatch reports whether the characters of query appear in text in order,
+// ignoring case. Matches with consecutive characters and matches starting
+// words score higher.
+func fuzzyMatch(query, text string) (score int, ok bool) {
+	q := []rune(strings.ToLower(query))
+	if len(q) == 0 {
+		return 0, true
+	}
+	t := []rune(strings.ToLower(text))
+	qi, prev := 0, -2
+	for ti := 0; ti < len(t) && qi < len(q); ti++ {
+		if t[ti] != q[qi] {
+			continue
+		}
+		score++
+		if ti == prev+1 {
+			score += 2
+		}
+		if ti == 0 || !unicode.IsLetter(t[ti-1]) {
+			score += 3
+		}
+		prev = ti
+		qi++
+	}
+	return score, qi == len(q)
+}
+
+func openPalette(m *model) tea.Cmd {
+	m.palette = &palette{entries: paletteEntries, noMatches: "No matching commands", verb: "run"}
+	return nil
+}
+
+// handlePaletteKey edits the search, moves the cursor, or runs the entry
+// under the cursor.
+func (m *model) handlePaletteKey(msg tea.KeyMsg) tea.Cmd {
+	p := m.palette
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.palette = nil
+	case tea.KeyEnter:
+		matches := p.matches(*m)
+		m.palette = nil
+		if p.cursor < len(matches) {
+			return matches[p.cursor].run(m)
+		}
+		if p.fallback != nil && strings.TrimSpace(p.query) != "" {
+			return p.fallback(m, strings.TrimSpace(p.query))
+		}
+	case tea.KeyUp, tea.KeyCtrlP:
+		p.cursor = max(p.cursor-1, 0)
+	case tea.KeyDown, tea.KeyCtrlN:
+		p.cursor = min(p.cursor+1, max(len(p.matches(*m))-1, 0))
+	case tea.KeyBackspace:
+		if r := []rune(p.query); len(r) > 0 {
+			p.query = string(r[:len(r)-1])
+			p.cursor = 0
+		}
+	case tea.KeySpace:
+		p.query += " "
+		p.cursor = 0
+	case tea.KeyRunes:
+		p.query += string(msg.Runes)
+		p.cursor = 0
+	}
+	return nil
+}
+
+func (m model) paletteView() string {
+	var b strings.Builder
+	b.WriteString(inputStyle.Render("> ") + m.palette.query + inputStyle.Render("█") + "\n\n")
+	matches := m.palette.matches(m)
+	if len(matches) == 0 {
+		b.WriteString(helpStyle.Render(m.palette.noMatches) + "\n")
+	}
+	// Scroll the list to keep the cursor in sight, leaving room for the
+	// query and help lines
+	start, end := 0, len(matches)
+	if m.height > 0 {
+		rows := max(m.bodyRows(m.headerView(), m.footerView())-4, 1)
+		start = max(m.palette.cursor-rows+1, 0)
+		end = min(start+rows, len(matches))
+	}
+	for i := start; i < end; i++ {
+		e := matches[i]
+		line := e.label
+		if e.help != "" {
+			line += "  " + helpStyle.Render(e.help)
+		}
+		if i == m.palette.cursor {
+			line = selectedStyle.Render(line)
+		} else {
+			line = "  " + line
+		}
+		b.WriteString(line + "\n")
+	}
+	b.WriteString("\n" + helpStyle.Render("Type to search, Up/Down to move, Enter to "+m.palette.verb+", Esc to close") + "\n\n")
+	return b.String()
+}
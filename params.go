@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxStopSequences is the most stop sequences the API accepts.
+const maxStopSequences = 4
+
+// parseStopSequences parses a space separated list of stop sequences. A
+// sequence may be double quoted to include spaces or escapes such as "\n".
+func parseStopSequences(s string) ([]string, error) {
+	var stops []string
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		var stop string
+		if s[0] == '"' {
+			end := closingQuote(s)
+			if end < 0 {
+				return nil, errors.New("unterminated quoted stop sequence")
+			}
+			var err error
+			if stop, err = strconv.Unquote(s[:end+1]); err != nil {
+				return nil, fmt.Errorf("invalid stop sequence %s", s[:end+1])
+			}
+			s = s[end+1:]
+		} else {
+			stop, s, _ = strings.Cut(s, " ")
+		}
+		if stop == "" {
+			return nil, errors.New("stop sequences cannot be empty")
+		}
+		stops = append(stops, stop)
+	}
+	if len(stops) > maxStopSequences {
+		return nil, fmt.Errorf("at most %d stop sequences are allowed", maxStopSequences)
+	}
+	return stops, nil
+}
+
+// closingQuote returns the index of the quote closing the string that s
+// starts with, or -1 if there is none.
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// formatStopSequences renders stop sequences quoted, so whitespace is visible.
+func formatStopSequences(stops []string) string {
+	quoted := make([]string, len(stops))
+	for i, s := range stops {
+		quoted[i] = strconv.Quote(s)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// jsonModePrompt is added to requests in JSON mode, since the API rejects
+// JSON object mode unless the conversation asks for JSON.
+const jsonModePrompt = "Respond with valid JSON."
+
+// jsonModeModels are the model name prefixes known to support JSON mode.
+var jsonModeModels = []string{"gpt-4o", "gpt-4.1", "gpt-4-turbo", "gpt-3.5-turbo", "o1", "o3", "o4"}
+
+// supportsJSONMode reports whether modelName is known to support JSON mode.
+func supportsJSONMode(modelName string) bool {
+	for _, prefix := range jsonModeModels {
+		if strings.HasPrefix(modelName, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonSchema is a JSON schema used for structured outputs.
+type jsonSchema struct {
+	name   string
+	schema map[string]any
+}
+
+// loadJSONSchema reads a JSON schema from a file, naming it after the file.
+func loadJSONSchema(path string) (*jsonSchema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("load schema: %w", err)
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("load schema %s: %w", path, err)
+	}
+
+	// Schema names may only contain letters, digits, underscores and dashes
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	name := strings.Map(func(r rune) rune {
+		if r == '_' || r == '-' || r < 128 && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, base)
+	if name == "" {
+		name = "response"
+	}
+	return &jsonSchema{name: name[:min(len(name), 64)], schema: schema}, nil
+}
+
+// prettyJSON indents content if it is valid JSON. ok is false otherwise.
+func prettyJSON(content string) (pretty string, ok bool) {
+	var b bytes.Buffer
+	if err := json.Indent(&b, []byte(strings.TrimSpace(content)), "", "  "); err != nil {
+		return content, false
+	}
+	return b.String(), true
+}
+
+// parsePenalty parses a presence or frequency penalty, which the API accepts
+// from -2.0 to 2.0.
+func parsePenalty(s string) (float64, error) {
+	p, err := strconv.ParseFloat(s, 64)
+	if err != nil || p < -2 || p > 2 {
+		return 0, fmt.Errorf("penalty must be a number from -2.0 to 2.0, got %q", s)
+	}
+	return p, nil
+}
+
+// formatPenalty renders an optional penalty, or other optional number, "off"
+// when unset.
+func formatPenalty(p *float64) string {
+	if p == nil {
+		return "off"
+	}
+	return strconv.FormatFloat(*p, 'f', -1, 64)
+}
+
+// samplingSetting is a generation setting that /set shows and changes. Each
+// can be given in the config file or, overriding it, in the environment.
+type samplingSetting struct {
+	name string // as in the config file and /set
+	env  string
+	// Numbers are kept in float or, for whole numbers, int. Floats must be
+	// from min to max, and ints positive if positive is set.
+	float    func(c *config) **float64
+	min, max float64
+	int      func(c *config) **int64
+	positive bool
+	// Words are kept in text, and must be one of options.
+	text    func(c *config) *string
+	options []string
+}
+
+var samplingSettings = []samplingSetting{
+	{name: "temperature", env: "LLMTUI_TEMPERATURE", float: func(c *config) **float64 { return &c.Temperature }, min: 0, max: 2},
+	{name: "top_p", env: "LLMTUI_TOP_P", float: func(c *config) **float64 { return &c.TopP }, min: 0, max: 1},
+	{name: "max_tokens", env: "LLMTUI_MAX_TOKENS", int: func(c *config) **int64 { return &c.MaxTokens }, positive: true},
+	{name: "presence_penalty", env: "LLMTUI_PRESENCE_PENALTY", float: func(c *config) **float64 { return &c.PresencePenalty }, min: -2, max: 2},
+	{name: "frequency_penalty", env: "LLMTUI_FREQUENCY_PENALTY", float: func(c *config) **float64 { return &c.FrequencyPenalty }, min: -2, max: 2},
+	{name: "seed", env: "LLMTUI_SEED", int: func(c *config) **int64 { return &c.Seed }},
+	{name: "reasoning_effort", env: "LLMTUI_REASONING_EFFORT", text: func(c *config) *string { return &c.ReasoningEffort }, options: reasoningEfforts},
+}
+
+func findSamplingSetting(name string) (samplingSetting, bool) {
+	for _, s := range samplingSettings {
+		if s.name == name {
+			return s, true
+		}
+	}
+	return samplingSetting{}, false
+}
+
+// set parses value into the setting, or clears it when value is "off".
+func (s samplingSetting) set(c *config, value string) error {
+	if s.text != nil {
+		if value == "off" {
+			*s.text(c) = ""
+			return nil
+		}
+		if !slices.Contains(s.options, value) {
+			return fmt.Errorf("%s must be one of %s, got %q", s.name, strings.Join(s.options, ", "), value)
+		}
+		*s.text(c) = value
+		return nil
+	}
+	if s.float != nil {
+		if value == "off" {
+			*s.float(c) = nil
+			return nil
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || f < s.min || f > s.max {
+			return fmt.Errorf("%s must be a number from %g to %g, got %q", s.name, s.min, s.max, value)
+		}
+		*s.float(c) = &f
+		return nil
+	}
+	if value == "off" {
+		*s.int(c) = nil
+		return nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || s.positive && n <= 0 {
+		kind := "a whole number"
+		if s.positive {
+			kind = "a positive whole number"
+		}
+		return fmt.Errorf("%s must be %s, got %q", s.name, kind, value)
+	}
+	*s.int(c) = &n
+	return nil
+}
+
+// get formats the setting's value, "off" when unset.
+func (s samplingSetting) get(c config) string {
+	if s.text != nil {
+		return cmp.Or(*s.text(&c), "off")
+	}
+	if s.float != nil {
+		return formatPenalty(*s.float(&c))
+	}
+	if n := *s.int(&c); n != nil {
+		return strconv.FormatInt(*n, 10)
+	}
+	return "off"
+}
+
+// check validates a value read from the config file.
+func (s samplingSetting) check(c config) error {
+	if value := s.get(c); value != "off" {
+		if err := s.set(&c, value); err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+	}
+	return nil
+}
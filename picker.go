@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// sessionPicker is the full-screen list of saved sessions, shown at startup
+// and with ctrl+o, to open, rename or delete them.
+type sessionPicker struct {
+	entries   []sessionSummary
+	cursor    int
+	offset    int
+	query     string // text the listed sessions contain, if searching
+	searching bool   // typing goes to the query
+}
+
+func openSessionPicker(m *model) tea.Cmd {
+	p, err := newSessionPicker()
+	if err != nil {
+		m.notice = err.Error()
+		return nil
+	}
+	m.sessions = p
+	return nil
+}
+
+// pickSessionOnStart opens the picker at startup, unless there are no
+// sessions to pick from or it is disabled.
+func (m *model) pickSessionOnStart() {
+	if m.config.DisableSessionPicker {
+		return
+	}
+	if p, err := newSessionPicker(); err == nil && len(p.entries) > 0 {
+		m.sessions = p
+	}
+}
+
+// newSessionPicker lists the saved sessions, most recent first.
+func newSessionPicker() (*sessionPicker, error) {
+	entries, err := listSessionSummaries("")
+	if err != nil {
+		return nil, err
+	}
+	return &sessionPicker{entries: entries}, nil
+}
+
+// selected returns the entry under the cursor, if there is one.
+func (p *sessionPicker) selected() (sessionSummary, bool) {
+	if p.cursor >= len(p.entries) {
+		return sessionSummary{}, false
+	}
+	return p.entries[p.cursor], true
+}
+
+// pickerRows is how many sessions fit on screen below the title, and search
+// line if any, and above the footer.
+func (m model) pickerRows() int {
+	p := m.sessions
+	if m.height <= 0 {
+		return len(p.entries)
+	}
+	rows := m.height - 6
+	if p.searching || p.query != "" {
+		rows -= 2
+	}
+	return max(rows, 1)
+}
+
+// search lists the sessions containing the query again.
+func (p *sessionPicker) search() error {
+	entries, err := listSessionSummaries(p.query)
+	if err != nil {
+		return err
+	}
+	p.entries, p.cursor, p.offset = entries, 0, 0
+	return nil
+}
+
+// handleSearchKey edits the query, listing the matching sessions as it
+// changes.
+func (m *model) handleSearchKey(msg tea.KeyMsg) {
+	p := m.sessions
+	switch msg.Type {
+	case tea.KeyEnter:
+		p.searching = false
+		return
+	case tea.KeyEsc:
+		p.searching = false
+		p.query = ""
+	case tea.KeyBackspace:
+		r := []rune(p.query)
+		if len(r) == 0 {
+			return
+		}
+		p.query = string(r[:len(r)-1])
+	case tea.KeySpace:
+		p.query += " "
+	case tea.KeyRunes:
+		p.query += string(msg.Runes)
+	default:
+		return
+	}
+	if err := p.search(); err != nil {
+		m.notice = err.Error()
+	}
+}
+
+// handlePickerKey moves through the sessions or acts on the one under the
+// cursor.
+func (m *model) handlePickerKey(msg tea.KeyMsg) tea.Cmd {
+	p := m.sessions
+	if p.searching {
+		m.handleSearchKey(msg)
+		return nil
+	}
+	switch msg.String() {
+	case "/":
+		p.searching = true
+	case "esc", "q":
+		m.sessions = nil
+	case "up", "k":
+		p.cursor = max(p.cursor-1, 0)
+	case "down", "j":
+		p.cursor = min(p.cursor+1, max(len(p.entries)-1, 0))
+	case "pgup":
+		p.cursor = max(p.cursor-m.pickerRows(), 0)
+	case "pgdown":
+		p.cursor = min(p.cursor+m.pickerRows(), max(len(p.entries)-1, 0))
+	case "enter":
+		if e, ok := p.selected(); ok {
+			return m.openSession(e)
+		}
+	case "r":
+		if e, ok := p.selected(); ok {
+			m.prompt = &textPrompt{label: "Rename to: ", value: e.name, onSubmit: func(m *model, name string) tea.Cmd {
+				m.renameSession(e.name, name)
+				return nil
+			}}
+		}
+	case "d", "delete":
+		if e, ok := p.selected(); ok {
+			return m.confirmAction(fmt.Sprintf("Delete session %s?", e.name), false, func(m *model) tea.Cmd {
+				m.deleteSession(e.name)
+				return nil
+			})
+		}
+	}
+	// Keep the cursor on screen
+	p.offset = min(p.offset, p.cursor)
+	p.offset = max(p.offset, p.cursor-m.pickerRows()+1)
+	return nil
+}
+
+// openSession closes the picker and opens the session: in the tab it is
+// already open in, in place of an empty conversation, or else in a new tab.
+func (m *model) openSession(e sessionSummary) tea.Cmd {
+	s, err := loadSession(e.name)
+	if err != nil {
+		m.notice = err.Error()
+		return nil
+	}
+	for i, c := range m.tabs {
+		if c.sessionName == e.name {
+			m.sessions = nil
+			m.switchTab(i)
+			return nil
+		}
+	}
+	if len(m.messages) > 0 || m.loading {
+		if len(m.tabs) >= maxTabs {
+			m.notice = fmt.Sprintf("At most %d tabs can be open, close one first", maxTabs)
+			return nil
+		}
+		m.openTab()
+	}
+	m.sessions = nil
+	m.restoreSession(e.name, s)
+	m.notice = "Opened session " + e.name
+	return nil
+}
+
+// renameSession renames a saved session, and any open conversation saved as
+// it along with it.
+func (m *model) renameSession(from, to string) {
+	if to == from {
+		return
+	}
+	if err := renameStoredSession(from, to); err != nil {
+		m.notice = err.Error()
+		return
+	}
+	for _, c := range m.tabs {
+		if c.sessionName == from {
+			c.sessionName = to
+		}
+	}
+	if p := m.sessions; p != nil {
+		for i := range p.entries {
+			if p.entries[i].name == from {
+				p.entries[i].name = to
+			}
+		}
+	}
+	m.notice = fmt.Sprintf("Renamed session %s to %s", from, to)
+}
+
+// deleteSession deletes a saved session. An open conversation saved as it
+// is kept, and saved as a new session after its next turn.
+func (m *model) deleteSession(name string) {
+	if err := deleteStoredSession(name); err != nil {
+		m.notice = err.Error()
+		return
+	}
+	for _, c := range m.tabs {
+		if c.sessionName == name {
+			c.sessionName = ""
+		}
+	}
+	if p := m.sessions; p != nil {
+		for i, e := range p.entries {
+			if e.name == name {
+				p.entries = append(p.entries[:i], p.entries[i+1:]...)
+				break
+			}
+		}
+		p.cursor = min(p.cursor, max(len(p.entries)-1, 0))
+	}
+	m.notice = "Deleted session " + name
+}
+
+// pickerLine describes a session: its title, or name when untitled, when it
+// was last saved, its model and how many messages it has.
+func pickerLine(e sessionSummary, width int) string {
+	title := e.title
+	if title == "" {
+		title = e.name
+	}
+	details := fmt.Sprintf("%d messages", e.messages)
+	if e.messages == 1 {
+		details = "1 message"
+	}
+	if e.model != "" {
+		details = e.model + "  " + details
+	}
+	if !e.saved.IsZero() {
+		details = e.saved.Local().Format("2006-01-02 15:04") + "  " + details
+	}
+	if width > 0 {
+		title = ansi.Truncate(title, max(width-len(details)-6, 10), "…")
+	}
+	return title + "  " + helpStyle.Render(details)
+}
+
+func (m model) pickerView() string {
+	p := m.sessions
+	var b strings.Builder
+	b.WriteString(titleStyle.Render("Sessions") + "\n\n")
+	if p.searching || p.query != "" {
+		cursor := ""
+		if p.searching {
+			cursor = inputStyle.Render("█")
+		}
+		b.WriteString(inputStyle.Render("/") + p.query + cursor + "\n\n")
+	}
+	if len(p.entries) == 0 && p.query != "" {
+		b.WriteString(helpStyle.Render("No sessions contain "+p.query) + "\n")
+	} else if len(p.entries) == 0 {
+		b.WriteString(helpStyle.Render("No saved sessions") + "\n")
+	}
+	end := min(p.offset+m.pickerRows(), len(p.entries))
+	for i := p.offset; i < end; i++ {
+		line := pickerLine(p.entries[i], m.width)
+		if i == p.cursor {
+			line = selectedStyle.Render(line)
+		} else {
+			line = "  " + line
+		}
+		b.WriteString(line + "\n")
+	}
+	b.WriteString("\n")
+	switch {
+	case m.prompt != nil:
+		b.WriteString(m.prompt.render())
+	case m.confirm != nil:
+		b.WriteString(inputStyle.Render(m.confirm.prompt + " (y)es / (n)o"))
+	case m.notice != "":
+		b.WriteString(helpStyle.Render(m.notice))
+	case p.searching:
+		b.WriteString(helpStyle.Render("Type to search titles and messages, Enter to finish, Esc to clear"))
+	default:
+		b.WriteString(helpStyle.Render("Up/Down to move, Enter to open, / to search, r to rename, d to delete, Esc to close"))
+	}
+	return b.String()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Pipe mode answers a single prompt without the TUI, as in
+//
+//	echo "explain this error" | llmtui -p "be terse"
+//	llmtui "one-shot question"
+//
+// The prompt is the arguments followed by whatever is piped in, and the
+// answer streams to stdout so llmtui composes with other commands.
+
+// stdinPiped reports whether stdin is a pipe or file rather than a terminal.
+func stdinPiped() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice == 0
+}
+
+// pipePrompt joins the question given as arguments with the text piped in,
+// which usually is what the question is about.
+func pipePrompt(args []string, stdin io.Reader) (string, error) {
+	prompt := strings.TrimSpace(strings.Join(args, " "))
+	if stdin != nil {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return "", fmt.Errorf("read stdin: %w", err)
+		}
+		if piped := strings.TrimSpace(string(data)); piped != "" {
+			if prompt != "" {
+				prompt += "\n\n"
+			}
+			prompt += piped
+		}
+	}
+	if prompt == "" {
+		return "", errUsage{errors.New("nothing to ask, give a prompt as arguments or on stdin")}
+	}
+	return prompt, nil
+}
+
+// runPipe sends prompt to the model m is set up with and streams the answer
+// to w. Tools are not offered, since there is nobody to approve them, and
+// the budget is not enforced, since there is nobody to ask.
+func runPipe(m model, prompt string, w io.Writer) error {
+	if m.err != nil {
+		return m.err
+	}
+	if m.setup != nil {
+		return errUsage{errors.New("no provider is set up yet, run llmtui once to set one up")}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// A session given with --session is continued, and saved with the answer
+	history := append(slices.Clone(m.messages), chatMessage{role: "user", content: prompt, sent: time.Now()})
+	req, _ := m.newRequest(history, m.modelName)
+	req.choices = 1
+	req.tools = nil
+	if !m.config.RAG.Disabled {
+		ragCtx, cancel := context.WithTimeout(ctx, ragTimeout)
+		// Retrieval failing should not stop the answer, as in the TUI
+		if _, err := m.config.RAG.augment(ragCtx, &req); err != nil {
+			fmt.Fprintln(os.Stderr, "llmtui: retrieval failed:", err)
+		}
+		cancel()
+	}
+
+	streamChan := make(chan streamEvent, 100)
+	go startStreamingInBackground(ctx, streamChan, m.provider, req, budget{})
+	written := 0
+	for event := range streamChan {
+		// Without streaming, the answer is written once it has ended
+		streamed := !m.config.NoStream || event.done || event.err != nil
+		if len(event.content) > 0 && len(event.content[0]) > written && streamed {
+			if _, err := io.WriteString(w, event.content[0][written:]); err != nil {
+				return err
+			}
+			written = len(event.content[0])
+		}
+		if event.err != nil {
+			if written > 0 {
+				fmt.Fprintln(w)
+			}
+			return event.err
+		}
+		if event.done {
+			if written > 0 {
+				fmt.Fprintln(w)
+			}
+			if m.sessionName == "" {
+				return nil
+			}
+			m.messages = append(history, chatMessage{role: "assistant", content: event.content[0], model: m.modelName, sent: time.Now(), usage: event.usage})
+			return saveSession(m.sessionName, m.session())
+		}
+	}
+	// Interrupted, which the stream does not report
+	if written > 0 {
+		fmt.Fprintln(w)
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Prompts and commands sent are kept, like a shell's history, so they can be
+// recalled with Up and Down or searched with Ctrl+R. The conversation's own
+// prompts come first, then those sent in any conversation.
+
+// maxPromptHistory is how many prompts are kept across conversations.
+const maxPromptHistory = 1000
+
+// savePrompt adds a prompt to the history as its newest entry, dropping any
+// earlier copy and the oldest entries over maxPromptHistory.
+func savePrompt(content string) error {
+	db, err := openStore()
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM prompts WHERE content = ?`, content); err != nil {
+		return fmt.Errorf("save prompt: %w", err)
+	}
+	if _, err := tx.Exec(`INSERT INTO prompts (content, sent) VALUES (?, ?)`, content, formatTime(time.Now())); err != nil {
+		return fmt.Errorf("save prompt: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM prompts WHERE id NOT IN (SELECT id FROM prompts ORDER BY id DESC LIMIT ?)`, maxPromptHistory); err != nil {
+		return fmt.Errorf("save prompt: %w", err)
+	}
+	return tx.Commit()
+}
+
+// loadPrompts returns the prompts sent in any conversation, newest first.
+func loadPrompts() ([]string, error) {
+	db, err := openStore()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT content FROM prompts ORDER BY id DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("load prompts: %w", err)
+	}
+	defer rows.Close()
+	var prompts []string
+	for rows.Next() {
+		var content string
+		if err := rows.Scan(&content); err != nil {
+			return nil, fmt.Errorf("load prompts: %w", err)
+		}
+		prompts = append(prompts, content)
+	}
+	return prompts, rows.Err()
+}
+
+// keepPrompt adds input, as typed, to the prompt history. Like sessions, it
+// is not kept with autosave disabled.
+func (m *model) keepPrompt(input string) {
+	m.recall = nil
+	if m.config.DisableAutosave || strings.TrimSpace(input) == "" {
+		return
+	}
+	if err := savePrompt(input); err != nil {
+		m.notice = err.Error()
+	}
+}
+
+// promptHistory lists the prompts that can be recalled, newest first: the
+// conversation's own, then those from other conversations.
+func (m model) promptHistory() []string {
+	var prompts []string
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if msg := m.messages[i]; msg.role == "user" && !slices.Contains(prompts, msg.content) {
+			prompts = append(prompts, msg.content)
+		}
+	}
+	// Without the stored history, the conversation's prompts still recall
+	stored, _ := loadPrompts()
+	for _, p := range stored {
+		if !slices.Contains(prompts, p) {
+			prompts = append(prompts, p)
+		}
+	}
+	return prompts
+}
+
+// recalling reports whether the input shows a recalled prompt, unchanged, so
+// Up and Down keep moving through the history rather than the text.
+func (m model) recalling() bool {
+	return m.recallIndex >= 0 && m.recallIndex < len(m.recall) && m.input.Value() == m.recall[m.recallIndex]
+}
+
+// recallPrompt puts an older (step 1) or newer (step -1) prompt in the
+// input. Moving past the newest one empties the input again.
+func (m *model) recallPrompt(step int) {
+	if !m.recalling() {
+		m.recall = m.promptHistory()
+		m.recallIndex = -1
+	}
+	i := m.recallIndex + step
+	switch {
+	case i < 0:
+		m.recall = nil
+		m.setInput("")
+		return
+	case i >= len(m.recall):
+		if len(m.recall) == 0 {
+			m.recall = nil
+			m.notice = "No prompts sent yet"
+		} else {
+			m.notice = "No older prompts"
+		}
+		return
+	}
+	m.recallIndex = i
+	m.setInput(m.recall[i])
+}
+
+// openPromptSearch lists the prompt history to search as you type, putting
+// the one picked in the input.
+func openPromptSearch(m *model) tea.Cmd {
+	m.palette = &palette{entries: promptEntries, noMatches: "No matching prompts", verb: "recall"}
+	return nil
+}
+
+func promptEntries(m model) []paletteEntry {
+	prompts := m.promptHistory()
+	entries := make([]paletteEntry, len(prompts))
+	for i, p := range prompts {
+		entries[i] = paletteEntry{
+			label: truncateLine(strings.Join(strings.Fields(p), " "), m.width-2),
+			run: func(m *model) tea.Cmd {
+				m.setInput(p)
+				return nil
+			},
+		}
+	}
+	return entries
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/openai/openai-go"
+)
+
+// provider is a chat backend. The rest of the app only deals in requests and
+// chunks, so backends can be added without touching the update loop.
+type provider interface {
+	// stream starts a request. The channel delivers the response as it
+	// arrives and is closed when it ends; an error ending it early is sent
+	// as a final chunk. Cancelling ctx stops the request and closes the
+	// channel.
+	stream(ctx context.Context, req request) (<-chan chunk, error)
+}
+
+// modelLister is implemented by providers that can list the models they
+// offer.
+type modelLister interface {
+	models(ctx context.Context) ([]string, error)
+}
+
+// embedder is implemented by providers that can embed text, as documents are
+// indexed and searched with.
+type embedder interface {
+	// embed returns an embedding for each of texts, in order.
+	embed(ctx context.Context, model string, texts []string) ([][]float32, error)
+}
+
+// message is a conversation turn as sent to a provider.
+type message struct {
+	role    string // "system", "user" or "assistant"
+	content string
+	images  []attachedImage // only in user messages
+	// toolCalls are made by an assistant message, and a "tool" message gives
+	// the result of the call toolCallID, to the tool toolName.
+	toolCalls  []toolCall
+	toolCallID string
+	toolName   string
+}
+
+// request is a provider-neutral chat request. Providers ignore settings they
+// cannot express.
+type request struct {
+	model    string
+	messages []message
+	choices  int // candidate responses to generate, at least one
+	stop     []string
+	json     bool
+	schema   *jsonSchema // with json, a schema responses must follow
+	tools    []tool      // tools the model may call
+	// Unset sampling settings, penalties and seed are left to the
+	// provider's defaults.
+	temperature      *float64
+	topP             *float64
+	maxTokens        *int64
+	presencePenalty  *float64
+	frequencyPenalty *float64
+	seed             *int64
+	// reasoningEffort is how hard a reasoning model should think, one of
+	// reasoningEfforts, or empty to leave it to the model.
+	reasoningEffort string
+}
+
+// chunk is a piece of a streamed response: text for one of the choices, or
+// the thinking a reasoning model does before it, the tokens the request used,
+// the complete calls of a response calling tools, or the error that ended the
+// stream.
+type chunk struct {
+	choice    int
+	content   string
+	reasoning string
+	usage     *usage
+	toolCalls []toolCall
+	err       error
+}
+
+// sendChunk delivers c unless the request is cancelled first, reporting
+// whether it was delivered.
+func sendChunk(ctx context.Context, ch chan<- chunk, c chunk) bool {
+	select {
+	case ch <- c:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// backend describes a kind of provider: where its settings are found in the
+// environment and how to connect to it.
+type backend struct {
+	keyEnv       string // empty if no API key is needed
+	modelEnv     string
+	baseURLEnv   string
+	defaultModel string
+	// optionalKey allows leaving out the key when a base URL is set, since
+	// local compatible servers often do not check it.
+	optionalKey bool
+	// connect builds the client for a profile, whose API key and base URL
+	// have been filled in from the environment if need be.
+	connect func(p profile) (provider, error)
+}
+
+// backends are the supported providers by name, as set in a profile or
+// LLMTUI_PROVIDER.
+var backends = map[string]backend{
+	"openai": {
+		keyEnv:       "OPENAI_API_KEY",
+		modelEnv:     "OPENAI_MODEL",
+		baseURLEnv:   "OPENAI_BASE_URL",
+		defaultModel: defaultModel,
+		optionalKey:  true,
+		connect:      newOpenAIProvider,
+	},
+	"anthropic": {
+		keyEnv:       "ANTHROPIC_API_KEY",
+		modelEnv:     "ANTHROPIC_MODEL",
+		baseURLEnv:   "ANTHROPIC_BASE_URL",
+		defaultModel: defaultAnthropicModel,
+		connect:      newAnthropicProvider,
+	},
+	"gemini": {
+		keyEnv:       "GEMINI_API_KEY",
+		modelEnv:     "GEMINI_MODEL",
+		defaultModel: defaultGeminiModel,
+		connect:      newGeminiProvider,
+	},
+	"ollama": {
+		modelEnv:     "OLLAMA_MODEL",
+		baseURLEnv:   "OLLAMA_HOST",
+		defaultModel: defaultOllamaModel,
+		connect:      newOllamaProvider,
+	},
+	// Azure deployments stand in for model names
+	"azure": {
+		keyEnv:       "AZURE_OPENAI_API_KEY",
+		modelEnv:     "AZURE_OPENAI_DEPLOYMENT",
+		baseURLEnv:   "AZURE_OPENAI_ENDPOINT",
+		defaultModel: defaultModel,
+		connect:      newAzureProvider,
+	},
+}
+
+// lookupBackend returns the named backend, empty meaning the one chosen by
+// LLMTUI_PROVIDER or else OpenAI.
+func lookupBackend(name string) (backend, error) {
+	if name == "" {
+		name = os.Getenv("LLMTUI_PROVIDER")
+	}
+	if name == "" {
+		name = "openai"
+	}
+	b, ok := backends[name]
+	if !ok {
+		return backend{}, errUsage{fmt.Errorf("unsupported provider %q", name)}
+	}
+	return b, nil
+}
+
+// configured reports whether the environment holds the key needed to
+// connect, if one is.
+func (b backend) configured() bool {
+	if b.keyEnv == "" || os.Getenv(b.keyEnv) != "" {
+		return true
+	}
+	return b.optionalKey && os.Getenv(b.baseURLEnv) != ""
+}
+
+// apiStatus returns the HTTP status of an error returned by a provider's
+// API, reporting false for any other error.
+func apiStatus(err error) (int, bool) {
+	var openaiErr *openai.Error
+	if errors.As(err, &openaiErr) {
+		return openaiErr.StatusCode, true
+	}
+	var anthropicErr *anthropicError
+	if errors.As(err, &anthropicErr) {
+		return anthropicErr.StatusCode, true
+	}
+	var ollamaErr *ollamaError
+	if errors.As(err, &ollamaErr) {
+		return ollamaErr.StatusCode, true
+	}
+	var geminiErr *geminiError
+	if errors.As(err, &geminiErr) {
+		return geminiErr.StatusCode, true
+	}
+	return 0, false
+}
+
+// newRequest builds the request asking modelName to respond to the
+// conversation history, with the current generation settings. The oldest
+// turns are left out if the history does not fit the model's context window;
+// it also returns how many were.
+func (m model) newRequest(history []chatMessage, modelName string) (request, int) {
+	settings := m.config
+	if m.settings != nil {
+		settings = *m.settings
+	}
+	req := request{
+		model:            modelName,
+		choices:          max(m.config.Choices, 1),
+		stop:             m.config.Stop,
+		json:             m.config.JSONMode,
+		schema:           m.jsonSchema,
+		temperature:      settings.Temperature,
+		topP:             settings.TopP,
+		maxTokens:        settings.MaxTokens,
+		presencePenalty:  settings.PresencePenalty,
+		frequencyPenalty: settings.FrequencyPenalty,
+		seed:             settings.Seed,
+		reasoningEffort:  settings.ReasoningEffort,
+	}
+	if m.systemPrompt != "" {
+		req.messages = append(req.messages, message{role: "system", content: m.systemPrompt})
+	}
+	if msg, ok := memoryMessage(); ok {
+		req.messages = append(req.messages, msg)
+	}
+	summarized := false
+	for _, msg := range history {
+		// Failed turns are shown but never sent
+		if msg.err != nil {
+			continue
+		}
+		if msg.compacted {
+			// The summary is sent once, where the messages it replaces were
+			if !summarized && m.summary != "" {
+				req.messages = append(req.messages, message{role: "system", content: summaryPrefix + m.summary})
+			}
+			summarized = true
+			continue
+		}
+		req.messages = append(req.messages, message{
+			role:       msg.role,
+			content:    msg.content,
+			images:     msg.images,
+			toolCalls:  msg.toolCalls,
+			toolCallID: msg.toolCallID,
+			toolName:   msg.toolName,
+		})
+	}
+	// Several choices could each call tools, which there is no way to follow
+	if req.choices == 1 {
+		req.tools = m.tools()
+	}
+	reserve := responseReserve
+	if req.maxTokens != nil {
+		reserve = int(*req.maxTokens)
+	}
+	var dropped int
+	req.messages, dropped = fitContext(pairToolMessages(req.messages), m.config.contextWindow(modelName), reserve)
+	return req, dropped
+}
+
+// listModelsTimeout bounds how long listing a provider's models may take.
+const listModelsTimeout = 10 * time.Second
+
+// modelsMsg reports the models a provider offers.
+type modelsMsg struct {
+	models []string
+	err    error
+	quiet  bool // only remember the models for completion
+}
+
+// listModels asks the provider for its models, if it can list them. Quietly
+// listing them reports nothing, for when the user did not ask.
+func (m model) listModels(quiet bool) tea.Cmd {
+	lister, ok := m.provider.(modelLister)
+	if !ok {
+		return nil
+	}
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), listModelsTimeout)
+		defer cancel()
+		models, err := lister.models(ctx)
+		return modelsMsg{models: models, err: err, quiet: quiet}
+	}
+}
+
+// readEvents calls fn with the data of each server-sent event read from r
+// until fn returns false or r ends, returning any error reading it.
+func readEvents(r io.Reader, fn func(data []byte) bool) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := bytes.CutPrefix(scanner.Bytes(), []byte("data:"))
+		if !ok {
+			// Event names are repeated in the data, and other lines are
+			// comments or blank separators
+			continue
+		}
+		if !fn(bytes.TrimSpace(data)) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
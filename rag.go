@@ -0,0 +1,459 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Documents indexed with llmtui index are cut into chunks, each stored with
+// its embedding in the session database. Before each request, the chunks
+// closest to the user's message are given to the model as context, and the
+// answer cites them.
+
+// ragConfig sets up the embeddings documents are indexed and retrieved with.
+type ragConfig struct {
+	// Provider embeds text: openai, the default, azure or ollama. Its key
+	// and base URL are found as for a profile of that provider.
+	Provider   string `toml:"provider,omitempty"`
+	Model      string `toml:"model,omitempty"` // defaulting to defaultEmbeddingModels
+	APIKey     string `toml:"api_key,omitempty"`
+	APIKeyEnv  string `toml:"api_key_env,omitempty"`
+	BaseURL    string `toml:"base_url,omitempty"`
+	APIVersion string `toml:"api_version,omitempty"` // Azure OpenAI only
+	// TopK is how many chunks are given with each message, defaulting to
+	// defaultRAGTopK.
+	TopK int `toml:"top_k,omitempty"`
+	// Disabled stops retrieval without dropping the index.
+	Disabled bool `toml:"disabled,omitempty"`
+}
+
+// defaultEmbeddingModels are the embedding models used with each provider
+// unless another is configured. For Azure, it names the deployment.
+var defaultEmbeddingModels = map[string]string{
+	"openai": "text-embedding-3-small",
+	"azure":  "text-embedding-3-small",
+	"ollama": "nomic-embed-text",
+}
+
+const (
+	defaultRAGTopK = 4
+	// chunkSize is about how many characters of a document go in a chunk.
+	chunkSize = 1500
+	// maxChunkTokens caps a chunk that could not be cut at a line break,
+	// such as a minified file, to fit what embedding models take.
+	maxChunkTokens = 2000
+	// maxIndexedFileSize skips files too large to be documents.
+	maxIndexedFileSize = 1024 * 1024
+	// embedBatchSize is how many chunks are embedded per request.
+	embedBatchSize = 64
+	// ragTimeout bounds how long retrieval may delay a request.
+	ragTimeout = 15 * time.Second
+)
+
+func (r ragConfig) provider() string {
+	return cmp.Or(r.Provider, "openai")
+}
+
+func (r ragConfig) model() string {
+	return cmp.Or(r.Model, defaultEmbeddingModels[r.provider()])
+}
+
+func (r ragConfig) topK() int {
+	if r.TopK <= 0 {
+		return defaultRAGTopK
+	}
+	return r.TopK
+}
+
+// embedder connects to the provider documents are embedded with.
+func (r ragConfig) embedder() (embedder, error) {
+	p := profile{Provider: r.provider(), Model: r.model(), APIKey: r.APIKey, APIKeyEnv: r.APIKeyEnv, BaseURL: r.BaseURL, APIVersion: r.APIVersion}
+	backend, _, err := p.connect()
+	if err != nil {
+		return nil, fmt.Errorf("embeddings: %w", err)
+	}
+	e, ok := backend.(embedder)
+	if !ok {
+		return nil, errUsage{fmt.Errorf("the %s provider cannot embed text, set provider in [rag] to openai, azure or ollama", r.provider())}
+	}
+	return e, nil
+}
+
+// textChunk is a piece of a document, from line first to line last.
+type textChunk struct {
+	path    string // relative to the indexed folder
+	first   int
+	last    int
+	content string
+}
+
+// source names the chunk as cited under an answer.
+func (c textChunk) source(root string) string {
+	path := filepath.Join(root, c.path)
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			path = rel
+		}
+	}
+	return fmt.Sprintf("%s:%d-%d", path, c.first, c.last)
+}
+
+// chunkText cuts a document into chunks of about chunkSize characters,
+// breaking at a blank line in the second half of one where there is one,
+// otherwise between lines.
+func chunkText(path, text string) []textChunk {
+	lines := strings.Split(text, "\n")
+	var chunks []textChunk
+	add := func(start, end int) {
+		// Leave out the blank lines around it
+		for start < end && strings.TrimSpace(lines[start]) == "" {
+			start++
+		}
+		for end > start && strings.TrimSpace(lines[end-1]) == "" {
+			end--
+		}
+		if start == end {
+			return
+		}
+		content, _ := cutToTokens(strings.Join(lines[start:end], "\n"), maxChunkTokens)
+		chunks = append(chunks, textChunk{path: path, first: start + 1, last: end, content: content})
+	}
+	start, size, blank := 0, 0, -1
+	for i := 0; i < len(lines); i++ {
+		if size > 0 && size+len(lines[i]) > chunkSize {
+			end := i
+			if blank > start {
+				end = blank
+			}
+			add(start, end)
+			start, size, blank = end, 0, -1
+			i = end - 1
+			continue
+		}
+		if strings.TrimSpace(lines[i]) == "" && size > chunkSize/2 {
+			blank = i
+		}
+		size += len(lines[i]) + 1
+	}
+	add(start, len(lines))
+	return chunks
+}
+
+// indexFolder indexes the text files in root, replacing what was indexed
+// from it before. progress is called as chunks are embedded. It returns how
+// many files and chunks were indexed.
+func indexFolder(ctx context.Context, root string, r ragConfig, progress func(done, total int)) (int, int, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return 0, 0, err
+	}
+	if info, err := os.Stat(root); err != nil {
+		return 0, 0, err
+	} else if !info.IsDir() {
+		return 0, 0, errUsage{fmt.Errorf("%s is not a folder", root)}
+	}
+	e, err := r.embedder()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var chunks []textChunk
+	files := 0
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if path != root && (strings.HasPrefix(name, ".") || name == "node_modules") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.Size() > maxIndexedFileSize {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if !utf8.Valid(data) || strings.ContainsRune(string(data), 0) {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		if found := chunkText(rel, string(data)); len(found) > 0 {
+			chunks = append(chunks, found...)
+			files++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(chunks) == 0 {
+		return 0, 0, fmt.Errorf("no text files found in %s", root)
+	}
+
+	vectors := make([][]float32, 0, len(chunks))
+	for batch := range slices.Chunk(chunks, embedBatchSize) {
+		texts := make([]string, len(batch))
+		for i, c := range batch {
+			// The path tells the model what a chunk is about as much as its
+			// text does
+			texts[i] = c.path + "\n\n" + c.content
+		}
+		embedded, err := e.embed(ctx, r.model(), texts)
+		if err != nil {
+			return 0, 0, err
+		}
+		vectors = append(vectors, embedded...)
+		progress(len(vectors), len(chunks))
+	}
+	if err := storeChunks(root, r.model(), chunks, vectors); err != nil {
+		return 0, 0, err
+	}
+	return files, len(chunks), nil
+}
+
+// runIndex indexes a folder for llmtui index, showing progress on stderr.
+func runIndex(root string) error {
+	loadEnv()
+	cfg, err := loadConfig()
+	if err != nil {
+		return errUsage{err}
+	}
+	embedding := false
+	files, chunks, err := indexFolder(context.Background(), root, cfg.RAG, func(done, total int) {
+		fmt.Fprintf(os.Stderr, "\rEmbedding chunks %d/%d", done, total)
+		embedding = true
+	})
+	if embedding {
+		fmt.Fprintln(os.Stderr)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Indexed %d files in %d chunks with %s\n", files, chunks, cfg.RAG.model())
+	return nil
+}
+
+// storeChunks replaces the chunks indexed from root.
+func storeChunks(root, model string, chunks []textChunk, vectors [][]float32) error {
+	db, err := openStore()
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM chunks WHERE root = ?`, root); err != nil {
+		return fmt.Errorf("save index: %w", err)
+	}
+	insert, err := tx.Prepare(`INSERT INTO chunks (root, path, first_line, last_line, content, model, embedding) VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("save index: %w", err)
+	}
+	defer insert.Close()
+	for i, c := range chunks {
+		if _, err := insert.Exec(root, c.path, c.first, c.last, c.content, model, encodeVector(vectors[i])); err != nil {
+			return fmt.Errorf("save index: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// encodeVector stores an embedding as little-endian float32s.
+func encodeVector(v []float32) []byte {
+	data := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(f))
+	}
+	return data
+}
+
+func decodeVector(data []byte) []float32 {
+	v := make([]float32, len(data)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	return v
+}
+
+// cosine returns the cosine similarity of two embeddings, zero if they differ
+// in length.
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+// indexSummary counts the chunks indexed with each embedding model.
+func indexSummary(db *sql.DB) (map[string]int, error) {
+	rows, err := db.Query(`SELECT model, count(*) FROM chunks GROUP BY model`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make(map[string]int)
+	for rows.Next() {
+		var model string
+		var n int
+		if err := rows.Scan(&model, &n); err != nil {
+			return nil, err
+		}
+		counts[model] = n
+	}
+	return counts, rows.Err()
+}
+
+// augment gives the model the indexed chunks closest to the last user message
+// of req, in a system message before it, returning the sources of the chunks
+// in the order they are numbered. Nothing is added when nothing is indexed.
+func (r ragConfig) augment(ctx context.Context, req *request) ([]string, error) {
+	at := -1
+	for i := len(req.messages) - 1; i >= 0; i-- {
+		if req.messages[i].role == "user" {
+			at = i
+			break
+		}
+	}
+	if at < 0 || strings.TrimSpace(req.messages[at].content) == "" {
+		return nil, nil
+	}
+	db, err := openStore()
+	if err != nil {
+		return nil, err
+	}
+	counts, err := indexSummary(db)
+	if err != nil || len(counts) == 0 {
+		return nil, err
+	}
+	if counts[r.model()] == 0 {
+		return nil, fmt.Errorf("the documents were indexed with another embedding model than %s, run llmtui index again", r.model())
+	}
+	e, err := r.embedder()
+	if err != nil {
+		return nil, err
+	}
+	embedded, err := e.embed(ctx, r.model(), []string{req.messages[at].content})
+	if err != nil {
+		return nil, err
+	}
+
+	type scored struct {
+		chunk textChunk
+		root  string
+		score float64
+	}
+	rows, err := db.QueryContext(ctx, `SELECT root, path, first_line, last_line, content, embedding FROM chunks WHERE model = ?`, r.model())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var best []scored
+	for rows.Next() {
+		var s scored
+		var embedding []byte
+		if err := rows.Scan(&s.root, &s.chunk.path, &s.chunk.first, &s.chunk.last, &s.chunk.content, &embedding); err != nil {
+			return nil, err
+		}
+		s.score = cosine(embedded[0], decodeVector(embedding))
+		best = append(best, s)
+		// Keep only the closest, so large indexes are not held in memory
+		if len(best) > 4*r.topK() {
+			slices.SortFunc(best, func(a, b scored) int { return cmp.Compare(b.score, a.score) })
+			best = best[:r.topK()]
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	slices.SortFunc(best, func(a, b scored) int { return cmp.Compare(b.score, a.score) })
+	best = best[:min(len(best), r.topK())]
+
+	var b strings.Builder
+	b.WriteString("Excerpts from the user's documents that may help with their next message follow. " +
+		"Cite the ones you use by their number, as in [1], and ignore the ones that do not help.")
+	sources := make([]string, len(best))
+	for i, s := range best {
+		sources[i] = s.chunk.source(s.root)
+		fence := fenceFor(s.chunk.content)
+		fmt.Fprintf(&b, "\n\n[%d] %s\n%s\n%s\n%s", i+1, sources[i], fence, s.chunk.content, fence)
+	}
+	req.messages = slices.Insert(req.messages, at, message{role: "system", content: b.String()})
+	return sources, nil
+}
+
+// formatSources lists the sources cited under an answer.
+func formatSources(sources []string) string {
+	cited := make([]string, len(sources))
+	for i, s := range sources {
+		cited[i] = fmt.Sprintf("[%d] %s", i+1, s)
+	}
+	return "Sources: " + strings.Join(cited, " · ")
+}
+
+func runRAG(m *model, args string) tea.Cmd {
+	switch args {
+	case "":
+	case "on":
+		m.config.RAG.Disabled = false
+	case "off":
+		m.config.RAG.Disabled = true
+		m.notice = "Retrieval off, the indexed documents are not searched"
+		return nil
+	default:
+		m.notice = "Usage: /rag [on|off]"
+		return nil
+	}
+	db, err := openStore()
+	if err != nil {
+		m.notice = err.Error()
+		return nil
+	}
+	counts, err := indexSummary(db)
+	if err != nil {
+		m.notice = err.Error()
+		return nil
+	}
+	switch {
+	case len(counts) == 0:
+		m.notice = "No documents are indexed, run llmtui index <folder> first"
+	case counts[m.config.RAG.model()] == 0:
+		m.notice = fmt.Sprintf("The documents were indexed with another embedding model than %s, run llmtui index again", m.config.RAG.model())
+	case m.config.RAG.Disabled:
+		m.notice = fmt.Sprintf("Retrieval off, %d chunks are indexed with %s (/rag on to search them)", counts[m.config.RAG.model()], m.config.RAG.model())
+	default:
+		m.notice = fmt.Sprintf("Retrieval on, searching %d chunks indexed with %s for each message", counts[m.config.RAG.model()], m.config.RAG.model())
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Reasoning models, such as OpenAI's o-series, DeepSeek R1 or Claude and
+// Gemini with thinking enabled, think before they answer. How hard is set with
+// reasoning_effort, and the thinking they show is kept apart from the answer,
+// dimmed and folded away once the response is complete.
+
+// reasoningEfforts are the values reasoning_effort takes, as OpenAI names
+// them.
+var reasoningEfforts = []string{"low", "medium", "high"}
+
+// thinkingBudget is how many tokens providers that set thinking by budget
+// rather than effort, Anthropic and Gemini, may spend thinking at an effort.
+func thinkingBudget(effort string) int64 {
+	switch effort {
+	case "low":
+		return 2048
+	case "high":
+		return 24576
+	}
+	return 8192
+}
+
+// splitThinking separates the thinking some models, such as DeepSeek R1 when
+// served without a separate reasoning field, write between <think> tags at
+// the start of a response from the answer that follows. Until the closing tag
+// arrives, all of it is thinking.
+func splitThinking(content string) (thinking, answer string) {
+	rest, ok := strings.CutPrefix(strings.TrimLeft(content, " \t\n"), "<think>")
+	if !ok {
+		return "", content
+	}
+	thinking, answer, ok = strings.Cut(rest, "</think>")
+	if !ok {
+		return rest, ""
+	}
+	return thinking, strings.TrimLeft(answer, "\n")
+}
+
+// splitResponses returns the answers received for each choice, with the
+// thinking written in tags split out of them, and the first choice's
+// thinking: what was received as such followed by what was in tags.
+func splitResponses(responses []strings.Builder, thinking string) ([]string, string) {
+	answers := builderStrings(responses)
+	for i, s := range answers {
+		tagged, answer := splitThinking(s)
+		answers[i] = answer
+		if i == 0 {
+			thinking += tagged
+		}
+	}
+	return answers, thinking
+}
+
+// renderThinking renders a response's thinking dimmed and wrapped to width,
+// or, unless expanded, a line saying how long it is.
+func renderThinking(reasoning string, expanded bool, width int) string {
+	reasoning = strings.TrimSpace(reasoning)
+	if reasoning == "" {
+		return ""
+	}
+	if !expanded {
+		return helpStyle.Render(fmt.Sprintf("▸ Thinking, %d words (Alt+H to show)", len(strings.Fields(reasoning)))) + "\n"
+	}
+	return helpStyle.Render("▾ Thinking") + "\n" + helpStyle.Width(markdownWidth(width)).Render(reasoning) + "\n\n"
+}
+
+// toggleThinking shows or hides the thinking of the target response.
+func (m *model) toggleThinking() {
+	i := m.targetMessage()
+	if i < 0 || strings.TrimSpace(m.messages[i].reasoning) == "" {
+		m.notice = "No thinking to show"
+		return
+	}
+	m.messages[i].showReasoning = !m.messages[i].showReasoning
+}
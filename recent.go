@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// maxRecentModels is how many recently used models are remembered.
+const maxRecentModels = 5
+
+func recentModelsPath() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "recent_models.json"), nil
+}
+
+// loadRecentModels returns the remembered models, most recent first. The list
+// is a convenience, so a missing or unreadable file just yields an empty list.
+func loadRecentModels() []string {
+	path, err := recentModelsPath()
+	if err != nil {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var models []string
+	if err := json.Unmarshal(data, &models); err != nil {
+		return nil
+	}
+	return models
+}
+
+func saveRecentModels(models []string) error {
+	path, err := recentModelsPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(models)
+	if err != nil {
+		return err
+	}
+	// The data directory also holds the history, see initStore
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// addRecentModel moves name to the front of models, dropping the oldest
+// entries beyond maxRecentModels.
+func addRecentModel(models []string, name string) []string {
+	models = slices.DeleteFunc(slices.Clone(models), func(s string) bool { return s == name })
+	models = append([]string{name}, models...)
+	if len(models) > maxRecentModels {
+		models = models[:maxRecentModels]
+	}
+	return models
+}
+
+// rememberModel records the current model as the most recently used one.
+func (m *model) rememberModel() {
+	if m.modelName == "" {
+		return
+	}
+	m.recentModels = addRecentModel(m.recentModels, m.modelName)
+	if err := saveRecentModels(m.recentModels); err != nil {
+		m.notice = "Could not save recent models: " + err.Error()
+	}
+}
+
+// cycleModel switches to the recently used model after the current one,
+// without reordering the list so repeated presses visit every entry.
+func (m *model) cycleModel() {
+	if len(m.recentModels) < 2 {
+		m.notice = "No other recent models, switch with /model <name>"
+		return
+	}
+	i := slices.Index(m.recentModels, m.modelName)
+	m.modelName = m.recentModels[(i+1)%len(m.recentModels)]
+	m.notice = "Model: " + m.modelName
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// renderConversation renders the conversation from the model's messages,
+// followed by the response in progress or the choices waiting to be picked.
+// Everything shown in the chat body is derived here, so the screen always
+// matches the conversation state.
+func renderConversation(m model) string {
+	var b strings.Builder
+	b.WriteString(m.renderTurns(len(m.messages)))
+
+	switch {
+	case m.compare != nil:
+		b.WriteString(renderComparison(m.compare, m.width, m.loading))
+	case len(m.choices) > 0:
+		b.WriteString(renderChoices(m.choices, false))
+	case m.loading && len(m.partialChoices) > 0:
+		b.WriteString(renderChoices(m.partialChoices, true))
+	case m.loading:
+		if m.streaming && (m.partialResp != "" || m.partialReasoning != "") && !m.config.NoStream {
+			// The thinking is shown in full until the answer is complete
+			b.WriteString(assistantLabel(chatMessage{model: m.override}) + "\n" +
+				renderThinking(m.partialReasoning, true, m.width) +
+				renderPartialMarkdown(m.partialResp, markdownWidth(m.width)) + assistantStyle.Render("█"))
+		} else {
+			b.WriteString(assistantStyle.Render(activeLabels.Assistant + " is typing..."))
+		}
+		b.WriteString("\n\n")
+	}
+	return b.String()
+}
+
+// renderTurns renders the messages shown before index end, as they start
+// the conversation.
+func (m model) renderTurns(end int) string {
+	var b strings.Builder
+	if m.firstVisible > 0 {
+		b.WriteString(helpStyle.Render(fmt.Sprintf("[%d earlier messages not shown]", m.firstVisible)) + "\n\n")
+	}
+	for i := m.firstVisible; i < end; i++ {
+		b.WriteString(m.renderTurn(i))
+	}
+	return b.String()
+}
+
+// renderTurn renders the message at index i, followed by a marker if it is
+// the last one replaced by the summary in requests.
+func (m model) renderTurn(i int) string {
+	s := highlightMatches(renderMessage(m.messages[i], m.highlighted(i), m.width), m.findQuery)
+	if m.messages[i].compacted && (i+1 == len(m.messages) || !m.messages[i+1].compacted) {
+		s += helpStyle.Render("── messages above are summarized in requests, /compact off restores them ──") + "\n\n"
+	}
+	return s
+}
+
+// renderMessage renders a single conversation turn for the conversation view,
+// highlighting it when selected. Responses are rendered as markdown wrapped to
+// width.
+func renderMessage(msg chatMessage, selected bool, width int) string {
+	var block string
+	switch {
+	case msg.err != nil:
+		block = errorStyle.Render("Error: ") + msg.err.Error() + "\n" +
+			helpStyle.Render("Press "+bindingLabel("retry")+" to retry")
+	case msg.role == "tool":
+		content := strings.TrimRight(msg.content, "\n")
+		if msg.collapsed {
+			content = collapse(content)
+		}
+		block = toolStyle.Render("↳ "+msg.toolName) + "\n" + content
+	case msg.role == "user":
+		block = userLabel(userStyle) + msg.content
+		for _, img := range msg.images {
+			block += "\n" + helpStyle.Render(img.placeholder())
+		}
+	case msg.raw:
+		block = assistantLabel(msg) + helpStyle.Render("[raw]") + "\n" + msg.content
+	default:
+		label, content := assistantLabel(msg), formattedContent(msg)
+		if !msg.json {
+			// Rendered markdown is a block of its own, below the label
+			label += "\n"
+			content = renderMarkdown(content, markdownWidth(width))
+		}
+		if msg.collapsed {
+			content = collapse(content)
+		}
+		if msg.reasoning != "" {
+			// The thinking goes between the label and the answer
+			label = strings.TrimSuffix(label, "\n") + "\n" + renderThinking(msg.reasoning, msg.showReasoning, width)
+		}
+		block = label + content
+		for _, call := range msg.toolCalls {
+			block += "\n" + toolStyle.Render("⚙ "+call.name) + " " + helpStyle.Render(call.arguments)
+		}
+		if msg.interrupted != nil {
+			block += errorStyle.Render(" ⚡ response cut off here: ") + msg.interrupted.Error()
+		}
+	}
+	if len(msg.sources) > 0 {
+		block += "\n" + helpStyle.Render(formatSources(msg.sources))
+	}
+	if msg.usage.tokens() > 0 {
+		block += "\n" + helpStyle.Render(msg.usage.String())
+	}
+	if selected {
+		block = selectedStyle.Render(block)
+	}
+	if msg.discarded > 0 {
+		block += "\n\n" + helpStyle.Render(fmt.Sprintf("── branched here, %d later messages left behind, see /branches ──", msg.discarded))
+	}
+	return block + "\n\n"
+}
+
+// collapse cuts content down to its first collapsedLines lines, noting how
+// many more there are.
+func collapse(content string) string {
+	lines := strings.Split(content, "\n")
+	if len(lines) <= collapsedLines {
+		return content
+	}
+	return strings.Join(lines[:collapsedLines], "\n") + "\n" +
+		helpStyle.Render(fmt.Sprintf("[+%d more lines]", len(lines)-collapsedLines))
+}
+
+// formattedContent returns a message's content as it is displayed, before any
+// collapsing.
+func formattedContent(msg chatMessage) string {
+	if !msg.json {
+		return msg.content
+	}
+	content, _ := prettyJSON(msg.content)
+	if msg.jsonErr != nil {
+		content += "\n" + errorStyle.Render("(response "+msg.jsonErr.Error()+")")
+	}
+	return content
+}
+
+// markdownWidth is the width responses are wrapped to in a terminal width
+// columns wide, leaving room for the selection border.
+func markdownWidth(width int) int {
+	if width <= 0 {
+		return 0
+	}
+	return max(width-selectedStyle.GetHorizontalFrameSize(), 1)
+}
+
+// assistantLabel labels a response, naming the model when it is not the
+// conversation's.
+func assistantLabel(msg chatMessage) string {
+	name := activeLabels.Assistant
+	if msg.model != "" {
+		name += " (" + msg.model + ")"
+	}
+	return assistantStyle.Render(labelText(activeLabels.AssistantIcon, name))
+}
+
+// userLabel renders the label shown before the user's messages and input.
+func userLabel(style lipgloss.Style) string {
+	return style.Render(labelText(activeLabels.UserIcon, activeLabels.User))
+}
+
+func labelText(icon, name string) string {
+	if icon != "" {
+		return icon + " " + name + ": "
+	}
+	return name + ": "
+}
+
+// renderChoices renders each candidate response in its own labelled section.
+func renderChoices(choices []string, streaming bool) string {
+	var b strings.Builder
+	for i, c := range choices {
+		b.WriteString(assistantStyle.Render(fmt.Sprintf("Choice %d:", i+1)) + "\n" + c)
+		if streaming {
+			b.WriteString(assistantStyle.Render("█"))
+		}
+		b.WriteString("\n\n")
+	}
+	return b.String()
+}
+
+// truncateLine shortens a styled line to fit width columns, ending it with an
+// ellipsis when cut. Escape sequences do not count towards the width and are
+// kept intact. A width of zero, before the terminal size is known, leaves the
+// line alone.
+func truncateLine(line string, width int) string {
+	if width <= 0 || lipgloss.Width(line) <= width {
+		return line
+	}
+	return ansi.Truncate(line, width, "…")
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/charmbracelet/x/ansi"
+)
+
+func TestRenderConversation(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []chatMessage
+		loading  bool
+		partial  string
+		want     []string // in order
+		notWant  []string
+	}{
+		{
+			name:     "user",
+			messages: []chatMessage{{role: "user", content: "What is Go?"}},
+			want:     []string{activeLabels.User, "What is Go?"},
+		},
+		{
+			name:     "assistant",
+			messages: []chatMessage{{role: "user", content: "Hi"}, {role: "assistant", content: "Hello there"}},
+			want:     []string{"Hi", activeLabels.Assistant, "Hello there"},
+		},
+		{
+			name:     "error",
+			messages: []chatMessage{{role: "user", content: "Hi"}, {role: "assistant", err: errors.New("rate limited")}},
+			want:     []string{"Error: rate limited", "to retry"},
+		},
+		{
+			name:     "waiting",
+			messages: []chatMessage{{role: "user", content: "Hi"}},
+			loading:  true,
+			want:     []string{activeLabels.Assistant + " is typing..."},
+		},
+		{
+			name:     "streaming",
+			messages: []chatMessage{{role: "user", content: "Hi"}},
+			loading:  true,
+			partial:  "Hello, wor",
+			want:     []string{"Hello, wor", "█"},
+			notWant:  []string{"is typing"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testModel(t)
+			m.width = 80
+			m.messages = tt.messages
+			m.loading = tt.loading
+			m.streaming = tt.partial != ""
+			m.partialResp = tt.partial
+			got := ansi.Strip(renderConversation(m))
+			// Each is expected after the one before
+			rest := got
+			for _, s := range tt.want {
+				_, after, ok := strings.Cut(rest, s)
+				if !ok {
+					t.Errorf("missing %q, in order, in:\n%s", s, got)
+					break
+				}
+				rest = after
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(got, s) {
+					t.Errorf("unexpected %q in:\n%s", s, got)
+				}
+			}
+		})
+	}
+}
+
+func TestTruncateLine(t *testing.T) {
+	red, bold, reset := "\x1b[31m", "\x1b[1m", "\x1b[0m"
+	tests := []struct {
+		name  string
+		line  string
+		width int
+		want  string // without styling
+	}{
+		{"fits", red + "short" + reset, 10, "short"},
+		{"exact", red + "exactly10!" + reset, 10, "exactly10!"},
+		{"cut", red + "a red line" + reset + " then " + bold + "bold" + reset, 12, "a red line …"},
+		{"cut inside colour", bold + "bold words go on" + reset, 8, "bold wo…"},
+		{"wide characters", red + "日本語のテキスト" + reset, 7, "日本語…"},
+		{"no width", red + "unknown width" + reset, 0, "unknown width"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateLine(tt.line, tt.width)
+			if plain := ansi.Strip(got); plain != tt.want {
+				t.Errorf("truncateLine(%q, %d) shows %q, want %q", tt.line, tt.width, plain, tt.want)
+			}
+			if tt.width > 0 && ansi.StringWidth(got) > tt.width {
+				t.Errorf("truncateLine(%q, %d) is %d columns wide", tt.line, tt.width, ansi.StringWidth(got))
+			}
+			if strings.HasPrefix(tt.line, red) && !strings.HasPrefix(got, red) {
+				t.Errorf("truncateLine(%q, %d) = %q lost its colour", tt.line, tt.width, got)
+			}
+		})
+	}
+}
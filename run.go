@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxRunOutput caps how much of a command's output is included in a prompt.
+const maxRunOutput = 16 * 1024
+
+// runTimeout bounds how long a command started with /run may take.
+const runTimeout = 30 * time.Second
+
+// runOutputMsg carries the result of a command started with /run.
+type runOutputMsg struct {
+	tab     int
+	command string
+	output  string
+	err     error
+}
+
+func runRun(m *model, args string) tea.Cmd {
+	if args == "" {
+		m.notice = "Usage: /run <command>"
+		return nil
+	}
+	run := func(m *model) tea.Cmd {
+		m.notice = "Running " + args + "..."
+		return runShell(m.id, args)
+	}
+	if err := m.config.checkDenied(args); err != nil {
+		m.notice = err.Error()
+		return nil
+	}
+	// Commands run with the user's privileges, so unless the program is
+	// explicitly allowed this always asks, even with confirmations disabled
+	if m.config.runAllowed(args) {
+		return run(m)
+	}
+	m.confirm = &confirmation{prompt: fmt.Sprintf("Run %q?", args), action: run}
+	return nil
+}
+
+// runShell runs command through the shell in the background.
+func runShell(tab int, command string) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), runTimeout)
+		defer cancel()
+		out, err := shellCommand(ctx, command).CombinedOutput()
+		return runOutputMsg{tab: tab, command: command, output: string(out), err: err}
+	}
+}
+
+// shellCommand prepares command to be run by the shell.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// commandPrograms returns the program each simple command in a command line
+// runs, and whether those are all it does. Substitutions can run anything
+// and redirections write files, so a command line with either is never
+// allowed without asking, and neither is one setting variables for a
+// command, since PATH or LD_PRELOAD change what it runs, nor one running a
+// program by path or through a variable, which could be anything.
+func commandPrograms(command string) ([]string, bool) {
+	complete := !strings.ContainsAny(command, "`>") && !strings.Contains(command, "$(") && !strings.Contains(command, "<(")
+	var programs []string
+	for _, part := range strings.FieldsFunc(command, func(r rune) bool { return strings.ContainsRune(";&|()\n", r) }) {
+		fields := strings.Fields(part)
+		// Skip variables set for the command, as in LANG=C sort, to find
+		// the program for run_denied
+		for len(fields) > 0 && strings.Contains(fields[0], "=") {
+			fields = fields[1:]
+			complete = false
+		}
+		if len(fields) > 0 {
+			program := strings.Trim(fields[0], `'"`)
+			if strings.ContainsAny(program, `/\$`) {
+				complete = false
+			}
+			programs = append(programs, program)
+		}
+	}
+	return programs, complete && len(programs) > 0
+}
+
+// runAllowed reports whether command only runs programs in run_allowed, so
+// it may run without asking.
+func (c config) runAllowed(command string) bool {
+	programs, complete := commandPrograms(command)
+	return complete && !slices.ContainsFunc(programs, func(p string) bool { return !slices.Contains(c.RunAllowed, p) })
+}
+
+// checkDenied returns an error if command runs a program in run_denied.
+func (c config) checkDenied(command string) error {
+	programs, _ := commandPrograms(command)
+	for _, p := range programs {
+		// A denied program is denied wherever it is run from
+		if slices.Contains(c.RunDenied, p) || slices.Contains(c.RunDenied, filepath.Base(p)) {
+			return fmt.Errorf("%s is not allowed to run, it is in run_denied", p)
+		}
+	}
+	return nil
+}
+
+// runShellTool runs a command for the model, once allowed, returning its
+// output with how it exited.
+func runShellTool(ctx context.Context, c config, args json.RawMessage) (string, error) {
+	command, err := shellToolCommand(args)
+	if err != nil {
+		return "", err
+	}
+	out, err := shellCommand(ctx, command).CombinedOutput()
+	output, truncated := truncateOutput(string(out))
+	lines := []string{strings.TrimRight(output, "\n")}
+	if truncated {
+		lines = append(lines, fmt.Sprintf("(output truncated to %d KB)", maxRunOutput/1024))
+	}
+	var exit *exec.ExitError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		lines = append(lines, fmt.Sprintf("(timed out after %v)", toolTimeout))
+	case ctx.Err() != nil:
+		lines = append(lines, "(stopped)")
+	case errors.As(err, &exit):
+		lines = append(lines, fmt.Sprintf("(%v)", err))
+	case err != nil:
+		return "", err
+	}
+	if lines[0] == "" {
+		lines[0] = "(no output)"
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// approveShellTool refuses a command running a denied program, and asks
+// about one that runs anything not in run_allowed.
+func approveShellTool(c config, args json.RawMessage) (string, error) {
+	command, err := shellToolCommand(args)
+	if err != nil {
+		return "", err
+	}
+	if err := c.checkDenied(command); err != nil {
+		return "", err
+	}
+	if c.runAllowed(command) {
+		return "", nil
+	}
+	return fmt.Sprintf("The model wants to run %q. Allow it?", command), nil
+}
+
+func shellToolCommand(args json.RawMessage) (string, error) {
+	var params struct {
+		Command string `json:"command"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(params.Command) == "" {
+		return "", errors.New("no command given")
+	}
+	return params.Command, nil
+}
+
+// truncateOutput cuts output to maxRunOutput, at a character boundary,
+// reporting whether it did.
+func truncateOutput(output string) (string, bool) {
+	if len(output) <= maxRunOutput {
+		return output, false
+	}
+	output = output[:maxRunOutput]
+	for !utf8.ValidString(output) {
+		output = output[:len(output)-1]
+	}
+	return output, true
+}
+
+// attachOutput keeps a command's output to be included, fenced, in the next
+// message sent in the conversation. A failing command's output is still
+// attached, since it is often what the user wants to ask about.
+func (m *model) attachOutput(msg runOutputMsg) {
+	output, truncated := truncateOutput(msg.output)
+	if output == "" && msg.err != nil {
+		m.notice = fmt.Sprintf("%s failed: %v", msg.command, msg.err)
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "$ %s\n```\n%s", msg.command, strings.TrimRight(output, "\n"))
+	b.WriteString("\n```")
+	if truncated {
+		fmt.Fprintf(&b, "\n(output truncated to %d KB)", maxRunOutput/1024)
+	}
+	if m.attachment != "" {
+		m.attachment += "\n\n"
+	}
+	m.attachment += b.String()
+
+	m.notice = fmt.Sprintf("Output of %s will be included in your next message (Esc to drop it)", msg.command)
+	if msg.err != nil {
+		m.notice = fmt.Sprintf("%s failed (%v), its output will be included in your next message", msg.command, msg.err)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// searchConfig sets up the search provider the web_search tool uses.
+type searchConfig struct {
+	Provider string `toml:"provider,omitempty"` // brave, serpapi or searxng
+	APIKey   string `toml:"api_key,omitempty"`  // otherwise BRAVE_API_KEY or SERPAPI_API_KEY
+	URL      string `toml:"url,omitempty"`      // a SearXNG instance
+	// Results is how many results are given to the model, defaulting to
+	// defaultSearchResults.
+	Results int `toml:"results,omitempty"`
+}
+
+const defaultSearchResults = 5
+
+// searchResult is a page a search found.
+type searchResult struct {
+	title   string
+	url     string
+	snippet string
+}
+
+// searchProvider runs a query with the API key, if the provider takes one,
+// returning up to n results.
+type searchProvider func(ctx context.Context, s searchConfig, key, query string, n int) ([]searchResult, error)
+
+// searchProviders are the supported search providers, by name, with the
+// variable their API key may be set in.
+var searchProviders = map[string]struct {
+	search searchProvider
+	keyEnv string
+}{
+	"brave":   {searchBrave, "BRAVE_API_KEY"},
+	"serpapi": {searchSerpAPI, "SERPAPI_API_KEY"},
+	"searxng": {searchSearXNG, ""},
+}
+
+// validate checks the search provider is known and has what it needs, if the
+// web_search tool is enabled.
+func (s searchConfig) validate(tools []string) error {
+	if s.Provider == "" {
+		if slices.Contains(tools, "web_search") {
+			return errors.New("the web_search tool needs a search provider, set provider in [search]")
+		}
+		return nil
+	}
+	p, ok := searchProviders[s.Provider]
+	if !ok {
+		return fmt.Errorf("unknown search provider %q, use brave, serpapi or searxng", s.Provider)
+	}
+	if p.keyEnv == "" && s.URL == "" {
+		return fmt.Errorf("the %s search provider needs the url of an instance in [search]", s.Provider)
+	}
+	return nil
+}
+
+// apiKey returns the search provider's API key, from the config or the
+// environment.
+func (s searchConfig) apiKey() string {
+	if s.APIKey != "" {
+		return s.APIKey
+	}
+	if env := searchProviders[s.Provider].keyEnv; env != "" {
+		return os.Getenv(env)
+	}
+	return ""
+}
+
+// webSearchTool searches the web for the model, returning a numbered line
+// for each result so the top ones are visible even when collapsed.
+func webSearchTool(ctx context.Context, c config, args json.RawMessage) (string, error) {
+	var params struct {
+		Query string `json:"query"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(params.Query) == "" {
+		return "", errors.New("no query given")
+	}
+	p, ok := searchProviders[c.Search.Provider]
+	if !ok {
+		return "", errors.New("no search provider is configured")
+	}
+	if p.keyEnv != "" && c.Search.apiKey() == "" {
+		return "", fmt.Errorf("no %s API key, set api_key in [search] or %s", c.Search.Provider, p.keyEnv)
+	}
+	n := cmp.Or(max(c.Search.Results, 0), defaultSearchResults)
+	results, err := p.search(ctx, c.Search, c.Search.apiKey(), params.Query, n)
+	if err != nil {
+		return "", err
+	}
+	if len(results) == 0 {
+		return "No results for " + strconv.Quote(params.Query), nil
+	}
+	lines := make([]string, 0, min(len(results), n))
+	for i, r := range results[:min(len(results), n)] {
+		line := fmt.Sprintf("%d. %s (%s)", i+1, r.title, r.url)
+		if r.snippet != "" {
+			line += ": " + r.snippet
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func searchBrave(ctx context.Context, s searchConfig, key, query string, n int) ([]searchResult, error) {
+	params := url.Values{"q": {query}, "count": {strconv.Itoa(n)}}
+	var resp struct {
+		Web struct {
+			Results []struct {
+				Title       string `json:"title"`
+				URL         string `json:"url"`
+				Description string `json:"description"`
+			} `json:"results"`
+		} `json:"web"`
+	}
+	header := http.Header{"X-Subscription-Token": {key}}
+	if err := getJSON(ctx, "https://api.search.brave.com/res/v1/web/search?"+params.Encode(), header, &resp); err != nil {
+		return nil, err
+	}
+	results := make([]searchResult, len(resp.Web.Results))
+	for i, r := range resp.Web.Results {
+		results[i] = searchResult{title: plainText(r.Title), url: r.URL, snippet: plainText(r.Description)}
+	}
+	return results, nil
+}
+
+func searchSerpAPI(ctx context.Context, s searchConfig, key, query string, n int) ([]searchResult, error) {
+	params := url.Values{"engine": {"google"}, "q": {query}, "num": {strconv.Itoa(n)}, "api_key": {key}}
+	var resp struct {
+		OrganicResults []struct {
+			Title   string `json:"title"`
+			Link    string `json:"link"`
+			Snippet string `json:"snippet"`
+		} `json:"organic_results"`
+		Error string `json:"error"`
+	}
+	if err := getJSON(ctx, "https://serpapi.com/search.json?"+params.Encode(), nil, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" && !strings.Contains(resp.Error, "hasn't returned any results") {
+		return nil, errors.New(resp.Error)
+	}
+	results := make([]searchResult, len(resp.OrganicResults))
+	for i, r := range resp.OrganicResults {
+		results[i] = searchResult{title: r.Title, url: r.Link, snippet: r.Snippet}
+	}
+	return results, nil
+}
+
+func searchSearXNG(ctx context.Context, s searchConfig, key, query string, n int) ([]searchResult, error) {
+	params := url.Values{"q": {query}, "format": {"json"}}
+	var resp struct {
+		Results []struct {
+			Title   string `json:"title"`
+			URL     string `json:"url"`
+			Content string `json:"content"`
+		} `json:"results"`
+	}
+	// The instance must allow the JSON format in its settings
+	if err := getJSON(ctx, strings.TrimSuffix(s.URL, "/")+"/search?"+params.Encode(), nil, &resp); err != nil {
+		return nil, err
+	}
+	results := make([]searchResult, len(resp.Results))
+	for i, r := range resp.Results {
+		results[i] = searchResult{title: r.Title, url: r.URL, snippet: plainText(r.Content)}
+	}
+	return results, nil
+}
+
+// getJSON requests a URL and decodes its JSON response into v.
+func getJSON(ctx context.Context, rawURL string, header http.Header, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	for k, values := range header {
+		req.Header[k] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("search failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// htmlTag matches the tags some providers highlight matches with.
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// plainText strips tags and entities from a snippet, and joins its lines.
+func plainText(s string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(htmlTag.ReplaceAllString(s, ""))), " ")
+}
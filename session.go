@@ -0,0 +1,327 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// session is the stored form of a conversation. Earlier versions saved it as
+// JSON, which is still read when importing those files, and it is exported and
+// imported as JSON in the same form.
+type session struct {
+	// Version is the version of the JSON format: sessionVersion when
+	// exported, unset in files saved by earlier versions.
+	Version int    `json:"version,omitempty"`
+	Title   string `json:"title,omitempty"`
+	Model   string `json:"model"`
+	System  string `json:"system,omitempty"`
+	// Summary stands in for the compacted messages in requests.
+	Summary  string           `json:"summary,omitempty"`
+	Created  time.Time        `json:"created,omitzero"`
+	Saved    time.Time        `json:"saved"`
+	Messages []sessionMessage `json:"messages"`
+	// Branches are the other paths the conversation took, each in full.
+	Branches [][]sessionMessage `json:"branches,omitempty"`
+}
+
+type sessionMessage struct {
+	Role    string    `json:"role"`
+	Content string    `json:"content"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time,omitzero"`
+	// Images are sent with a user message.
+	Images []sessionImage `json:"images,omitempty"`
+	// ToolCalls are made by a response, and a message of the role "tool"
+	// gives the result of the call ToolCallID to the tool ToolName.
+	ToolCalls  []sessionToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string            `json:"tool_call_id,omitempty"`
+	ToolName   string            `json:"tool_name,omitempty"`
+	// Sources are the indexed documents a response was given, as cited
+	// under it.
+	Sources []string `json:"sources,omitempty"`
+	// Reasoning is the thinking a reasoning model did before responding.
+	Reasoning string `json:"reasoning,omitempty"`
+	// Model is set on a response from a model other than the session's.
+	Model string        `json:"model,omitempty"`
+	Usage *sessionUsage `json:"usage,omitempty"`
+	// Interrupted is the error that cut a response off.
+	Interrupted string `json:"interrupted,omitempty"`
+	// JSON marks a response requested in JSON mode, and JSONError says why
+	// it is not valid JSON or does not follow its schema.
+	JSON      bool   `json:"json,omitempty"`
+	JSONError string `json:"json_error,omitempty"`
+	// Compacted marks a message the summary stands in for.
+	Compacted bool `json:"compacted,omitempty"`
+}
+
+// sessionUsage is the stored form of what the request for a response used.
+type sessionUsage struct {
+	Prompt     int64   `json:"prompt"`
+	Completion int64   `json:"completion"`
+	Cost       float64 `json:"cost,omitempty"`
+	Priced     bool    `json:"priced,omitempty"`
+}
+
+// sessionToolCall is the stored form of a tool call. Its arguments are a JSON
+// object in a string, as models write them.
+type sessionToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+// sessionImage is the stored form of an image. Its data is base64 encoded in
+// JSON.
+type sessionImage struct {
+	Name string `json:"name"`
+	MIME string `json:"mime"`
+	Data []byte `json:"data"`
+}
+
+// dataDir returns the directory application data is kept in,
+// ~/.local/share/llmtui unless XDG_DATA_HOME says otherwise.
+func dataDir() (string, error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dataHome, "llmtui"), nil
+}
+
+// sessionsDir returns the directory earlier versions saved sessions in.
+func sessionsDir() (string, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "sessions"), nil
+}
+
+// validateName checks that a user supplied name is usable as a file name.
+func validateName(name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return fmt.Errorf("invalid name %q", name)
+	}
+	return nil
+}
+
+// defaultSessionName names a session after its title, or the current time
+// for untitled conversations.
+func defaultSessionName(title string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(title) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		case b.Len() > 0 && !strings.HasSuffix(b.String(), "-"):
+			b.WriteByte('-')
+		}
+	}
+	if name := strings.TrimSuffix(b.String(), "-"); name != "" {
+		return name
+	}
+	return time.Now().Format("2006-01-02-150405")
+}
+
+// newSession captures a conversation for saving. It was created when its
+// first message was sent.
+func newSession(title, modelName, systemPrompt, summary string, messages []chatMessage, branches [][]chatMessage) session {
+	s := session{Title: title, Model: modelName, System: systemPrompt, Summary: summary, Saved: time.Now(), Messages: sessionMessages(messages)}
+	for _, b := range branches {
+		s.Branches = append(s.Branches, sessionMessages(b))
+	}
+	if len(messages) > 0 {
+		s.Created = messages[0].sent
+	}
+	return s
+}
+
+func sessionMessages(messages []chatMessage) []sessionMessage {
+	saved := make([]sessionMessage, len(messages))
+	for i, msg := range messages {
+		saved[i] = sessionMessage{
+			Role: msg.role, Content: msg.content, Time: msg.sent,
+			ToolCallID: msg.toolCallID, ToolName: msg.toolName, Sources: msg.sources, Reasoning: msg.reasoning,
+			Model: msg.model, JSON: msg.json, Compacted: msg.compacted,
+		}
+		if msg.usage.tokens() > 0 {
+			saved[i].Usage = &sessionUsage{Prompt: msg.usage.prompt, Completion: msg.usage.completion, Cost: msg.usage.cost, Priced: msg.usage.priced}
+		}
+		if msg.interrupted != nil {
+			saved[i].Interrupted = msg.interrupted.Error()
+		}
+		if msg.jsonErr != nil {
+			saved[i].JSONError = msg.jsonErr.Error()
+		}
+		for _, call := range msg.toolCalls {
+			saved[i].ToolCalls = append(saved[i].ToolCalls, sessionToolCall{ID: call.id, Name: call.name, Arguments: call.arguments})
+		}
+		if msg.err != nil {
+			saved[i].Error = msg.err.Error()
+		}
+		for _, img := range msg.images {
+			saved[i].Images = append(saved[i].Images, sessionImage{Name: img.name, MIME: img.mime, Data: img.data})
+		}
+	}
+	return saved
+}
+
+// unusedSessionName returns name, or name with a number appended if a
+// session of that name already exists.
+func unusedSessionName(name string) string {
+	candidate := name
+	for i := 2; ; i++ {
+		if exists, err := sessionExists(candidate); err != nil || !exists {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s-%d", name, i)
+	}
+}
+
+// autosaveMsg reports an autosave that failed.
+type autosaveMsg struct{ err error }
+
+// autosaves are the snapshots of sessions waiting to be saved in the
+// background, only the latest of each being kept. saving is held while they
+// are written, so they are written one at a time and in order.
+var autosaves struct {
+	sync.Mutex
+	pending map[string]session
+	saving  sync.Mutex
+}
+
+// autosave saves the conversation as its session in the background, naming a
+// new one after it the first time, unless autosaving is disabled. Failures
+// are only reported since the conversation itself is unaffected.
+func (m *model) autosave() tea.Cmd {
+	if m.config.DisableAutosave || len(m.messages) == 0 {
+		return nil
+	}
+	if m.sessionName == "" {
+		m.sessionName = unusedSessionName(defaultSessionName(m.title))
+	}
+	autosaves.Lock()
+	if autosaves.pending == nil {
+		autosaves.pending = make(map[string]session)
+	}
+	autosaves.pending[m.sessionName] = m.session()
+	autosaves.Unlock()
+	return func() tea.Msg {
+		if err := flushAutosaves(); err != nil {
+			return autosaveMsg{err}
+		}
+		return nil
+	}
+}
+
+// flushAutosaves saves the sessions waiting to be autosaved, returning the
+// first error.
+func flushAutosaves() error {
+	autosaves.saving.Lock()
+	defer autosaves.saving.Unlock()
+	autosaves.Lock()
+	pending := autosaves.pending
+	autosaves.pending = nil
+	autosaves.Unlock()
+	var errs []error
+	for name, s := range pending {
+		errs = append(errs, saveSession(name, s))
+	}
+	return errors.Join(errs...)
+}
+
+// restoreSession replaces the conversation with a saved session, which it
+// continues to be saved as, along with the model it was having.
+func (m *model) restoreSession(name string, s session) {
+	m.messages = chatMessages(s.Messages)
+	m.branches = nil
+	for _, b := range s.Branches {
+		m.branches = append(m.branches, chatMessages(b))
+	}
+	m.title = s.Title
+	if s.Model != "" {
+		m.modelName = s.Model
+	}
+	if s.System != "" {
+		m.systemPrompt = s.System
+	}
+	m.selected = -1
+	m.firstVisible = 0
+	m.lastStats = ""
+	m.spent = usage{}
+	for _, msg := range m.messages {
+		m.spent = m.spent.add(msg.usage)
+	}
+	m.summary = s.Summary
+	m.editing = -1
+	m.sessionName = name
+}
+
+// chatMessages converts saved messages back into conversation turns.
+func chatMessages(saved []sessionMessage) []chatMessage {
+	messages := make([]chatMessage, len(saved))
+	for i, msg := range saved {
+		messages[i] = chatMessage{
+			role: msg.Role, content: msg.Content, sent: msg.Time,
+			toolCallID: msg.ToolCallID, toolName: msg.ToolName, sources: msg.Sources, reasoning: msg.Reasoning,
+			model: msg.Model, json: msg.JSON, compacted: msg.Compacted,
+		}
+		if u := msg.Usage; u != nil {
+			messages[i].usage = usage{prompt: u.Prompt, completion: u.Completion, cost: u.Cost, priced: u.Priced}
+		}
+		if msg.Interrupted != "" {
+			messages[i].interrupted = errors.New(msg.Interrupted)
+		}
+		switch msg.JSONError {
+		case "":
+		case errNotJSON.Error():
+			messages[i].jsonErr = errNotJSON
+		default:
+			messages[i].jsonErr = errors.New(msg.JSONError)
+		}
+		for _, call := range msg.ToolCalls {
+			messages[i].toolCalls = append(messages[i].toolCalls, toolCall{id: call.ID, name: call.Name, arguments: call.Arguments})
+		}
+		// Tool results are long and seldom read
+		messages[i].collapsed = msg.Role == "tool"
+		if msg.Error != "" {
+			messages[i].err = errors.New(msg.Error)
+		}
+		for _, img := range msg.Images {
+			messages[i].images = append(messages[i].images, attachedImage{name: img.Name, mime: img.MIME, data: img.Data})
+		}
+	}
+	return messages
+}
+
+// resumeLatest reopens the most recently saved session, if there is one.
+func (m *model) resumeLatest() {
+	names, err := recentSessions()
+	if err != nil {
+		m.notice = err.Error()
+		return
+	}
+	if len(names) == 0 {
+		m.notice = "No saved sessions to resume"
+		return
+	}
+	s, err := loadSession(names[0])
+	if err != nil {
+		m.notice = err.Error()
+		return
+	}
+	m.restoreSession(names[0], s)
+	m.notice = "Resumed session " + names[0]
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// The first-run setup walks a new user through choosing a provider, entering
+// an API key and picking a default model, then saves the answers as the
+// "default" profile in a new config file.
+
+const setupProfileName = "default"
+
+func (m *model) startSetup() {
+	m.setup = &profile{}
+	m.prompt = &textPrompt{
+		label:    "Provider (openai, anthropic, gemini, ollama, or the base URL of an OpenAI-compatible server): ",
+		value:    "openai",
+		onSubmit: setupProvider,
+	}
+}
+
+func setupProvider(m *model, value string) tea.Cmd {
+	switch {
+	case value == "" || value == "openai":
+		m.setup.Provider = "openai"
+	case value == "anthropic" || value == "gemini":
+		m.setup.Provider = value
+	case value == "ollama":
+		// A local server needs no key
+		m.setup.Provider = value
+		return setupAPIKey(m, "")
+	case strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://"):
+		m.setup.Provider = "openai"
+		m.setup.BaseURL = value
+	default:
+		m.notice = "Enter openai, anthropic, gemini, ollama or a URL starting with http:// or https://"
+		m.startSetup()
+		return nil
+	}
+	m.prompt = &textPrompt{label: "API key: ", mask: true, onSubmit: setupAPIKey}
+	return nil
+}
+
+func setupAPIKey(m *model, value string) tea.Cmd {
+	// Local OpenAI-compatible servers often do not check the key
+	if value == "" && m.setup.BaseURL == "" && backends[m.setup.Provider].keyEnv != "" {
+		m.notice = "An API key is required for " + m.setup.Provider
+		m.prompt = &textPrompt{label: "API key: ", mask: true, onSubmit: setupAPIKey}
+		return nil
+	}
+	if value == "" && m.setup.BaseURL != "" {
+		value = "none"
+	}
+	m.setup.APIKey = value
+	m.prompt = &textPrompt{label: "Default model: ", value: backends[m.setup.Provider].defaultModel, onSubmit: setupModel}
+	return nil
+}
+
+func setupModel(m *model, value string) tea.Cmd {
+	if value == "" {
+		value = backends[m.setup.Provider].defaultModel
+	}
+	m.setup.Model = value
+
+	cfg := m.config
+	cfg.DefaultProfile = setupProfileName
+	cfg.Profiles = map[string]profile{setupProfileName: *m.setup}
+	path, err := saveConfig(cfg)
+	if err != nil {
+		m.err = err
+		return nil
+	}
+	m.config = cfg
+	m.setup = nil
+	if err := m.useProfile(setupProfileName); err != nil {
+		m.err = err
+		return nil
+	}
+	m.rememberModel()
+	m.notice = "Saved configuration to " + path
+	return nil
+}
+
+func (m model) setupView() string {
+	var b strings.Builder
+	b.WriteString(titleStyle.Render("Welcome to LLM TUI Chat"))
+	b.WriteString("\n")
+	b.WriteString("No configuration was found, so let's set one up. Press Enter to accept\n")
+	b.WriteString("a suggested value or Esc to quit.\n\n")
+	if m.prompt != nil {
+		b.WriteString(m.prompt.render())
+		b.WriteString("\n\n")
+	}
+	if m.notice != "" {
+		b.WriteString(errorStyle.Render(m.notice))
+		b.WriteString("\n")
+	}
+	b.WriteString(helpStyle.Render(fmt.Sprintf("Answers are saved as the %q profile.", setupProfileName)))
+	return b.String()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// codeBlock is a fenced code block in one of the responses, numbered across
+// the conversation by /code.
+type codeBlock struct {
+	lang    string
+	text    string
+	message int // index of the response it is in
+}
+
+// codePreviewLength is how much of its first line a code block is listed by.
+const codePreviewLength = 50
+
+// codeBlocks returns the fenced code blocks of every response, in order.
+func (m model) codeBlocks() []codeBlock {
+	var blocks []codeBlock
+	for i, msg := range m.messages {
+		if msg.role != "assistant" || msg.err != nil {
+			continue
+		}
+		for _, part := range splitFences(msg.content) {
+			if part.code {
+				blocks = append(blocks, codeBlock{lang: part.lang, text: part.text, message: i})
+			}
+		}
+	}
+	return blocks
+}
+
+func runCode(m *model, args string) tea.Cmd {
+	blocks := m.codeBlocks()
+	if len(blocks) == 0 {
+		m.notice = "No code blocks in the conversation"
+		return nil
+	}
+	switch args {
+	case "":
+		// Only the selected or last response's blocks, keeping their numbers
+		target := m.targetMessage()
+		if !slices.ContainsFunc(blocks, func(b codeBlock) bool { return b.message == target }) {
+			m.notice = "The response has no code blocks, /code all lists every one"
+			return nil
+		}
+		m.palette = &palette{entries: codeEntries(target), noMatches: "No matching code blocks", verb: "copy"}
+		return nil
+	case "all":
+		m.palette = &palette{entries: codeEntries(-1), noMatches: "No matching code blocks", verb: "copy"}
+		return nil
+	}
+
+	number, path, _ := strings.Cut(args, " ")
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 1 || n > len(blocks) {
+		m.notice = fmt.Sprintf("Usage: /code [all|<1-%d> [file]]", len(blocks))
+		return nil
+	}
+	if path = strings.TrimSpace(path); path == "" {
+		m.notice = fmt.Sprintf("Copied code block %d to %s", n, writeClipboard(blocks[n-1].text))
+		return nil
+	}
+	return m.writeCodeBlock(n, blocks[n-1], path)
+}
+
+// codeEntries lists the code blocks of the response at index message, or of
+// every response if it is negative.
+func codeEntries(message int) func(m model) []paletteEntry {
+	return func(m model) []paletteEntry {
+		var entries []paletteEntry
+		for i, b := range m.codeBlocks() {
+			if message >= 0 && b.message != message {
+				continue
+			}
+			n := i + 1
+			entries = append(entries, paletteEntry{label: codeLabel(n, b), help: codeHelp(b), run: func(m *model) tea.Cmd {
+				m.notice = fmt.Sprintf("Copied code block %d to %s", n, writeClipboard(b.text))
+				return nil
+			}})
+		}
+		return entries
+	}
+}
+
+// codeLabel describes block n by its language and first line.
+func codeLabel(n int, b codeBlock) string {
+	lang := b.lang
+	if lang == "" {
+		lang = "text"
+	}
+	var first string
+	for _, line := range strings.Split(b.text, "\n") {
+		if first = strings.TrimSpace(line); first != "" {
+			break
+		}
+	}
+	if len([]rune(first)) > codePreviewLength {
+		first = string([]rune(first)[:codePreviewLength]) + "…"
+	}
+	return fmt.Sprintf("%d %s: %s", n, lang, first)
+}
+
+func codeHelp(b codeBlock) string {
+	lines := strings.Count(b.text, "\n") + 1
+	if lines == 1 {
+		return "1 line"
+	}
+	return fmt.Sprintf("%d lines", lines)
+}
+
+// writeCodeBlock saves block n to path, asking first if that replaces a file.
+func (m *model) writeCodeBlock(n int, b codeBlock, path string) tea.Cmd {
+	path = expandHome(path)
+	write := func(m *model) tea.Cmd {
+		text := b.text
+		if !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		if err := os.WriteFile(path, []byte(text), 0o644); err != nil {
+			m.notice = err.Error()
+			return nil
+		}
+		m.notice = fmt.Sprintf("Wrote code block %d to %s", n, path)
+		return nil
+	}
+	if _, err := os.Stat(path); err == nil {
+		return m.confirmAction(path+" exists. Replace it?", false, write)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		m.notice = err.Error()
+		return nil
+	}
+	return write(m)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// statusBarStyle is the bar at the bottom of the screen, filled edge to edge
+// so it stands apart from the conversation.
+var statusBarStyle = lipgloss.NewStyle().
+	Foreground(lipgloss.Color("#E5E7EB")).
+	Background(lipgloss.Color("#374151"))
+
+// statusBar renders the bar at the bottom of the screen: the model and its
+// provider, the session, how full the context window is and how much has
+// been spent on the left, and the state of the connection on the right.
+func (m model) statusBar() string {
+	var left []string
+	if len(m.comparing) > 0 {
+		left = append(left, strings.Join(m.comparing, " vs "))
+	} else {
+		left = append(left, m.modelName)
+	}
+	provider := providerName(m.provider)
+	if m.profile != "" {
+		provider += " (" + m.profile + ")"
+	}
+	left = append(left, provider)
+	if m.sessionName != "" {
+		left = append(left, "Session: "+m.sessionName)
+	} else {
+		left = append(left, "Not saved")
+	}
+	window := m.config.contextWindow(m.modelName)
+	used := m.contextTokens()
+	left = append(left, fmt.Sprintf("Context: %s/%s (%d%%)", formatTokens(used), formatTokens(window), used*100/window))
+	if m.spent.tokens() > 0 {
+		spent := "Spent: " + formatTokens(int(m.spent.tokens())) + " tokens"
+		if m.spent.priced {
+			spent += " (" + formatCost(m.spent.cost) + ")"
+		}
+		left = append(left, spent)
+	}
+
+	right := m.connectionState() + " · ? for help"
+	bar := " " + strings.Join(left, " · ")
+	// The state is kept in view, cutting the rest short if need be
+	if m.width > 0 {
+		bar = truncateLine(bar, max(m.width-lipgloss.Width(right)-2, 0))
+		bar += strings.Repeat(" ", max(m.width-lipgloss.Width(bar)-lipgloss.Width(right)-1, 1))
+	} else {
+		bar += "  "
+	}
+	return statusBarStyle.Render(bar + right + " ")
+}
+
+// connectionState describes what the conversation's request is doing, or how
+// the last one ended.
+func (m model) connectionState() string {
+	switch {
+	case m.loading && m.reconnecting > 0:
+		return fmt.Sprintf("↻ Reconnecting (%d of %d)", m.reconnecting, maxReconnects)
+	case m.loading && m.streaming:
+		return "● Streaming"
+	case m.loading:
+		return "○ Waiting"
+	case len(m.messages) > 0 && m.messages[len(m.messages)-1].err != nil:
+		return "✕ Last request failed"
+	}
+	return "● Ready"
+}
+
+// contextTokens estimates how much of the context window the conversation
+// takes: what the provider reported the last response's request used, plus
+// estimates for anything after it, or estimates for all of it when nothing
+// was reported.
+func (m model) contextTokens() int {
+	tokens := 0
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		msg := m.messages[i]
+		if msg.usage.tokens() > 0 {
+			return tokens + int(msg.usage.tokens())
+		}
+		if msg.err == nil && !msg.compacted {
+			tokens += estimateTokens(message{content: msg.content, images: msg.images})
+		}
+	}
+	if m.summary != "" {
+		tokens += utf8.RuneCountInString(m.summary) / 4
+	}
+	return tokens + utf8.RuneCountInString(m.systemPrompt)/4
+}
+
+// providerName names the kind of provider p is, as in a profile.
+func providerName(p provider) string {
+	switch p := p.(type) {
+	case *openaiProvider:
+		if p.azureEndpoint != "" {
+			return "azure"
+		}
+		return "openai"
+	case *anthropicProvider:
+		return "anthropic"
+	case *geminiProvider:
+		return "gemini"
+	case *ollamaProvider:
+		return "ollama"
+	}
+	return "no provider"
+}
+
+// formatTokens shortens a token count to thousands or millions.
+func formatTokens(n int) string {
+	switch {
+	case n >= 1_000_000:
+		return strings.TrimSuffix(fmt.Sprintf("%.1f", float64(n)/1e6), ".0") + "M"
+	case n >= 1000:
+		return strings.TrimSuffix(fmt.Sprintf("%.1f", float64(n)/1e3), ".0") + "k"
+	}
+	return fmt.Sprint(n)
+}
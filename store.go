@@ -0,0 +1,654 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Sessions are stored in a SQLite database in the data directory. Sessions
+// saved as JSON files by earlier versions are imported the first time it is
+// opened.
+
+const storeSchema = `
+CREATE TABLE IF NOT EXISTS sessions (
+	id      INTEGER PRIMARY KEY,
+	name    TEXT NOT NULL UNIQUE,
+	title   TEXT NOT NULL DEFAULT '',
+	model   TEXT NOT NULL DEFAULT '',
+	system  TEXT NOT NULL DEFAULT '',
+	summary TEXT NOT NULL DEFAULT '',
+	created TEXT NOT NULL DEFAULT '',
+	saved   TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS sessions_saved ON sessions (saved);
+CREATE TABLE IF NOT EXISTS messages (
+	session_id INTEGER NOT NULL REFERENCES sessions (id) ON DELETE CASCADE,
+	position   INTEGER NOT NULL,
+	role       TEXT NOT NULL,
+	content    TEXT NOT NULL,
+	error      TEXT NOT NULL DEFAULT '',
+	time       TEXT NOT NULL DEFAULT '',
+	tools      TEXT NOT NULL DEFAULT '',
+	sources    TEXT NOT NULL DEFAULT '',
+	reasoning  TEXT NOT NULL DEFAULT '',
+	details    TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (session_id, position)
+);
+CREATE TABLE IF NOT EXISTS branch_messages (
+	session_id INTEGER NOT NULL REFERENCES sessions (id) ON DELETE CASCADE,
+	branch     INTEGER NOT NULL,
+	position   INTEGER NOT NULL,
+	role       TEXT NOT NULL,
+	content    TEXT NOT NULL,
+	error      TEXT NOT NULL DEFAULT '',
+	time       TEXT NOT NULL DEFAULT '',
+	tools      TEXT NOT NULL DEFAULT '',
+	sources    TEXT NOT NULL DEFAULT '',
+	reasoning  TEXT NOT NULL DEFAULT '',
+	details    TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (session_id, branch, position)
+);
+CREATE TABLE IF NOT EXISTS message_images (
+	session_id INTEGER NOT NULL REFERENCES sessions (id) ON DELETE CASCADE,
+	branch     INTEGER NOT NULL, -- -1 for the messages of the session itself
+	position   INTEGER NOT NULL,
+	number     INTEGER NOT NULL,
+	name       TEXT NOT NULL,
+	mime       TEXT NOT NULL,
+	data       BLOB NOT NULL,
+	PRIMARY KEY (session_id, branch, position, number)
+);
+CREATE TABLE IF NOT EXISTS chunks (
+	root       TEXT NOT NULL, -- the folder indexed
+	path       TEXT NOT NULL,
+	first_line INTEGER NOT NULL,
+	last_line  INTEGER NOT NULL,
+	content    TEXT NOT NULL,
+	model      TEXT NOT NULL,
+	embedding  BLOB NOT NULL -- little-endian float32s
+);
+CREATE INDEX IF NOT EXISTS chunks_root ON chunks (root);
+CREATE TABLE IF NOT EXISTS memories (
+	id      INTEGER PRIMARY KEY,
+	content TEXT NOT NULL,
+	created TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS prompts (
+	id      INTEGER PRIMARY KEY,
+	content TEXT NOT NULL,
+	sent    TEXT NOT NULL DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS metadata (
+	key   TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+`
+
+// storeColumns are the columns added to tables after they were first
+// released, which databases created before are migrated to have.
+var storeColumns = []struct{ table, column, definition string }{
+	{"messages", "tools", "TEXT NOT NULL DEFAULT ''"},
+	{"branch_messages", "tools", "TEXT NOT NULL DEFAULT ''"},
+	{"messages", "sources", "TEXT NOT NULL DEFAULT ''"},
+	{"branch_messages", "sources", "TEXT NOT NULL DEFAULT ''"},
+	{"messages", "reasoning", "TEXT NOT NULL DEFAULT ''"},
+	{"branch_messages", "reasoning", "TEXT NOT NULL DEFAULT ''"},
+	{"sessions", "summary", "TEXT NOT NULL DEFAULT ''"},
+	{"messages", "details", "TEXT NOT NULL DEFAULT ''"},
+	{"branch_messages", "details", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// migrateStore adds any of storeColumns a table is missing.
+func migrateStore(db *sql.DB) error {
+	for _, c := range storeColumns {
+		var exists bool
+		err := db.QueryRow(`SELECT count(*) > 0 FROM pragma_table_info(?) WHERE name = ?`, c.table, c.column).Scan(&exists)
+		if err == nil && !exists {
+			_, err = db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, c.table, c.column, c.definition))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// messageTools holds a message's tool calls, or the call it gives the result
+// of, as stored in the tools column in JSON.
+type messageTools struct {
+	ToolCalls  []sessionToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string            `json:"tool_call_id,omitempty"`
+	ToolName   string            `json:"tool_name,omitempty"`
+}
+
+// encodeTools returns the tools column of msg, empty if it has nothing to do
+// with tools.
+func encodeTools(msg sessionMessage) string {
+	if len(msg.ToolCalls) == 0 && msg.ToolCallID == "" && msg.ToolName == "" {
+		return ""
+	}
+	data, _ := json.Marshal(messageTools{ToolCalls: msg.ToolCalls, ToolCallID: msg.ToolCallID, ToolName: msg.ToolName})
+	return string(data)
+}
+
+// decodeTools fills in the tool fields of msg from its tools column.
+func decodeTools(column string, msg *sessionMessage) {
+	var tools messageTools
+	if column == "" || json.Unmarshal([]byte(column), &tools) != nil {
+		return
+	}
+	msg.ToolCalls, msg.ToolCallID, msg.ToolName = tools.ToolCalls, tools.ToolCallID, tools.ToolName
+}
+
+// messageDetails holds how a response came about and how it ended, as stored
+// in the details column in JSON.
+type messageDetails struct {
+	Model       string        `json:"model,omitempty"`
+	Usage       *sessionUsage `json:"usage,omitempty"`
+	Interrupted string        `json:"interrupted,omitempty"`
+	JSON        bool          `json:"json,omitempty"`
+	JSONError   string        `json:"json_error,omitempty"`
+	Compacted   bool          `json:"compacted,omitempty"`
+}
+
+// encodeDetails returns the details column of msg, empty if there are none.
+func encodeDetails(msg sessionMessage) string {
+	details := messageDetails{Model: msg.Model, Usage: msg.Usage, Interrupted: msg.Interrupted, JSON: msg.JSON, JSONError: msg.JSONError, Compacted: msg.Compacted}
+	if details == (messageDetails{}) {
+		return ""
+	}
+	data, _ := json.Marshal(details)
+	return string(data)
+}
+
+// decodeDetails fills in the details of msg from its details column.
+func decodeDetails(column string, msg *sessionMessage) {
+	var details messageDetails
+	if column == "" || json.Unmarshal([]byte(column), &details) != nil {
+		return
+	}
+	msg.Model, msg.Usage, msg.Interrupted = details.Model, details.Usage, details.Interrupted
+	msg.JSON, msg.JSONError, msg.Compacted = details.JSON, details.JSONError, details.Compacted
+}
+
+// decodeSources splits the sources column of a message, which lists them a
+// line each.
+func decodeSources(column string) []string {
+	if column == "" {
+		return nil
+	}
+	return strings.Split(column, "\n")
+}
+
+// errSessionNotFound is returned for a session name that is not stored.
+var errSessionNotFound = errors.New("no such session")
+
+var (
+	storeOnce sync.Once
+	storeDB   *sql.DB
+	storeErr  error
+)
+
+// openStore opens the session database, creating and migrating it on first
+// use. The connection is shared for the life of the program.
+func openStore() (*sql.DB, error) {
+	storeOnce.Do(func() {
+		storeDB, storeErr = initStore()
+	})
+	return storeDB, storeErr
+}
+
+func initStore() (*sql.DB, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return nil, err
+	}
+	// The history holds whole conversations, which are no one else's
+	// business
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("open history: %w", err)
+	}
+	path := filepath.Join(dir, "history.db")
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("open history: %w", err)
+	}
+	if _, err := db.Exec(storeSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open history %s: %w", path, err)
+	}
+	if err := migrateStore(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open history %s: %w", path, err)
+	}
+	if err := importSessionFiles(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// importSessionFiles copies sessions saved as JSON files into the database,
+// once. The files are left in place.
+func importSessionFiles(db *sql.DB) error {
+	var done string
+	err := db.QueryRow(`SELECT value FROM metadata WHERE key = 'imported_files'`).Scan(&done)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("open history: %w", err)
+	}
+
+	dir, err := sessionsDir()
+	if err != nil {
+		return err
+	}
+	names, err := listNames(dir, ".json")
+	if err != nil {
+		return fmt.Errorf("import sessions: %w", err)
+	}
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(dir, name+".json"))
+		if err != nil {
+			return fmt.Errorf("import session %s: %w", name, err)
+		}
+		var s session
+		if err := json.Unmarshal(data, &s); err != nil {
+			// A damaged file should not keep every other session out
+			continue
+		}
+		if err := storeSession(db, name, s, false); err != nil {
+			return fmt.Errorf("import session %s: %w", name, err)
+		}
+	}
+	if _, err := db.Exec(`INSERT INTO metadata (key, value) VALUES ('imported_files', ?)`, formatTime(time.Now())); err != nil {
+		return fmt.Errorf("import sessions: %w", err)
+	}
+	return nil
+}
+
+// storeSession writes s under name, replacing any session of that name
+// unless replace is false, in which case an existing one is kept. Only the
+// rows that differ from those stored are written, since a session is saved
+// after every response and mostly grows at the end.
+func storeSession(db *sql.DB, name string, s session, replace bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRow(`SELECT id FROM sessions WHERE name = ?`, name).Scan(&id)
+	switch {
+	case err == nil && !replace:
+		return nil
+	case err == nil:
+		_, err = tx.Exec(`UPDATE sessions SET title = ?, model = ?, system = ?, summary = ?, created = ?, saved = ? WHERE id = ?`,
+			s.Title, s.Model, s.System, s.Summary, formatTime(s.Created), formatTime(s.Saved), id)
+	case errors.Is(err, sql.ErrNoRows):
+		var res sql.Result
+		res, err = tx.Exec(`INSERT INTO sessions (name, title, model, system, summary, created, saved) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			name, s.Title, s.Model, s.System, s.Summary, formatTime(s.Created), formatTime(s.Saved))
+		if err == nil {
+			id, err = res.LastInsertId()
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	upsert, err := tx.Prepare(upsertMessage("messages", "session_id, position"))
+	if err != nil {
+		return err
+	}
+	defer upsert.Close()
+	for i, msg := range s.Messages {
+		if _, err := upsert.Exec(append([]any{id, i}, messageValues(msg)...)...); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM messages WHERE session_id = ? AND position >= ?`, id, len(s.Messages)); err != nil {
+		return err
+	}
+	upsertBranch, err := tx.Prepare(upsertMessage("branch_messages", "session_id, branch, position"))
+	if err != nil {
+		return err
+	}
+	defer upsertBranch.Close()
+	for b, messages := range s.Branches {
+		for i, msg := range messages {
+			if _, err := upsertBranch.Exec(append([]any{id, b, i}, messageValues(msg)...)...); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(`DELETE FROM branch_messages WHERE session_id = ? AND branch = ? AND position >= ?`, id, b, len(messages)); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM branch_messages WHERE session_id = ? AND branch >= ?`, id, len(s.Branches)); err != nil {
+		return err
+	}
+
+	upsertImage, err := tx.Prepare(`INSERT INTO message_images (session_id, branch, position, number, name, mime, data) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (session_id, branch, position, number) DO UPDATE SET name = excluded.name, mime = excluded.mime, data = excluded.data
+		WHERE (name, mime, data) <> (excluded.name, excluded.mime, excluded.data)`)
+	if err != nil {
+		return err
+	}
+	defer upsertImage.Close()
+	dropImages, err := tx.Prepare(`DELETE FROM message_images WHERE session_id = ? AND branch = ? AND position = ? AND number >= ?`)
+	if err != nil {
+		return err
+	}
+	defer dropImages.Close()
+	for b, messages := range append([][]sessionMessage{s.Messages}, s.Branches...) {
+		// The session's own messages are branch -1
+		for i, msg := range messages {
+			for n, img := range msg.Images {
+				if _, err := upsertImage.Exec(id, b-1, i, n, img.Name, img.MIME, img.Data); err != nil {
+					return err
+				}
+			}
+			if _, err := dropImages.Exec(id, b-1, i, len(msg.Images)); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(`DELETE FROM message_images WHERE session_id = ? AND branch = ? AND position >= ?`, id, b-1, len(messages)); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM message_images WHERE session_id = ? AND branch >= ?`, id, len(s.Branches)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// messageColumns are the columns of messages and branch_messages that hold
+// the message itself, as messageValues returns them.
+var messageColumns = []string{"role", "content", "error", "time", "tools", "sources", "reasoning", "details"}
+
+// messageValues returns the values of messageColumns for msg.
+func messageValues(msg sessionMessage) []any {
+	return []any{msg.Role, msg.Content, msg.Error, formatTime(msg.Time), encodeTools(msg), strings.Join(msg.Sources, "\n"), msg.Reasoning, encodeDetails(msg)}
+}
+
+// upsertMessage returns a statement storing a message in table at the key
+// columns given, followed by messageColumns, which leaves a stored row that
+// is the same untouched.
+func upsertMessage(table, key string) string {
+	columns := strings.Join(messageColumns, ", ")
+	set := make([]string, len(messageColumns))
+	excluded := make([]string, len(messageColumns))
+	for i, c := range messageColumns {
+		set[i] = c + " = excluded." + c
+		excluded[i] = "excluded." + c
+	}
+	placeholders := strings.Repeat("?, ", strings.Count(key, ",")+len(messageColumns)) + "?"
+	return fmt.Sprintf(`INSERT INTO %s (%s, %s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s WHERE (%s) <> (%s)`,
+		table, key, columns, placeholders, key, strings.Join(set, ", "), columns, strings.Join(excluded, ", "))
+}
+
+// saveSession stores s under name, replacing any session of that name.
+func saveSession(name string, s session) error {
+	if err := validateName(name); err != nil {
+		return err
+	}
+	db, err := openStore()
+	if err != nil {
+		return err
+	}
+	if err := storeSession(db, name, s, true); err != nil {
+		return fmt.Errorf("save session: %w", err)
+	}
+	return nil
+}
+
+// loadSession reads the named session.
+func loadSession(name string) (session, error) {
+	var s session
+	db, err := openStore()
+	if err != nil {
+		return s, err
+	}
+	var id int64
+	var created, saved string
+	err = db.QueryRow(`SELECT id, title, model, system, summary, created, saved FROM sessions WHERE name = ?`, name).
+		Scan(&id, &s.Title, &s.Model, &s.System, &s.Summary, &created, &saved)
+	if errors.Is(err, sql.ErrNoRows) {
+		return s, fmt.Errorf("load session %s: %w", name, errSessionNotFound)
+	}
+	if err != nil {
+		return s, fmt.Errorf("load session %s: %w", name, err)
+	}
+	s.Created, s.Saved = parseTime(created), parseTime(saved)
+
+	rows, err := db.Query(`SELECT role, content, error, time, tools, sources, reasoning, details FROM messages WHERE session_id = ? ORDER BY position`, id)
+	if err != nil {
+		return s, fmt.Errorf("load session %s: %w", name, err)
+	}
+	defer rows.Close()
+	s.Messages = []sessionMessage{}
+	for rows.Next() {
+		var msg sessionMessage
+		var sent, tools, sources, details string
+		if err := rows.Scan(&msg.Role, &msg.Content, &msg.Error, &sent, &tools, &sources, &msg.Reasoning, &details); err != nil {
+			return s, fmt.Errorf("load session %s: %w", name, err)
+		}
+		msg.Time = parseTime(sent)
+		decodeTools(tools, &msg)
+		decodeDetails(details, &msg)
+		msg.Sources = decodeSources(sources)
+		s.Messages = append(s.Messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return s, fmt.Errorf("load session %s: %w", name, err)
+	}
+	if s.Branches, err = loadBranches(db, id); err != nil {
+		return s, fmt.Errorf("load session %s: %w", name, err)
+	}
+	if err := loadImages(db, id, &s); err != nil {
+		return s, fmt.Errorf("load session %s: %w", name, err)
+	}
+	return s, nil
+}
+
+// loadImages reads the images of the messages of s, the session with the
+// given id, and of its branches.
+func loadImages(db *sql.DB, id int64, s *session) error {
+	rows, err := db.Query(`SELECT branch, position, name, mime, data FROM message_images WHERE session_id = ? ORDER BY branch, position, number`, id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b, i int
+		var img sessionImage
+		if err := rows.Scan(&b, &i, &img.Name, &img.MIME, &img.Data); err != nil {
+			return err
+		}
+		messages := s.Messages
+		if b >= 0 && b < len(s.Branches) {
+			messages = s.Branches[b]
+		} else if b >= 0 {
+			continue
+		}
+		if i < len(messages) {
+			messages[i].Images = append(messages[i].Images, img)
+		}
+	}
+	return rows.Err()
+}
+
+// loadBranches reads the other branches of the session with the given id.
+func loadBranches(db *sql.DB, id int64) ([][]sessionMessage, error) {
+	rows, err := db.Query(`SELECT branch, role, content, error, time, tools, sources, reasoning, details FROM branch_messages WHERE session_id = ? ORDER BY branch, position`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var branches [][]sessionMessage
+	for rows.Next() {
+		var b int
+		var msg sessionMessage
+		var sent, tools, sources, details string
+		if err := rows.Scan(&b, &msg.Role, &msg.Content, &msg.Error, &sent, &tools, &sources, &msg.Reasoning, &details); err != nil {
+			return nil, err
+		}
+		msg.Time = parseTime(sent)
+		decodeTools(tools, &msg)
+		decodeDetails(details, &msg)
+		msg.Sources = decodeSources(sources)
+		for len(branches) <= b {
+			branches = append(branches, nil)
+		}
+		branches[b] = append(branches[b], msg)
+	}
+	return branches, rows.Err()
+}
+
+// sessionSummary describes a stored session without its messages.
+type sessionSummary struct {
+	name     string
+	title    string
+	model    string
+	saved    time.Time
+	messages int
+}
+
+// listSessionSummaries returns the stored sessions, most recently saved
+// first. With a query, only sessions whose name, title or messages contain
+// it are listed.
+func listSessionSummaries(query string) ([]sessionSummary, error) {
+	db, err := openStore()
+	if err != nil {
+		return nil, err
+	}
+	q := `SELECT s.name, s.title, s.model, s.saved,
+		(SELECT count(*) FROM messages m WHERE m.session_id = s.id)
+		FROM sessions s`
+	var args []any
+	if query != "" {
+		q += ` WHERE s.name LIKE ?1 ESCAPE '\' OR s.title LIKE ?1 ESCAPE '\'
+			OR EXISTS (SELECT 1 FROM messages m WHERE m.session_id = s.id AND m.content LIKE ?1 ESCAPE '\')`
+		args = append(args, "%"+escapeLike(query)+"%")
+	}
+	q += ` ORDER BY s.saved DESC, s.name`
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list sessions: %w", err)
+	}
+	defer rows.Close()
+	var summaries []sessionSummary
+	for rows.Next() {
+		var sum sessionSummary
+		var saved string
+		if err := rows.Scan(&sum.name, &sum.title, &sum.model, &saved, &sum.messages); err != nil {
+			return nil, fmt.Errorf("list sessions: %w", err)
+		}
+		sum.saved = parseTime(saved)
+		summaries = append(summaries, sum)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list sessions: %w", err)
+	}
+	return summaries, nil
+}
+
+// recentSessions returns the names of all stored sessions, most recently
+// saved first.
+func recentSessions() ([]string, error) {
+	summaries, err := listSessionSummaries("")
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(summaries))
+	for i, sum := range summaries {
+		names[i] = sum.name
+	}
+	return names, nil
+}
+
+// sessionExists reports whether a session of that name is stored.
+func sessionExists(name string) (bool, error) {
+	db, err := openStore()
+	if err != nil {
+		return false, err
+	}
+	var n int
+	if err := db.QueryRow(`SELECT count(*) FROM sessions WHERE name = ?`, name).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// renameStoredSession renames a stored session.
+func renameStoredSession(from, to string) error {
+	if err := validateName(to); err != nil {
+		return err
+	}
+	if exists, err := sessionExists(to); err != nil {
+		return fmt.Errorf("rename session: %w", err)
+	} else if exists {
+		return fmt.Errorf("a session named %s already exists", to)
+	}
+	db, err := openStore()
+	if err != nil {
+		return err
+	}
+	res, err := db.Exec(`UPDATE sessions SET name = ? WHERE name = ?`, to, from)
+	if err != nil {
+		return fmt.Errorf("rename session: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("rename session %s: %w", from, errSessionNotFound)
+	}
+	return nil
+}
+
+// deleteStoredSession deletes a stored session and its messages.
+func deleteStoredSession(name string) error {
+	db, err := openStore()
+	if err != nil {
+		return err
+	}
+	res, err := db.Exec(`DELETE FROM sessions WHERE name = ?`, name)
+	if err != nil {
+		return fmt.Errorf("delete session: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("delete session %s: %w", name, errSessionNotFound)
+	}
+	return nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// storeTimeFormat is RFC 3339 in UTC with a fixed number of digits, so stored
+// times sort as text. Unknown times are stored as empty text.
+const storeTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(storeTimeFormat)
+}
+
+func parseTime(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, s)
+	return t
+}
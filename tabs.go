@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// conversation is the state of one tab: its messages, model and view, along
+// with any request in flight for it.
+type conversation struct {
+	id          int
+	modelName   string
+	messages    []chatMessage
+	loading     bool
+	streaming   bool
+	partialResp string
+	// partialReasoning is the thinking of the response being streamed.
+	partialReasoning string
+	// partialChoices and choices hold the candidates of a request for more
+	// than one choice, while streaming and once complete respectively.
+	partialChoices []string
+	choices        []string
+	streamChan     chan streamEvent
+	queued         string // message waiting for the current response to finish
+	attachment     string // command output and files to include in the next message
+	lastStats      string // length summary of the last completed response
+	rate           throughput
+	reconnecting   int         // attempt at resuming the response after the connection dropped
+	compare        *comparison // models answering a /compare prompt, if any
+	selected       int         // index of the selected message, -1 for none
+	editing        int         // index of the message being edited in the input, -1 for none
+	title          string      // conversation title, generated or set with /title
+	sessionName    string      // session the conversation is saved to, empty until saved
+	// branches are the other paths the conversation took, as forked by
+	// editing, regenerating or /branch.
+	branches [][]chatMessage
+	// images are sent with the next message, along with the attachment.
+	images []attachedImage
+	// summary stands in for the compacted messages in requests, and
+	// compacting is set while it is being written.
+	summary    string
+	compacting bool
+	// spent is the tokens used by every request in the conversation, and
+	// choicesUsage what the request for the choices waiting to be picked used.
+	spent        usage
+	choicesUsage usage
+	// systemPrompt starts as the profile's and can be changed per
+	// conversation with /system.
+	systemPrompt string
+	// provider answers the conversation's requests, connected for the
+	// profile named, or for none if it is empty, so tabs can use different
+	// providers.
+	provider provider
+	profile  string
+	// comparing are the models every message is sent to, set with /compare
+	// without a prompt, or none to send them to modelName alone.
+	comparing []string
+	// recall is the prompt history being moved through with Up and Down, and
+	// recallIndex the prompt in the input, or nil when not recalling.
+	recall      []string
+	recallIndex int
+	// findQuery is the text last searched for in the conversation.
+	findQuery string
+	// firstVisible is the index of the oldest message still rendered; the
+	// ones before it were evicted from the screen by the scrollback limit.
+	firstVisible int
+	// viewport scrolls the rendered conversation, separately in each tab.
+	viewport viewport.Model
+	override string // model answering the request in flight, if not modelName
+	// settings replaces the configured generation settings for the request
+	// in flight, as given to /retry.
+	settings *config
+	// sources are the indexed documents given with the request in flight.
+	sources []string
+	// cancelStream stops the request in flight, with the reason to report
+	// unless it is nil, and streamDone is closed once its goroutine has
+	// returned.
+	cancelStream context.CancelCauseFunc
+	streamDone   chan struct{}
+}
+
+// maxTabs is how many tabs can be open, one per number key.
+const maxTabs = 9
+
+// tabMsg is a message about a particular tab's request, which may arrive
+// while another tab is active.
+type tabMsg interface {
+	tabID() int
+}
+
+func (msg streamStartMsg) tabID() int    { return msg.tab }
+func (msg streamStarted) tabID() int     { return msg.tab }
+func (msg streamUpdateMsg) tabID() int   { return msg.tab }
+func (msg streamCompleteMsg) tabID() int { return msg.tab }
+func (msg titleMsg) tabID() int          { return msg.tab }
+func (msg runOutputMsg) tabID() int      { return msg.tab }
+func (msg fetchMsg) tabID() int          { return msg.tab }
+
+var (
+	tabStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#626262")).
+			Padding(0, 1)
+
+	activeTabStyle = lipgloss.NewStyle().
+			Bold(true).
+			Foreground(lipgloss.Color("#FAFAFA")).
+			Background(lipgloss.Color("#7C3AED")).
+			Padding(0, 1)
+)
+
+// failedModel is a model that only shows a fatal startup error.
+func failedModel(err error) model {
+	m := model{err: err, input: newInput(defaultInputMaxHeight)}
+	m.openTab()
+	return m
+}
+
+// openTab adds an empty conversation using the current model and makes it
+// the active tab.
+func (m *model) openTab() {
+	c := &conversation{id: m.nextTab, selected: -1, editing: -1, viewport: viewport.New(0, 0)}
+	if m.conversation != nil {
+		c.modelName = m.modelName
+		c.systemPrompt = m.systemPrompt
+		c.provider = m.provider
+		c.profile = m.profile
+	}
+	m.nextTab++
+	m.tabs = append(m.tabs, c)
+	m.conversation = c
+}
+
+// findTab returns the open conversation with the given id, or nil if its tab
+// has been closed.
+func (m model) findTab(id int) *conversation {
+	for _, c := range m.tabs {
+		if c.id == id {
+			return c
+		}
+	}
+	return nil
+}
+
+// tabIndex returns the position of the active tab.
+func (m model) tabIndex() int {
+	for i, c := range m.tabs {
+		if c == m.conversation {
+			return i
+		}
+	}
+	return 0
+}
+
+// updateTab handles a message for a tab in the background by making it
+// active for the duration of the update.
+func (m model) updateTab(msg tabMsg) (tea.Model, tea.Cmd) {
+	target := m.findTab(msg.tabID())
+	if target == nil {
+		// The tab was closed and its request cancelled
+		return m, nil
+	}
+	active := m.conversation
+	m.conversation = target
+	next, cmd := m.Update(msg)
+	m = next.(model)
+	m.conversation = active
+	return m, cmd
+}
+
+// switchTab makes the tab at index i active.
+func (m *model) switchTab(i int) {
+	if i < 0 || i >= len(m.tabs) {
+		m.notice = fmt.Sprintf("There is no tab %d", i+1)
+		return
+	}
+	if m.tabs[i].provider != m.provider {
+		// The models listed were the other provider's
+		m.providerModels = nil
+	}
+	m.conversation = m.tabs[i]
+}
+
+// cycleTab moves delta tabs along, wrapping around at either end.
+func (m *model) cycleTab(delta int) {
+	m.switchTab((m.tabIndex() + delta + len(m.tabs)) % len(m.tabs))
+}
+
+func newTab(m *model) tea.Cmd {
+	if len(m.tabs) >= maxTabs {
+		m.notice = fmt.Sprintf("At most %d tabs can be open", maxTabs)
+		return nil
+	}
+	m.openTab()
+	return nil
+}
+
+// closeTab closes the active tab, cancelling its request if one is in
+// flight.
+func closeTab(m *model) tea.Cmd {
+	if len(m.tabs) == 1 {
+		m.notice = "Cannot close the last tab, use /clear to start over"
+		return nil
+	}
+	return m.confirmDiscard("Close this tab?", func(m *model) tea.Cmd {
+		if m.cancelStream != nil {
+			m.cancelStream(nil)
+		}
+		i := m.tabIndex()
+		m.tabs = append(m.tabs[:i], m.tabs[i+1:]...)
+		m.conversation = m.tabs[min(i, len(m.tabs)-1)]
+		return nil
+	})
+}
+
+// tabBar renders a label for each open tab, marking the active one and any
+// with a response in flight. It is empty while only one tab is open.
+func (m model) tabBar() string {
+	if len(m.tabs) < 2 {
+		return ""
+	}
+	labels := make([]string, len(m.tabs))
+	for i, c := range m.tabs {
+		label := fmt.Sprintf("%d %s", i+1, c.tabTitle())
+		if c.loading {
+			label += " ●"
+		}
+		if c == m.conversation {
+			labels[i] = activeTabStyle.Render(label)
+		} else {
+			labels[i] = tabStyle.Render(label)
+		}
+	}
+	return strings.Join(labels, " ")
+}
+
+// tabTitle is the name shown for the conversation in the tab bar.
+func (c *conversation) tabTitle() string {
+	const maxTitle = 20
+	if c.title == "" {
+		return "New chat"
+	}
+	if r := []rune(c.title); len(r) > maxTitle {
+		return string(r[:maxTitle-1]) + "…"
+	}
+	return c.title
+}
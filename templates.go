@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/atotto/clipboard"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Templates are prompts saved as markdown files. Placeholders in them are
+// filled in when one is used with /prompt: {{clipboard}} with the clipboard's
+// text, {{file:path}} with a file's, and anything else, such as {{input}} or
+// {{language}}, with what the user types when asked.
+
+const templateExt = ".md"
+
+// templatesDir returns the directory prompt templates are stored in,
+// ~/.config/llmtui/prompts on Linux.
+func templatesDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "llmtui", "prompts"), nil
+}
+
+func templatePath(name string) (string, error) {
+	if err := validateName(name); err != nil {
+		return "", err
+	}
+	dir, err := templatesDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+templateExt), nil
+}
+
+// saveTemplate stores content as the named template, replacing any existing
+// template with that name.
+func saveTemplate(name, content string) error {
+	path, err := templatePath(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("save template: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		return fmt.Errorf("save template: %w", err)
+	}
+	return nil
+}
+
+func loadTemplate(name string) (string, error) {
+	path, err := templatePath(name)
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("load template: %w", err)
+	}
+	return string(data), nil
+}
+
+// listTemplates returns the names of all saved templates in sorted order.
+func listTemplates() ([]string, error) {
+	dir, err := templatesDir()
+	if err != nil {
+		return nil, err
+	}
+	names, err := listNames(dir, templateExt)
+	if err != nil {
+		return nil, fmt.Errorf("list templates: %w", err)
+	}
+	return names, nil
+}
+
+// listNames returns the names, without extension, of the files in dir ending
+// in ext, in sorted order. A missing directory has no names.
+func listNames(dir, ext string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ext) {
+			names = append(names, strings.TrimSuffix(e.Name(), ext))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// placeholder matches a placeholder in a template, as in {{input}} or
+// {{file:notes.md}}.
+var placeholder = regexp.MustCompile(`\{\{\s*([^{}]*?)\s*\}\}`)
+
+// templateVariables returns the names of the placeholders in a template the
+// user fills in, in the order they first appear.
+func templateVariables(content string) []string {
+	var names []string
+	for _, match := range placeholder.FindAllStringSubmatch(content, -1) {
+		name := match[1]
+		if name == "clipboard" || strings.HasPrefix(name, "file:") || slices.Contains(names, name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// expandTemplate replaces the placeholders in a template with the values
+// filled in, the clipboard's text and the contents of the files named.
+func expandTemplate(content string, values map[string]string) (string, error) {
+	var err error
+	expanded := placeholder.ReplaceAllStringFunc(content, func(match string) string {
+		name := placeholder.FindStringSubmatch(match)[1]
+		if err != nil {
+			return match
+		}
+		switch {
+		case name == "clipboard":
+			var text string
+			if text, err = clipboard.ReadAll(); err != nil {
+				err = fmt.Errorf("read the clipboard: %w", err)
+			}
+			return text
+		case strings.HasPrefix(name, "file:"):
+			path := strings.TrimSpace(strings.TrimPrefix(name, "file:"))
+			if path == "" {
+				err = errors.New("{{file:}} names no file")
+				return match
+			}
+			var text string
+			text, err = readAttachment(expandHome(path))
+			return strings.TrimRight(text, "\n")
+		}
+		return values[name]
+	})
+	return expanded, err
+}
+
+func runPrompt(m *model, args string) tea.Cmd {
+	if args == "" {
+		names, err := listTemplates()
+		if err != nil {
+			m.notice = err.Error()
+			return nil
+		}
+		if len(names) == 0 {
+			m.notice = "No templates saved, press Ctrl+S to save the input as one"
+			return nil
+		}
+		m.palette = &palette{entries: templateEntries, noMatches: "No matching templates", verb: "fill in"}
+		return nil
+	}
+	name, input, _ := strings.Cut(args, " ")
+	content, err := loadTemplate(name)
+	if err != nil {
+		m.notice = err.Error()
+		return nil
+	}
+	values := make(map[string]string)
+	// Text after the name is the input, saving a question
+	if input = strings.TrimSpace(input); input != "" {
+		values["input"] = input
+	}
+	return m.fillTemplate(content, templateVariables(content), values)
+}
+
+// fillTemplate asks in turn for each variable of a template without a value,
+// then puts the template in the input with its placeholders replaced, to be
+// reviewed and sent.
+func (m *model) fillTemplate(content string, names []string, values map[string]string) tea.Cmd {
+	for _, name := range names {
+		if _, ok := values[name]; ok {
+			continue
+		}
+		m.prompt = &textPrompt{label: name + ": ", onSubmit: func(m *model, value string) tea.Cmd {
+			values[name] = value
+			return m.fillTemplate(content, names, values)
+		}}
+		return nil
+	}
+	text, err := expandTemplate(content, values)
+	if err != nil {
+		m.notice = err.Error()
+		return nil
+	}
+	m.setInput(text)
+	m.notice = "Press Enter to send the prompt, or edit it first"
+	return nil
+}
+
+// templateEntries lists the saved templates, each filled in when picked.
+func templateEntries(m model) []paletteEntry {
+	names, _ := listTemplates()
+	entries := make([]paletteEntry, len(names))
+	for i, name := range names {
+		var help string
+		if content, err := loadTemplate(name); err == nil {
+			help = strings.Join(strings.Fields(content), " ")
+		}
+		entries[i] = paletteEntry{label: name, help: help, run: func(m *model) tea.Cmd {
+			return runPrompt(m, name)
+		}}
+	}
+	return entries
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+
+	chromastyles "github.com/alecthomas/chroma/v2/styles"
+	"github.com/charmbracelet/glamour/styles"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// theme overrides the interface colours, given as hex codes or ANSI colour
+// numbers, and the styles responses are rendered in.
+type theme struct {
+	Accent    string `toml:"accent,omitempty"` // title, tabs, borders and selection
+	User      string `toml:"user,omitempty"`
+	Assistant string `toml:"assistant,omitempty"`
+	Input     string `toml:"input,omitempty"`
+	Error     string `toml:"error,omitempty"`
+	Muted     string `toml:"muted,omitempty"`      // help and status text
+	Tool      string `toml:"tool,omitempty"`       // tool calls and their results
+	StatusBar string `toml:"status_bar,omitempty"` // background of the status bar
+	// Markdown is a glamour style such as dark, light, dracula or
+	// tokyo-night, and Code a chroma style such as monokai or github.
+	Markdown string `toml:"markdown,omitempty"`
+	Code     string `toml:"code,omitempty"`
+}
+
+// applyTheme restyles the interface. It runs at startup, after the markdown
+// styles have been matched to the terminal, so a configured style wins.
+func applyTheme(t theme) error {
+	if t.Markdown != "" {
+		if _, ok := styles.DefaultStyles[t.Markdown]; !ok {
+			return fmt.Errorf("theme: unknown markdown style %q", t.Markdown)
+		}
+		markdownStyle = t.Markdown
+	}
+	if t.Code != "" {
+		if _, ok := chromastyles.Registry[t.Code]; !ok {
+			return fmt.Errorf("theme: unknown code style %q", t.Code)
+		}
+		codeStyle = t.Code
+	}
+	if t.Accent != "" {
+		accent := lipgloss.Color(t.Accent)
+		titleStyle = titleStyle.Foreground(accent)
+		selectedStyle = selectedStyle.BorderForeground(accent)
+		activeTabStyle = activeTabStyle.Background(accent)
+		panelStyle = panelStyle.BorderForeground(accent)
+	}
+	if t.StatusBar != "" {
+		statusBarStyle = statusBarStyle.Background(lipgloss.Color(t.StatusBar))
+	}
+	for _, c := range []struct {
+		color string
+		style *lipgloss.Style
+	}{
+		{t.User, &userStyle},
+		{t.Assistant, &assistantStyle},
+		{t.Input, &inputStyle},
+		{t.Error, &errorStyle},
+		{t.Muted, &helpStyle},
+		{t.Tool, &toolStyle},
+	} {
+		if c.color != "" {
+			*c.style = c.style.Foreground(lipgloss.Color(c.color))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// rateWindow is how far back the live tokens per second rate looks.
+const rateWindow = 2 * time.Second
+
+// throughput tracks how fast a response streams, from the token counts of
+// its updates. Rates are measured from the first update, so the wait for the
+// first token does not count; that is timed from when the request started.
+type throughput struct {
+	started time.Time
+	ended   time.Time // once the response is complete
+	first   tokenSample
+	recent  []tokenSample // samples within rateWindow of the latest
+}
+
+// startThroughput starts timing a request sent now.
+func startThroughput(now time.Time) throughput {
+	return throughput{started: now}
+}
+
+type tokenSample struct {
+	at     time.Time
+	tokens int
+}
+
+// record notes that tokens have been received in total by now.
+func (t *throughput) record(tokens int, now time.Time) {
+	s := tokenSample{at: now, tokens: tokens}
+	if t.first.at.IsZero() {
+		t.first = s
+	}
+	t.recent = append(t.recent, s)
+	i := 0
+	for i < len(t.recent)-1 && now.Sub(t.recent[i].at) > rateWindow {
+		i++
+	}
+	t.recent = t.recent[i:]
+}
+
+// rolling returns the rate over the last rateWindow, or zero until there is
+// enough to measure.
+func (t throughput) rolling() float64 {
+	if len(t.recent) < 2 {
+		return 0
+	}
+	return rate(t.recent[0], t.recent[len(t.recent)-1])
+}
+
+// average returns the rate over the whole response.
+func (t throughput) average() float64 {
+	if len(t.recent) == 0 {
+		return 0
+	}
+	return rate(t.first, t.recent[len(t.recent)-1])
+}
+
+// tokens returns roughly how many tokens have been received.
+func (t throughput) tokens() int {
+	if len(t.recent) == 0 {
+		return 0
+	}
+	return t.recent[len(t.recent)-1].tokens
+}
+
+// live describes a response streaming in as of now: how long it has taken,
+// and once tokens arrive, how many have and how fast they are.
+func (t throughput) live(now time.Time) string {
+	elapsed := fmt.Sprintf("%.1fs", now.Sub(t.started).Seconds())
+	if t.first.at.IsZero() {
+		return "Waiting for the first token · " + elapsed
+	}
+	s := fmt.Sprintf("Streaming · %s · ~%d tokens", elapsed, t.tokens())
+	if rate := t.rolling(); rate > 0 {
+		s += fmt.Sprintf(" · %.1f tokens/s", rate)
+	}
+	return s
+}
+
+// latency describes how long a complete response took: to its first token
+// and in all. It is empty if the response was not timed.
+func (t throughput) latency() string {
+	if t.started.IsZero() || t.ended.IsZero() {
+		return ""
+	}
+	s := fmt.Sprintf("%.1fs in all", t.ended.Sub(t.started).Seconds())
+	if !t.first.at.IsZero() {
+		s = fmt.Sprintf("first token after %.1fs, ", t.first.at.Sub(t.started).Seconds()) + s
+	}
+	return s
+}
+
+func rate(from, to tokenSample) float64 {
+	elapsed := to.at.Sub(from.at).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(to.tokens-from.tokens) / elapsed
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const titlePrompt = "Summarize the following exchange as a title of at most five words. " +
+	"Reply with the title only, without quotes or punctuation at the end."
+
+// titleTimeout bounds the background title request so a slow server cannot
+// leave it running indefinitely.
+const titleTimeout = 30 * time.Second
+
+// titleMsg carries a generated conversation title.
+type titleMsg struct {
+	tab   int
+	title string
+	err   error
+}
+
+// autoTitle starts generating a title in the background once the first
+// exchange of an untitled conversation is complete.
+func (m model) autoTitle() tea.Cmd {
+	if !m.config.AutoTitle || m.title != "" || len(m.messages) < 2 {
+		return nil
+	}
+	// The first exchange ends with the first answer, after any tool calls
+	answer := m.messages[len(m.messages)-1]
+	if answer.role != "assistant" || answer.err != nil ||
+		slices.ContainsFunc(m.messages[1:], func(msg chatMessage) bool { return msg.role == "user" }) {
+		return nil
+	}
+	tab, backend, modelName := m.id, m.provider, m.modelName
+	exchange := "User: " + m.messages[0].content + "\n\nAssistant: " + answer.content
+
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), titleTimeout)
+		defer cancel()
+
+		chunks, err := backend.stream(ctx, request{
+			model: modelName,
+			messages: []message{
+				{role: "system", content: titlePrompt},
+				{role: "user", content: exchange},
+			},
+			choices: 1,
+		})
+		if err != nil {
+			return titleMsg{tab: tab, err: err}
+		}
+		var title strings.Builder
+		for c := range chunks {
+			if c.err != nil {
+				return titleMsg{tab: tab, err: c.err}
+			}
+			title.WriteString(c.content)
+		}
+		if err := ctx.Err(); err != nil {
+			return titleMsg{tab: tab, err: err}
+		}
+		return titleMsg{tab: tab, title: strings.Trim(strings.TrimSpace(title.String()), `"'.`)}
+	}
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Models that support function calling can ask for the tools enabled in the
+// config's tools list to be run. A response that calls tools is followed by
+// their results and a further request, until the model answers in text.
+
+// tool is a function the model can call.
+type tool struct {
+	name        string
+	description string
+	// parameters is the JSON schema of the call's arguments, an object.
+	parameters map[string]any
+	// run carries out a call, returning the text given back to the model.
+	run func(ctx context.Context, c config, args json.RawMessage) (string, error)
+	// approve, if set, is asked before a call is run. It returns a question
+	// for the user if the call needs their approval, or an error if it is
+	// refused outright.
+	approve func(c config, args json.RawMessage) (string, error)
+}
+
+// toolCall is a model's request to run a tool, with its arguments as a JSON
+// object.
+type toolCall struct {
+	id        string
+	name      string
+	arguments string
+}
+
+// toolTimeout bounds a single tool call.
+const toolTimeout = 30 * time.Second
+
+// maxToolRounds is how many times in a row the model may call tools before
+// the exchange is stopped, in case it goes round in circles.
+const maxToolRounds = 10
+
+// builtinTools are the tools that can be enabled by name.
+var builtinTools = []tool{
+	{
+		name:        "current_time",
+		description: "Get the current local date and time.",
+		parameters:  map[string]any{"type": "object", "properties": map[string]any{}},
+		run: func(ctx context.Context, c config, args json.RawMessage) (string, error) {
+			return time.Now().Format("Monday, 2 January 2006 15:04:05 MST"), nil
+		},
+	},
+	{
+		name:        "read_file",
+		description: "Read a text file in the current directory or below it.",
+		parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"path": map[string]any{"type": "string", "description": "The file's path, relative to the current directory."},
+			},
+			"required": []string{"path"},
+		},
+		run: readFileTool,
+	},
+	{
+		name:        "run_shell",
+		description: "Run a shell command in the current directory and get its output. The user is asked to allow each command first.",
+		parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"command": map[string]any{"type": "string", "description": "The command line to run."},
+			},
+			"required": []string{"command"},
+		},
+		run:     runShellTool,
+		approve: approveShellTool,
+	},
+	{
+		name:        "fetch_url",
+		description: "Download a web page and get its readable text, cut to a limited length.",
+		parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"url": map[string]any{"type": "string", "description": "The page's http or https URL."},
+			},
+			"required": []string{"url"},
+		},
+		run: fetchURLTool,
+	},
+	{
+		name:        "web_search",
+		description: "Search the web, getting the title, URL and a snippet of the top results. Use fetch_url, if enabled, to read a result.",
+		parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"query": map[string]any{"type": "string", "description": "The search query."},
+			},
+			"required": []string{"query"},
+		},
+		run: webSearchTool,
+	},
+	{
+		name:        "remember",
+		description: "Remember a fact about the user, such as their tools or preferences, for all future conversations. Use it when the user asks you to remember something.",
+		parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"content": map[string]any{"type": "string", "description": "The fact, as a short sentence."},
+			},
+			"required": []string{"content"},
+		},
+		run: rememberTool,
+	},
+	{
+		name:        "forget",
+		description: "Forget a fact remembered about the user, by its memory number.",
+		parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"id": map[string]any{"type": "integer", "description": "The fact's memory number."},
+			},
+			"required": []string{"id"},
+		},
+		run: forgetTool,
+	},
+}
+
+func readFileTool(ctx context.Context, c config, args json.RawMessage) (string, error) {
+	var params struct {
+		Path string `json:"path"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", err
+	}
+	// Only what the user could attach from here is readable. Opening the
+	// file through the root keeps symlinks from leading out of it too.
+	if !filepath.IsLocal(params.Path) {
+		return "", fmt.Errorf("%s is outside the current directory", params.Path)
+	}
+	root, err := os.OpenRoot(".")
+	if err != nil {
+		return "", err
+	}
+	defer root.Close()
+	return readAttachmentFrom(root, params.Path)
+}
+
+// findTool returns the named tool, if it exists.
+func findTool(name string) (tool, bool) {
+	i := slices.IndexFunc(builtinTools, func(t tool) bool { return t.name == name })
+	if i < 0 {
+		return tool{}, false
+	}
+	return builtinTools[i], true
+}
+
+// enabledTools returns the tools the config enables, which are declared in
+// every request.
+func (c config) enabledTools() []tool {
+	var enabled []tool
+	for _, name := range c.Tools {
+		if t, ok := findTool(name); ok {
+			enabled = append(enabled, t)
+		}
+	}
+	return enabled
+}
+
+// tools returns the tools declared in requests: those the config enables and
+// those of the MCP servers still connected.
+func (m model) tools() []tool {
+	tools := m.config.enabledTools()
+	for _, c := range m.mcpClients {
+		if c.failed() == nil {
+			tools = append(tools, c.tools...)
+		}
+	}
+	return tools
+}
+
+// validateTools checks the config's tools list names known tools.
+func (c config) validateTools() error {
+	for _, name := range c.Tools {
+		if _, ok := findTool(name); !ok {
+			return fmt.Errorf("unknown tool %q", name)
+		}
+	}
+	return nil
+}
+
+// toolResult is the outcome of a tool call.
+type toolResult struct {
+	call   toolCall
+	output string
+	err    error
+}
+
+// toolResultsMsg carries the results of the tool calls of a response.
+type toolResultsMsg struct {
+	tab     int
+	results []toolResult
+	stopped bool // stopped with Esc before all of them were done
+}
+
+func (msg toolResultsMsg) tabID() int { return msg.tab }
+
+// errDeclined is given to the model for a call the user did not allow.
+var errDeclined = errors.New("the user declined to allow this call")
+
+// toolApprovalMsg carries the calls of a response while the user is asked to
+// allow them, one at a time, back to their tab.
+type toolApprovalMsg struct {
+	tab     int
+	calls   []toolCall
+	refused map[string]error // the calls not to run, by id
+	next    int              // the call to consider next
+}
+
+func (msg toolApprovalMsg) tabID() int { return msg.tab }
+
+// approveTools asks the user about each call, from msg.next on, whose tool
+// needs approval, and then runs those that were not refused. Commands run
+// with the user's privileges, so this asks even with confirmations disabled.
+func (m *model) approveTools(msg toolApprovalMsg) tea.Cmd {
+	m.loading = true
+	tools := m.tools()
+	for i := msg.next; i < len(msg.calls); i++ {
+		call := msg.calls[i]
+		j := slices.IndexFunc(tools, func(t tool) bool { return t.name == call.name })
+		if j < 0 || tools[j].approve == nil {
+			continue
+		}
+		question, err := tools[j].approve(m.config, callArguments(call))
+		if err != nil {
+			msg.refused[call.id] = err
+			continue
+		}
+		if question == "" {
+			continue
+		}
+		answer := func(refusal error) func(m *model) tea.Cmd {
+			return func(m *model) tea.Cmd {
+				if refusal != nil {
+					msg.refused[call.id] = refusal
+				}
+				next := msg
+				next.next = i + 1
+				return func() tea.Msg { return next }
+			}
+		}
+		m.confirm = &confirmation{prompt: question, action: answer(nil), decline: answer(errDeclined)}
+		return nil
+	}
+	return m.startTools(msg.calls, msg.refused)
+}
+
+// startTools runs the calls of the last response one after another in the
+// background, except those refused, which fail with the reason. Stopping the
+// response stops them too, failing those left.
+func (m *model) startTools(calls []toolCall, refused map[string]error) tea.Cmd {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	done := make(chan struct{})
+	m.loading = true
+	m.cancelStream = cancel
+	m.streamDone = done
+	m.notice = "Running " + describeCalls(calls) + "..."
+	tab, cfg := m.id, m.config
+	tools := m.tools()
+	return func() tea.Msg {
+		defer close(done)
+		results := make([]toolResult, len(calls))
+		for i, call := range calls {
+			results[i] = toolResult{call: call, err: refused[call.id]}
+			if results[i].err != nil {
+				continue
+			}
+			if ctx.Err() != nil {
+				results[i].err = context.Cause(ctx)
+				continue
+			}
+			results[i].output, results[i].err = runTool(ctx, cfg, tools, call)
+		}
+		return toolResultsMsg{tab: tab, results: results, stopped: errors.Is(context.Cause(ctx), errStopped)}
+	}
+}
+
+// runTool carries out a single call to one of tools.
+func runTool(ctx context.Context, c config, tools []tool, call toolCall) (string, error) {
+	i := slices.IndexFunc(tools, func(t tool) bool { return t.name == call.name })
+	if i < 0 {
+		return "", fmt.Errorf("unknown tool %q", call.name)
+	}
+	ctx, cancel := context.WithTimeout(ctx, toolTimeout)
+	defer cancel()
+	return tools[i].run(ctx, c, callArguments(call))
+}
+
+// callArguments returns a call's arguments, as an empty object if it has
+// none.
+func callArguments(call toolCall) json.RawMessage {
+	if strings.TrimSpace(call.arguments) == "" {
+		return json.RawMessage("{}")
+	}
+	return json.RawMessage(call.arguments)
+}
+
+// describeCalls names the tools called, for notices.
+func describeCalls(calls []toolCall) string {
+	names := make([]string, len(calls))
+	for i, call := range calls {
+		names[i] = call.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// applyToolResults adds the results of the tool calls to the conversation
+// and asks the model to go on, unless the calls were stopped or it has
+// called tools too many times in a row.
+func (m *model) applyToolResults(msg toolResultsMsg) tea.Cmd {
+	m.cancelStream = nil
+	m.streamDone = nil
+	// Every call gets a result, even if stopped, since the model is owed one
+	for _, r := range msg.results {
+		result := chatMessage{role: "tool", content: r.output, toolCallID: r.call.id, toolName: r.call.name, sent: time.Now(), collapsed: true}
+		if r.err != nil {
+			// Failures are given back to the model, which can often recover
+			result.content = "Error: " + r.err.Error()
+		}
+		m.messages = append(m.messages, result)
+		if err := m.transcript.toolTurn(r.call, result.content); err != nil {
+			m.notice = err.Error()
+		}
+	}
+	m.trimScrollback()
+	switch {
+	case msg.stopped:
+		m.loading = false
+		m.notice = "Response stopped"
+		return m.afterTurn()
+	case m.toolRounds() >= maxToolRounds:
+		m.loading = false
+		m.notice = fmt.Sprintf("Stopped after %d rounds of tool calls, send a message to go on", maxToolRounds)
+		return m.afterTurn()
+	}
+	m.notice = ""
+	m.viewport.GotoBottom()
+	return m.sendRequest(m.messages, m.respondingModel())
+}
+
+// toolRounds counts the responses calling tools since the last user message.
+func (m model) toolRounds() int {
+	rounds := 0
+	for i := len(m.messages) - 1; i >= 0 && m.messages[i].role != "user"; i-- {
+		if len(m.messages[i].toolCalls) > 0 {
+			rounds++
+		}
+	}
+	return rounds
+}
+
+func runTools(m *model, args string) tea.Cmd {
+	if args != "" {
+		m.notice = "Usage: /tools"
+		return nil
+	}
+	m.palette = &palette{entries: toolEntries, noMatches: "No matching tools", verb: "toggle"}
+	return nil
+}
+
+// toolEntries lists the tools, each toggling whether it is enabled until
+// llmtui exits.
+func toolEntries(m model) []paletteEntry {
+	entries := make([]paletteEntry, len(builtinTools))
+	for i, t := range builtinTools {
+		label := t.name
+		if slices.Contains(m.config.Tools, t.name) {
+			label += " (enabled)"
+		}
+		entries[i] = paletteEntry{label: label, help: t.description, run: func(m *model) tea.Cmd {
+			if j := slices.Index(m.config.Tools, t.name); j >= 0 {
+				m.config.Tools = slices.Delete(slices.Clone(m.config.Tools), j, j+1)
+				m.notice = "Disabled " + t.name
+			} else {
+				m.config.Tools = append(slices.Clone(m.config.Tools), t.name)
+				m.notice = "Enabled " + t.name + ", the model can call it from the next request"
+			}
+			return nil
+		}}
+	}
+	return entries
+}
+
+// pairToolMessages leaves out tool calls without a result, and results
+// without their call, as left by compacting or deleting messages. Providers
+// reject either.
+func pairToolMessages(messages []message) []message {
+	answered := make(map[string]bool)
+	for _, msg := range messages {
+		if msg.role == "tool" {
+			answered[msg.toolCallID] = true
+		}
+	}
+	called := make(map[string]bool)
+	paired := make([]message, 0, len(messages))
+	for _, msg := range messages {
+		switch {
+		case msg.role == "tool" && !called[msg.toolCallID]:
+			continue
+		case len(msg.toolCalls) > 0:
+			calls := slices.DeleteFunc(slices.Clone(msg.toolCalls), func(call toolCall) bool { return !answered[call.id] })
+			for _, call := range calls {
+				called[call.id] = true
+			}
+			if len(calls) == 0 && msg.content == "" {
+				continue
+			}
+			msg.toolCalls = calls
+		}
+		paired = append(paired, msg)
+	}
+	return paired
+}
+
+// callIDs numbers the calls of providers that do not give them ids.
+var callIDs atomic.Int64
+
+// newCallID returns an id for a tool call, unique for the life of the
+// program and unlikely to match one in a saved session.
+func newCallID() string {
+	return fmt.Sprintf("call_%x_%d", time.Now().UnixNano(), callIDs.Add(1))
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// transcript is an append-only markdown log of the conversation. Every
+// finalized turn is flushed to disk as soon as it is written so a crash never
+// loses more than the response currently streaming.
+type transcript struct {
+	f      *os.File
+	deltas bool // also write responses incrementally while they stream
+	// written is how much of the in-progress response has already been
+	// written when deltas are enabled.
+	written int
+}
+
+func openTranscript(path string, deltas bool) (*transcript, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open transcript: %w", err)
+	}
+	t := &transcript{f: f, deltas: deltas}
+	if err := t.write(fmt.Sprintf("# Session started %s\n\n", timestamp())); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *transcript) Close() error {
+	if t == nil {
+		return nil
+	}
+	return t.f.Close()
+}
+
+// userTurn records a message sent by the user.
+func (t *transcript) userTurn(content string) error {
+	if t == nil {
+		return nil
+	}
+	return t.write(fmt.Sprintf("## You · %s\n\n%s\n\n", timestamp(), content))
+}
+
+// delta records the part of a streaming response that has not been written
+// yet. content is the full response received so far.
+func (t *transcript) delta(modelName, content string) error {
+	if t == nil || !t.deltas || len(content) <= t.written {
+		return nil
+	}
+	if t.written == 0 {
+		if _, err := t.f.WriteString(assistantHeading(modelName)); err != nil {
+			return fmt.Errorf("write transcript: %w", err)
+		}
+	}
+	_, err := t.f.WriteString(content[t.written:])
+	t.written = len(content)
+	if err != nil {
+		return fmt.Errorf("write transcript: %w", err)
+	}
+	return nil
+}
+
+// assistantTurn records a finished response, or the error that ended it. A
+// response in JSON mode is written pretty-printed in a code block, unless it
+// was written as it streamed, and followed by why it is invalid if it is.
+func (t *transcript) assistantTurn(modelName string, msg chatMessage) error {
+	if t == nil {
+		return nil
+	}
+	var s string
+	switch {
+	case t.deltas && t.written > 0:
+		if len(msg.content) > t.written {
+			s = msg.content[t.written:]
+		}
+	case msg.json:
+		content, _ := prettyJSON(msg.content)
+		s = assistantHeading(modelName) + "```json\n" + content + "\n```"
+	default:
+		s = assistantHeading(modelName) + msg.content
+	}
+	t.written = 0
+	if msg.err != nil {
+		s += fmt.Sprintf("\n> Error: %v", msg.err)
+	}
+	if msg.jsonErr != nil {
+		s += fmt.Sprintf("\n> Response %v", msg.jsonErr)
+	}
+	return t.write(s + "\n\n")
+}
+
+// toolTurn records the result of a tool call.
+func (t *transcript) toolTurn(call toolCall, result string) error {
+	if t == nil {
+		return nil
+	}
+	fence := fenceFor(result)
+	return t.write(fmt.Sprintf("## Tool (%s) · %s\n\n`%s`\n\n%s\n%s\n%s\n\n", call.name, timestamp(), call.arguments, fence, strings.TrimRight(result, "\n"), fence))
+}
+
+// write appends s and flushes it to disk.
+func (t *transcript) write(s string) error {
+	if _, err := t.f.WriteString(s); err != nil {
+		return fmt.Errorf("write transcript: %w", err)
+	}
+	if err := t.f.Sync(); err != nil {
+		return fmt.Errorf("sync transcript: %w", err)
+	}
+	return nil
+}
+
+func assistantHeading(modelName string) string {
+	return fmt.Sprintf("## LLM (%s) · %s\n\n", modelName, timestamp())
+}
+
+func timestamp() string {
+	return formatTimestamp(time.Now())
+}
+
+func formatTimestamp(t time.Time) string {
+	return t.Format("2006-01-02 15:04:05")
+}
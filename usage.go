@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// usage is how many tokens a request used, as reported by the provider, and
+// what they cost if the model's prices are known.
+type usage struct {
+	prompt     int64
+	completion int64
+	cost       float64
+	// priced reports whether cost is known, so free local models are told
+	// apart from models without prices.
+	priced bool
+}
+
+func (u usage) tokens() int64 {
+	return u.prompt + u.completion
+}
+
+func (u usage) add(other usage) usage {
+	return usage{
+		prompt:     u.prompt + other.prompt,
+		completion: u.completion + other.completion,
+		cost:       u.cost + other.cost,
+		priced:     u.priced || other.priced,
+	}
+}
+
+func (u usage) String() string {
+	s := fmt.Sprintf("%d prompt + %d completion tokens", u.prompt, u.completion)
+	if u.priced {
+		s += " · " + formatCost(u.cost)
+	}
+	return s
+}
+
+// formatCost formats an amount in dollars, with more digits for the small
+// amounts a single turn usually costs.
+func formatCost(cost float64) string {
+	if cost > 0 && cost < 0.0001 {
+		return "<$0.0001"
+	}
+	if cost > 0 && cost < 0.01 {
+		return fmt.Sprintf("$%.4f", cost)
+	}
+	return fmt.Sprintf("$%.2f", cost)
+}
+
+// price is what a model charges, in dollars per million tokens.
+type price struct {
+	Input  float64 `toml:"input"`
+	Output float64 `toml:"output"`
+}
+
+// modelPrices are the list prices of well-known models, matched by the
+// longest prefix so dated snapshots share their model's price. The config's
+// [pricing] table adds to and overrides them.
+var modelPrices = map[string]price{
+	"gpt-5":                 {Input: 1.25, Output: 10},
+	"gpt-5-mini":            {Input: 0.25, Output: 2},
+	"gpt-5-nano":            {Input: 0.05, Output: 0.40},
+	"gpt-4.1":               {Input: 2, Output: 8},
+	"gpt-4.1-mini":          {Input: 0.40, Output: 1.60},
+	"gpt-4.1-nano":          {Input: 0.10, Output: 0.40},
+	"gpt-4o":                {Input: 2.50, Output: 10},
+	"gpt-4o-mini":           {Input: 0.15, Output: 0.60},
+	"gpt-4-turbo":           {Input: 10, Output: 30},
+	"gpt-4":                 {Input: 30, Output: 60},
+	"gpt-3.5-turbo":         {Input: 0.50, Output: 1.50},
+	"o1":                    {Input: 15, Output: 60},
+	"o3":                    {Input: 2, Output: 8},
+	"o3-mini":               {Input: 1.10, Output: 4.40},
+	"o4-mini":               {Input: 1.10, Output: 4.40},
+	"claude-opus-4":         {Input: 15, Output: 75},
+	"claude-opus-4-5":       {Input: 5, Output: 25},
+	"claude-sonnet-4":       {Input: 3, Output: 15},
+	"claude-haiku-4-5":      {Input: 1, Output: 5},
+	"claude-3-7-sonnet":     {Input: 3, Output: 15},
+	"claude-3-5-sonnet":     {Input: 3, Output: 15},
+	"claude-3-5-haiku":      {Input: 0.80, Output: 4},
+	"claude-3-haiku":        {Input: 0.25, Output: 1.25},
+	"gemini-2.5-pro":        {Input: 1.25, Output: 10},
+	"gemini-2.5-flash":      {Input: 0.30, Output: 2.50},
+	"gemini-2.5-flash-lite": {Input: 0.10, Output: 0.40},
+	"gemini-2.0-flash":      {Input: 0.10, Output: 0.40},
+	"gemini-2.0-flash-lite": {Input: 0.075, Output: 0.30},
+}
+
+// longestPrefix looks up modelName in a table keyed by model names or their
+// prefixes, matching the longest.
+func longestPrefix[T any](table map[string]T, modelName string) (T, bool) {
+	best, found := "", false
+	for prefix := range table {
+		if strings.HasPrefix(modelName, prefix) && (!found || len(prefix) > len(best)) {
+			best, found = prefix, true
+		}
+	}
+	return table[best], found
+}
+
+// priceUsage fills in the cost of u for modelName, if its price is known.
+func (c config) priceUsage(modelName string, u usage) usage {
+	p, ok := longestPrefix(c.Pricing, modelName)
+	if !ok {
+		p, ok = longestPrefix(modelPrices, modelName)
+	}
+	if !ok {
+		return u
+	}
+	u.cost = (float64(u.prompt)*p.Input + float64(u.completion)*p.Output) / 1e6
+	u.priced = true
+	return u
+}
+
+// recordUsage prices the tokens a request to modelName used and adds them
+// to the conversation's total, returning the priced usage.
+func (m *model) recordUsage(u usage, modelName string) usage {
+	if u.tokens() == 0 {
+		return u
+	}
+	u = m.config.priceUsage(modelName, u)
+	m.spent = m.spent.add(u)
+	return u
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// With vim_mode set, keys are taken the way vim takes them: in insert mode
+// they go to the input as usual, in normal mode they move between messages
+// and act on them, and in visual mode they select a range of messages to
+// copy. Esc leaves insert and visual mode for normal mode.
+
+// vimMode is the mode keys are taken in with vim_mode set.
+type vimMode int
+
+const (
+	vimInsert vimMode = iota
+	vimNormal
+	vimVisual
+)
+
+func (v vimMode) String() string {
+	switch v {
+	case vimNormal:
+		return "NORMAL"
+	case vimVisual:
+		return "VISUAL"
+	}
+	return "INSERT"
+}
+
+// setVimMode switches to mode, giving the input focus only in insert mode so
+// keys typed in the others never reach it.
+func (m *model) setVimMode(mode vimMode) {
+	m.vim = mode
+	m.vimPending = ""
+	if mode == vimInsert {
+		m.input.Focus()
+	} else {
+		m.input.Blur()
+	}
+}
+
+func runVim(m *model, args string) tea.Cmd {
+	switch args {
+	case "":
+		m.config.VimMode = !m.config.VimMode
+	case "on":
+		m.config.VimMode = true
+	case "off":
+		m.config.VimMode = false
+	default:
+		m.notice = "Usage: /vim [on|off]"
+		return nil
+	}
+	// Either way, typing goes on where it was
+	m.setVimMode(vimInsert)
+	if m.config.VimMode {
+		m.notice = "Vim mode on, Esc for normal mode and i to type again"
+	} else {
+		m.notice = "Vim mode off"
+	}
+	return nil
+}
+
+// handleVimKey takes a key as the current vim mode says, reporting whether it
+// did. Keys it leaves, such as Enter and Ctrl+C, work as usual.
+func (m *model) handleVimKey(msg tea.KeyMsg) (bool, tea.Cmd) {
+	key := msg.String()
+	if m.vim == vimInsert {
+		if key != "esc" {
+			return false, nil
+		}
+		m.setVimMode(vimNormal)
+		return true, nil
+	}
+	pending := m.vimPending
+	m.vimPending = ""
+	switch key {
+	case "i", "a":
+		m.setVimMode(vimInsert)
+	case ":":
+		m.setVimMode(vimInsert)
+		m.setInput("/")
+	case "j", "down":
+		m.selectNext()
+	case "k", "up":
+		m.selectPrevious()
+	case "g":
+		if pending != "g" {
+			m.vimPending = "g"
+			break
+		}
+		m.selectMessage(m.firstVisible)
+		m.viewport.GotoTop()
+	case "G":
+		m.selectMessage(len(m.messages) - 1)
+		m.viewport.GotoBottom()
+	case "ctrl+d":
+		m.viewport.HalfPageDown()
+	case "ctrl+u":
+		m.viewport.HalfPageUp()
+	case "/":
+		m.promptFind()
+	case "n":
+		m.findNext(1)
+	case "N":
+		m.findNext(-1)
+	case "v":
+		if m.vim == vimVisual {
+			m.setVimMode(vimNormal)
+			break
+		}
+		if m.selected < 0 {
+			m.selectPrevious()
+		}
+		if m.selected < 0 {
+			m.notice = "No message to select"
+			break
+		}
+		m.visualStart = m.selected
+		m.setVimMode(vimVisual)
+	case "y", "Y":
+		if m.vim == vimVisual {
+			m.copyVisual(key == "y")
+			m.setVimMode(vimNormal)
+			break
+		}
+		m.copyMessage(key == "y")
+	case "e":
+		if m.selected < 0 {
+			m.notice = "Select the message to edit first"
+			break
+		}
+		m.setVimMode(vimInsert)
+		m.editMessage()
+	case "d", "D":
+		m.confirmDelete(key == "D")
+	case "?":
+		return true, openHelp(m)
+	case "q":
+		return true, m.quit()
+	case "esc":
+		if m.vim == vimVisual {
+			m.setVimMode(vimNormal)
+			return true, nil
+		}
+		return false, nil
+	default:
+		// Nothing else is typed outside insert mode
+		return msg.Type == tea.KeyRunes || msg.Type == tea.KeySpace, nil
+	}
+	return true, nil
+}
+
+// visualRange returns the first and last message selected in visual mode.
+func (m model) visualRange() (int, int) {
+	start := min(max(m.visualStart, m.firstVisible), len(m.messages)-1)
+	return min(start, m.selected), max(start, m.selected)
+}
+
+// highlighted reports whether message i is shown selected: it is the
+// selected message, or in the range selected in visual mode.
+func (m model) highlighted(i int) bool {
+	if m.vim == vimVisual && m.selected >= 0 {
+		first, last := m.visualRange()
+		return i >= first && i <= last
+	}
+	return i == m.selected
+}
+
+// copyVisual copies the messages selected in visual mode, as raw markdown or
+// as plain text, separated by blank lines.
+func (m *model) copyVisual(raw bool) {
+	if m.selected < 0 {
+		m.notice = "No message to copy"
+		return
+	}
+	first, last := m.visualRange()
+	var texts []string
+	for _, msg := range m.messages[first : last+1] {
+		if msg.err != nil {
+			continue
+		}
+		if raw {
+			texts = append(texts, msg.content)
+		} else {
+			texts = append(texts, m.plainText(msg))
+		}
+	}
+	if len(texts) == 0 {
+		m.notice = "Failed turns cannot be copied"
+		return
+	}
+	to := writeClipboard(strings.Join(texts, "\n\n"))
+	m.notice = fmt.Sprintf("Copied %d messages to %s", len(texts), to)
+	if len(texts) == 1 {
+		m.notice = "Copied 1 message to " + to
+	}
+}